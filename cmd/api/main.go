@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
@@ -11,9 +12,10 @@ import (
 	"syscall"
 	"time"
 
+	"music-library/internal/config"
+	"music-library/internal/logging"
 	"music-library/internal/server"
-
-	_ "github.com/joho/godotenv/autoload"
+	"music-library/internal/tracing"
 )
 
 //	@title			Music library API
@@ -23,43 +25,99 @@ import (
 //	@host		localhost:4001
 //	@BasePath	/songs
 
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
+//	@description				Created with cmd/apikey; also accepted as an Authorization bearer token.
+
+//	@securityDefinitions.apikey	BearerAuth
+//	@in							header
+//	@name						Authorization
+//	@description				"Bearer " and a JWT from the company SSO.
+
 func main() {
-	setupLogger()
-	server := server.NewServer()
-	go gracefulShutdown(server)
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "optional YAML config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	setupLogger(cfg.LogLevel)
+	slog.Info("Loaded configuration", "config", cfg)
 
-	err := server.ListenAndServe()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("Can't set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Can't flush traces", "error", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server, stopServer, err := server.NewServer(cfg, server.WithShutdown(ctx))
+	if err != nil {
+		slog.Error("Can't start server", "error", err)
+		os.Exit(1)
+	}
+	done := make(chan struct{})
+	go gracefulShutdown(ctx, stop, server, stopServer, cfg.Server, done)
+
+	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("http server error: %s", err))
 	}
+	<-done
 }
 
-func gracefulShutdown(apiServer *http.Server) {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+// gracefulShutdown waits for a signal, keeps serving for the drain delay while
+// /readyz reports draining, waits for in-flight requests to finish, and then
+// for the background workers before the database is closed.
+func gracefulShutdown(ctx context.Context, stop context.CancelFunc, apiServer *http.Server, stopServer func(context.Context) error, cfg config.Server, done chan<- struct{}) {
+	defer close(done)
 
 	<-ctx.Done()
+	stop()
 
 	log.Println("shutting down gracefully, press Ctrl+C again to force")
+	time.Sleep(cfg.DrainDelay)
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := apiServer.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown with error: %v", err)
+		// closing the connections cancels the requests still running, and
+		// with them their database queries
+		apiServer.Close()
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := stopServer(ctx); err != nil {
+		log.Printf("Background workers didn't stop cleanly: %v", err)
 	}
 
 	log.Println("Server exiting")
 }
 
-func setupLogger() {
+func setupLogger(level string) {
 	logLevel := new(slog.LevelVar)
-	options := &slog.HandlerOptions{Level: logLevel}
+	options := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: logging.Redact}
 
-	if os.Getenv("LOG_LEVEL") == "debug" {
-		logLevel.Set(slog.LevelDebug)
+	// config only lets through levels UnmarshalText knows
+	logLevel.UnmarshalText([]byte(level))
+	if logLevel.Level() == slog.LevelDebug {
 		options.AddSource = true
 	}
-	logger := slog.New(slog.NewJSONHandler(os.Stderr, options))
+	logger := slog.New(tracing.NewLogHandler(slog.NewJSONHandler(os.Stderr, options)))
 	slog.SetDefault(logger)
 }
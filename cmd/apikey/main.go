@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/auth"
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/ratelimit"
+)
+
+const usage = `Usage: apikey [-config file.yaml] <command>
+
+Manages the API keys of the database configured by DB_DRIVER and the DB_* or
+SQLITE_PATH settings, or by the -config YAML file.
+
+Commands:
+  create -name NAME [-scopes LIST]
+             create a key with the comma separated scopes, songs:read by
+             default; the key is printed once and can't be shown again
+  list       list keys with their usage
+  revoke ID  revoke a key, requests using it are refused from then on
+  limit ID CLASS PER_MINUTE BURST
+             give a key its own rate limit for a route class
+  limit ID CLASS default
+             go back to the configured limit for the class
+
+Scopes: songs:read, songs:write, admin (grants every scope)
+Route classes: read, write, enrich (routes calling the music info service)
+`
+
+func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "optional YAML config file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadDatabase(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Database.Driver == "memory" {
+		log.Fatal("The memory database doesn't outlive this command, use postgres or sqlite")
+	}
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := audit.WithActor(context.Background(), actor())
+	switch cmd := args[0]; cmd {
+	case "create":
+		err = create(ctx, db, args[1:])
+	case "list":
+		err = list(ctx, db)
+	case "revoke":
+		err = revoke(ctx, db, args[1:])
+	case "limit":
+		err = limit(ctx, db, args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+func create(ctx context.Context, db database.Service, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "what the key is for, shown in logs")
+	scopeList := fs.String("scopes", auth.ScopeSongsRead, "comma separated scopes")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*name) == "" {
+		return fmt.Errorf("create needs -name")
+	}
+	scopes, err := auth.ParseScopes(*scopeList)
+	if err != nil {
+		return err
+	}
+
+	var key models.APIKey
+	var secret string
+	err = db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		if key, secret, err = auth.CreateKey(ctx, tx, *name, scopes); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, audit.APIKey, key.Id, nil, key)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created key %d %q with scopes %s. Store it now, it can't be shown again:\n", key.Id, key.Name, strings.Join(key.Scopes, ","))
+	fmt.Println(secret)
+	return nil
+}
+
+func limit(ctx context.Context, db database.Service, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("limit needs a key id, a route class and PER_MINUTE BURST or default")
+	}
+	id, class := args[0], args[1]
+	if !slices.Contains(ratelimit.Classes, class) {
+		return fmt.Errorf("unknown route class %q, must be one of %s", class, strings.Join(ratelimit.Classes, ", "))
+	}
+
+	before, err := findKey(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	limits := maps.Clone(before.RateLimits)
+	if limits == nil {
+		limits = make(map[string]models.RateLimit)
+	}
+
+	switch {
+	case len(args) == 3 && args[2] == "default":
+		delete(limits, class)
+	case len(args) == 4:
+		perMinute, err1 := strconv.Atoi(args[2])
+		burst, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil || perMinute <= 0 || burst <= 0 {
+			return fmt.Errorf("PER_MINUTE and BURST must be positive integers")
+		}
+		limits[class] = models.RateLimit{PerMinute: perMinute, Burst: burst}
+	default:
+		return fmt.Errorf("limit needs PER_MINUTE BURST or default after the route class")
+	}
+
+	err = changeKey(ctx, db, before, func(tx database.Service) error {
+		return tx.SetAPIKeyRateLimits(ctx, id, limits)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key %s rate limits: %s\n", id, formatLimits(limits))
+	return nil
+}
+
+func revoke(ctx context.Context, db database.Service, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("revoke needs exactly one key id")
+	}
+	before, err := findKey(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+	err = changeKey(ctx, db, before, func(tx database.Service) error {
+		return tx.RevokeAPIKey(ctx, args[0])
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key %s revoked\n", args[0])
+	return nil
+}
+
+func findKey(ctx context.Context, db database.Service, id string) (models.APIKey, error) {
+	keys, err := db.ListAPIKeys(ctx)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	i := slices.IndexFunc(keys, func(key models.APIKey) bool { return strconv.Itoa(key.Id) == id })
+	if i < 0 {
+		return models.APIKey{}, customErrors.ErrKeyNotFound
+	}
+	return keys[i], nil
+}
+
+// changeKey runs change in a transaction with the audit entry of the key as
+// it was before and after.
+func changeKey(ctx context.Context, db database.Service, before models.APIKey, change func(tx database.Service) error) error {
+	return db.WithTx(ctx, func(tx database.Service) error {
+		if err := change(tx); err != nil {
+			return err
+		}
+		after, err := findKey(ctx, tx, strconv.Itoa(before.Id))
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.APIKey, before.Id, before, after)
+	})
+}
+
+// actor attributes the changes to the user running the command.
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+func list(ctx context.Context, db database.Service) error {
+	keys, err := db.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tLIMITS\tCREATED\tLAST USED\tREQUESTS\tREVOKED")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", key.Id, key.Name, key.Prefix, strings.Join(key.Scopes, ","), formatLimits(key.RateLimits),
+			formatTime(&key.CreatedAt), formatTime(key.LastUsedAt), key.RequestCount, formatTime(key.RevokedAt))
+	}
+	return w.Flush()
+}
+
+// formatLimits shows overrides as class=per minute/burst.
+func formatLimits(limits map[string]models.RateLimit) string {
+	var parts []string
+	for _, class := range ratelimit.Classes {
+		if l, ok := limits[class]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%d/%d", class, l.PerMinute, l.Burst))
+		}
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
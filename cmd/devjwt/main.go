@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const usage = `Usage: devjwt <command> [flags]
+
+Stands in for the SSO when testing offline: makes a signing key with its
+JWKS, for OIDC_JWKS, and signs tokens with it.
+
+Commands:
+  keys   write a new P-256 signing key and the matching JWKS
+  token  print a signed token, e.g. devjwt token -sub alice -roles editor
+
+Run a command with -h for its flags.
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd := os.Args[1]; cmd {
+	case "keys":
+		err = keys(os.Args[2:])
+	case "token":
+		err = token(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func keys(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	keyFile := fs.String("key", "dev-signing-key.pem", "where to write the private key")
+	jwksFile := fs.String("jwks", "dev-jwks.json", "where to write the JWKS")
+	fs.Parse(args)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+
+	kid, err := keyId(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		return err
+	}
+	point := pub.Bytes()[1:] // uncompressed: 0x04, then x and y
+	jwks, err := json.MarshalIndent(map[string]any{"keys": []map[string]string{{
+		"kty": "EC",
+		"crv": "P-256",
+		"use": "sig",
+		"alg": "ES256",
+		"kid": kid,
+		"x":   base64.RawURLEncoding.EncodeToString(point[:32]),
+		"y":   base64.RawURLEncoding.EncodeToString(point[32:]),
+	}}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*jwksFile, append(jwks, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s and %s, set OIDC_JWKS=%s\n", *keyFile, *jwksFile, *jwksFile)
+	return nil
+}
+
+func token(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	keyFile := fs.String("key", "dev-signing-key.pem", "private key written by devjwt keys")
+	issuer := fs.String("iss", "http://localhost/dev-sso", "issuer, must match OIDC_ISSUER")
+	audience := fs.String("aud", "music-library", "audience, empty for none")
+	subject := fs.String("sub", "", "subject, required")
+	name := fs.String("name", "", "preferred_username, the subject by default")
+	roles := fs.String("roles", "viewer", "comma separated values of the roles claim")
+	claim := fs.String("roles-claim", "roles", "claim carrying the roles")
+	ttl := fs.Duration("ttl", time.Hour, "lifetime of the token")
+	fs.Parse(args)
+
+	if *subject == "" {
+		return errors.New("token needs -sub")
+	}
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s holds no PEM key", *keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("%s is not an EC key", *keyFile)
+	}
+	kid, err := keyId(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                *issuer,
+		"sub":                *subject,
+		"preferred_username": *subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(*ttl).Unix(),
+		*claim:               strings.Split(*roles, ","),
+	}
+	if *name != "" {
+		claims["preferred_username"] = *name
+	}
+	if *audience != "" {
+		claims["aud"] = *audience
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	t.Header["kid"] = kid
+	signed, err := t.SignedString(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(signed)
+	return nil
+}
+
+// keyId derives the kid from the public key, so keys and tokens agree on it
+// without storing it anywhere.
+func keyId(pub *ecdsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"music-library/internal/fakeinfo"
+)
+
+func main() {
+	addr := flag.String("addr", ":5001", "listen address")
+	fixtures := flag.String("fixtures", "", "JSON or YAML fixture file (default: bundled fixtures)")
+	latency := flag.Duration("latency", 0, "delay added to every response")
+	errorRate := flag.Float64("error-rate", 0, "share of requests answered with 500, 0..1")
+	notFoundRate := flag.Float64("not-found-rate", 0, "share of requests answered with 404, 0..1")
+	flag.Parse()
+
+	songs := fakeinfo.DefaultFixtures()
+	if *fixtures != "" {
+		var err error
+		songs, err = fakeinfo.LoadFixtures(*fixtures)
+		if err != nil {
+			log.Fatalf("Can't load fixtures: %v", err)
+		}
+	}
+
+	server := &http.Server{
+		Addr: *addr,
+		Handler: fakeinfo.New(songs, fakeinfo.Options{
+			Latency:      *latency,
+			ErrorRate:    *errorRate,
+			NotFoundRate: *notFoundRate,
+		}),
+		ReadTimeout: 10 * time.Second,
+	}
+
+	slog.Info("Fake music info service listening", "addr", *addr, "songs", len(songs))
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
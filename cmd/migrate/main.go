@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+)
+
+const usage = `Usage: migrate [-config file.yaml] <command>
+
+Applies the embedded migrations to the database configured by DB_DRIVER and
+the DB_* or SQLITE_PATH settings, or by the -config YAML file.
+
+Commands:
+  status     print the current and latest schema version
+  up         apply all pending migrations
+  down N     roll back the last N migrations
+  goto V     migrate up or down to version V
+  force V    mark version V as applied and clean, after a failed migration
+             has been repaired by hand
+`
+
+func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "optional YAML config file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadDatabase(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mg, err := database.OpenMigrator(cfg.Database)
+	if err != nil {
+		log.Fatalf("Can't open database: %v", err)
+	}
+	defer mg.Close()
+
+	switch cmd := args[0]; cmd {
+	case "status":
+	case "up":
+		err = mg.Up()
+	case "down":
+		err = mg.Down(intArg(args))
+	case "goto":
+		err = mg.Goto(uint(intArg(args)))
+	case "force":
+		err = mg.Force(intArg(args))
+	default:
+		mg.Close()
+		log.Fatalf("Unknown command %q", cmd)
+	}
+	if err != nil {
+		mg.Close()
+		log.Fatalf("%s failed: %v", args[0], err)
+	}
+
+	status, err := mg.Status()
+	if err != nil {
+		mg.Close()
+		log.Fatalf("Can't read schema version: %v", err)
+	}
+	fmt.Printf("version %d of %d", status.Version, status.Latest)
+	if status.Dirty {
+		fmt.Print(", dirty")
+	}
+	if status.Version < status.Latest {
+		fmt.Printf(", %d pending", status.Latest-status.Version)
+	}
+	fmt.Println()
+}
+
+func intArg(args []string) int {
+	if len(args) != 2 {
+		log.Fatalf("%s needs exactly one number", args[0])
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		log.Fatalf("%s needs a non-negative number, got %q", args[0], args[1])
+	}
+	return n
+}
@@ -0,0 +1,99 @@
+// Package audit builds the entries of the append-only audit log. An entry
+// is written through the transaction making the change it describes, so
+// both are committed or rolled back together.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"music-library/internal/models"
+)
+
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// Resources changes are recorded for.
+const (
+	Song     = "song"
+	Artist   = "artist"
+	APIKey   = "apiKey"
+	Job      = "job"
+	Webhook  = "webhook"
+	Delivery = "webhookDelivery"
+	// Refresh is a request to refresh song metadata, by its target.
+	Refresh = "refresh"
+)
+
+// Recorder stores entries. Every database.Service is one; pass the one a
+// WithTx func gets.
+type Recorder interface {
+	RecordAudit(ctx context.Context, entry models.AuditEntry) error
+}
+
+type actorKey struct{}
+
+type requestKey struct{}
+
+type request struct {
+	id string
+	ip string
+}
+
+// WithActor returns ctx attributing the changes made under it to actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// WithRequest returns ctx carrying the id and client address of the HTTP
+// request changes made under it come from.
+func WithRequest(ctx context.Context, id, ip string) context.Context {
+	return context.WithValue(ctx, requestKey{}, request{id: id, ip: ip})
+}
+
+// Record writes an entry for a change to the resource with the given id,
+// made by the actor of ctx. before and after are stored as JSON; pass nil
+// for the side that doesn't exist.
+func Record(ctx context.Context, r Recorder, action, resource string, id any, before, after any) error {
+	entry := models.AuditEntry{
+		Actor:      actor(ctx),
+		Action:     action,
+		Resource:   resource,
+		ResourceId: fmt.Sprint(id),
+	}
+	if req, ok := ctx.Value(requestKey{}).(request); ok {
+		entry.RequestId, entry.SourceIP = req.id, req.ip
+	}
+
+	var err error
+	if entry.Before, err = marshal(before); err != nil {
+		return err
+	}
+	if entry.After, err = marshal(after); err != nil {
+		return err
+	}
+	return r.RecordAudit(ctx, entry)
+}
+
+// actor is who ctx attributes changes to: "anonymous" for requests without
+// credentials and "system" for work no request asked for.
+func actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	if _, ok := ctx.Value(requestKey{}).(request); ok {
+		return "anonymous"
+	}
+	return "system"
+}
+
+func marshal(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
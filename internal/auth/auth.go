@@ -0,0 +1,142 @@
+// Package auth identifies callers by API key or by a JWT from the company
+// SSO. A key is shown once when it is created; the database only keeps its
+// SHA-256 hash, which is enough for random keys of this length and lets a
+// key be looked up by its hash. Token holders get scopes through roles.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/models"
+)
+
+const (
+	ScopeSongsRead  = "songs:read"
+	ScopeSongsWrite = "songs:write"
+	// ScopeAdmin grants every other scope too.
+	ScopeAdmin = "admin"
+)
+
+var Scopes = []string{ScopeSongsRead, ScopeSongsWrite, ScopeAdmin}
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// roleScopes are the scopes each role grants.
+var roleScopes = map[string][]string{
+	RoleViewer: {ScopeSongsRead},
+	RoleEditor: {ScopeSongsRead, ScopeSongsWrite},
+	RoleAdmin:  {ScopeAdmin},
+}
+
+// keyPrefix marks our keys, so a leaked one is easy to recognize.
+const keyPrefix = "mlk_"
+
+// prefixLength is how much of a key is stored in the clear to tell keys apart.
+const prefixLength = len(keyPrefix) + 8
+
+// Principal is the caller a request was authenticated as.
+type Principal struct {
+	// Name identifies the caller in logs and on the songs it writes: "key:"
+	// and the key's name, or the token's username.
+	Name string
+	// KeyId is set for API keys, Subject and Roles for tokens.
+	KeyId   int
+	Subject string
+	Roles   []string
+	Scopes  []string
+	// RateLimits are the key's own limits by route class, if it has any.
+	RateLimits map[string]models.RateLimit
+}
+
+// Has reports whether p may use routes requiring scope.
+func (p Principal) Has(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// ParseScopes splits a comma separated scope list, rejecting unknown scopes.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, must be one of %s", scope, strings.Join(Scopes, ", "))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// IsAPIKey tells our keys from tokens sent the same way.
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, keyPrefix)
+}
+
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateKey stores a new key and returns it together with the key itself,
+// which can't be recovered later.
+func CreateKey(ctx context.Context, keys database.KeyStore, name string, scopes []string) (models.APIKey, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.APIKey{}, "", err
+	}
+	secret := keyPrefix + hex.EncodeToString(b)
+
+	key, err := keys.CreateAPIKey(ctx, models.APIKey{Name: name, Prefix: secret[:prefixLength], Scopes: scopes}, HashKey(secret))
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// Authenticate looks up the key a request presented. Unknown and revoked
+// keys are customErrors.ErrUnauthorized; other errors are the store's.
+func Authenticate(ctx context.Context, keys database.KeyStore, secret string) (Principal, error) {
+	if !IsAPIKey(secret) {
+		return Principal{}, customErrors.ErrUnauthorized
+	}
+	key, err := keys.GetAPIKeyByHash(ctx, HashKey(secret))
+	if err != nil {
+		if errors.Is(err, customErrors.ErrKeyNotFound) {
+			return Principal{}, customErrors.ErrUnauthorized
+		}
+		return Principal{}, err
+	}
+	return Principal{Name: "key:" + key.Name, KeyId: key.Id, Scopes: key.Scopes, RateLimits: key.RateLimits}, nil
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksMaxAge is how long a fetched key set is used before it is
+	// fetched again.
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh keeps tokens with unknown key ids from making us
+	// fetch the key set on every request.
+	jwksMinRefresh = time.Minute
+)
+
+// keySet holds the public keys of a JWKS, read from a URL or a file. It is
+// read again when it gets old or a token names a key it doesn't have, which
+// is how the issuer's key rotations get picked up.
+type keySet struct {
+	source string
+	client *http.Client
+
+	// group lets concurrent requests for a missing key share one fetch.
+	group   singleflight.Group
+	mu      sync.Mutex
+	keys    map[string]any
+	fetched time.Time
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newKeySet(source string) *keySet {
+	return &keySet{
+		source: source,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key returns the key with the given id, or the only key if kid is empty.
+// Requests for keys that are there don't wait for a refresh in flight.
+func (ks *keySet) key(ctx context.Context, kid string) (any, error) {
+	ks.mu.Lock()
+	key, ok := ks.lookup(kid)
+	age := time.Since(ks.fetched)
+	ks.mu.Unlock()
+
+	if (ok && age <= jwksMaxAge) || age < jwksMinRefresh {
+		if !ok {
+			return nil, fmt.Errorf("no key %q in the key set", kid)
+		}
+		return key, nil
+	}
+
+	// callers share one fetch, which the first of them cancelling mustn't end
+	_, err, _ := ks.group.Do("", func() (any, error) {
+		return nil, ks.refresh(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		if ok {
+			// an old key beats refusing every token while the issuer is down
+			return key, nil
+		}
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key, ok = ks.lookup(kid); !ok {
+		return nil, fmt.Errorf("no key %q in the key set", kid)
+	}
+	return key, nil
+}
+
+func (ks *keySet) lookup(kid string) (any, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// refresh reads the key set again and swaps it in, holding mu only for
+// that, so a slow issuer holds up no one who has their key.
+func (ks *keySet) refresh(ctx context.Context) error {
+	keys, err := ks.fetch(ctx)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.fetched = time.Now()
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	return nil
+}
+
+func (ks *keySet) fetch(ctx context.Context) (map[string]any, error) {
+	data, err := ks.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't read JWKS %s: %w", ks.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse JWKS %s: %w", ks.source, err)
+	}
+	return keys, nil
+}
+
+func (ks *keySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(ks.source, "http://") && !strings.HasPrefix(ks.source, "https://") {
+		return os.ReadFile(ks.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWKS returns the RSA and EC signing keys of a key set by key id.
+// Keys of other types or for encryption are skipped.
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key any
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k)
+		case "EC":
+			key, err = ecKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA or EC signing keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid e: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func ecKey(k jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	switch k.Crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, check = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("invalid EC key")
+	}
+	// ecdh rejects points that aren't on the curve
+	if _, err := check.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
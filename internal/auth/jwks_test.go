@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a key set holding a fresh P-256 key under each of kids,
+// calling before, if set, ahead of every answer.
+func jwksServer(t *testing.T, before func(), kids ...string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	set := `{"keys":[`
+	for i, kid := range kids {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := key.PublicKey.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		point := pub.Bytes()[1:]
+		if i > 0 {
+			set += ","
+		}
+		set += fmt.Sprintf(`{"kid":%q,"kty":"EC","crv":"P-256","x":%q,"y":%q}`, kid,
+			base64.RawURLEncoding.EncodeToString(point[:32]), base64.RawURLEncoding.EncodeToString(point[32:]))
+	}
+	set += "]}"
+
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if before != nil {
+			before()
+		}
+		w.Write([]byte(set))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func TestKeySetRotation(t *testing.T) {
+	srv, fetches := jwksServer(t, nil, "old", "new")
+	ks := newKeySet(srv.URL)
+	ks.keys = map[string]any{"old": "old key"}
+	ks.fetched = time.Now()
+
+	if _, err := ks.key(context.Background(), "new"); err == nil {
+		t.Errorf("key(new) right after a fetch succeeded, want no fetch within jwksMinRefresh")
+	}
+	ks.fetched = time.Now().Add(-2 * jwksMinRefresh)
+	if _, err := ks.key(context.Background(), "new"); err != nil {
+		t.Errorf("key(new) once jwksMinRefresh passed: %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times, want 1", n)
+	}
+}
+
+func TestKeySetIssuerDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	ks := newKeySet(srv.URL)
+	ks.keys = map[string]any{"old": "old key"}
+	ks.fetched = time.Now().Add(-2 * jwksMaxAge)
+
+	if key, err := ks.key(context.Background(), "old"); err != nil || key != "old key" {
+		t.Errorf("key(old) with the issuer down = %v, %v; want the stale key", key, err)
+	}
+	ks.fetched = time.Now().Add(-2 * jwksMinRefresh)
+	if _, err := ks.key(context.Background(), "other"); err == nil {
+		t.Errorf("key(other) with the issuer down succeeded")
+	}
+}
+
+// TestKeySetSlowIssuer checks that a fetch for a missing key holds up
+// neither requests for keys already there nor, beyond the one fetch, other
+// requests for the missing key.
+func TestKeySetSlowIssuer(t *testing.T) {
+	release := make(chan struct{})
+	srv, fetches := jwksServer(t, func() { <-release }, "old", "new")
+	ks := newKeySet(srv.URL)
+	ks.keys = map[string]any{"old": "old key"}
+	ks.fetched = time.Now().Add(-2 * jwksMinRefresh)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.key(context.Background(), "new")
+			errs <- err
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := ks.key(context.Background(), "old")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("key(old) during a fetch: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("key(old) waited for the fetch of another key")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("key(new) after the fetch: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times for concurrent requests, want 1", n)
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// leeway allows for clocks a little out of step with the issuer's.
+const leeway = time.Minute
+
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Verifier checks SSO tokens against the issuer's key set and maps the
+// roles claim to our roles.
+type Verifier struct {
+	cfg    config.OIDC
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+// NewVerifier reads the key set. A file has to be readable right away; a
+// URL that can't be fetched yet is retried when the first token arrives.
+func NewVerifier(ctx context.Context, cfg config.OIDC) (*Verifier, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v := &Verifier{
+		cfg:    cfg,
+		keys:   newKeySet(cfg.JWKS),
+		parser: jwt.NewParser(opts...),
+	}
+
+	if err := v.keys.refresh(ctx); err != nil {
+		if !strings.Contains(cfg.JWKS, "://") {
+			return nil, err
+		}
+		slog.Warn("Can't fetch the SSO key set yet", "error", err)
+	}
+	return v, nil
+}
+
+// Verify returns the principal of a valid token. Invalid tokens are
+// customErrors.ErrUnauthorized, wrapped with the reason.
+func (v *Verifier) Verify(ctx context.Context, token string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", customErrors.ErrUnauthorized, err)
+	}
+
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return Principal{}, fmt.Errorf("%w: token has no subject", customErrors.ErrUnauthorized)
+	}
+	p := Principal{Name: sub, Subject: sub}
+	for _, claim := range []string{"preferred_username", "email"} {
+		if name, _ := claims[claim].(string); name != "" {
+			p.Name = name
+			break
+		}
+	}
+
+	values := claimValues(claims, v.cfg.RolesClaim)
+	for role, granted := range map[string][]string{RoleViewer: v.cfg.ViewerRoles, RoleEditor: v.cfg.EditorRoles, RoleAdmin: v.cfg.AdminRoles} {
+		if slices.ContainsFunc(values, func(value string) bool { return slices.Contains(granted, value) }) {
+			p.Roles = append(p.Roles, role)
+		}
+	}
+	slices.Sort(p.Roles)
+	for _, role := range p.Roles {
+		p.Scopes = append(p.Scopes, roleScopes[role]...)
+	}
+	return p, nil
+}
+
+// claimValues reads a claim by its dotted path, as a list of strings or a
+// space separated string like the standard scope claim.
+func claimValues(claims jwt.MapClaims, path string) []string {
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
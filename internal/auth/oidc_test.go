@@ -0,0 +1,189 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"music-library/internal/auth"
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	issuer   = "https://sso.example.com/realms/music"
+	audience = "music-library"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// jwks is the key set holding the public halves of keys by key id.
+func jwks(t *testing.T, keys map[string]*ecdsa.PrivateKey) []byte {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		pub, err := key.PublicKey.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		point := pub.Bytes()[1:]
+		set.Keys = append(set.Keys, map[string]string{
+			"kid": kid, "kty": "EC", "use": "sig", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(point[:32]),
+			"y": base64.RawURLEncoding.EncodeToString(point[32:]),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func claims(overrides jwt.MapClaims) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"iss":                issuer,
+		"aud":                audience,
+		"sub":                "u-1",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+		"realm_access":       map[string]any{"roles": []string{"music-editor"}},
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(c, name)
+			continue
+		}
+		c[name] = value
+	}
+	return c
+}
+
+func oidcConfig(jwks string) config.OIDC {
+	return config.OIDC{
+		JWKS:        jwks,
+		Issuer:      issuer,
+		Audience:    audience,
+		RolesClaim:  "realm_access.roles",
+		ViewerRoles: []string{"music-viewer"},
+		EditorRoles: []string{"music-editor"},
+		AdminRoles:  []string{"music-admin", "ops"},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key, other := newKey(t), newKey(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks(t, map[string]*ecdsa.PrivateKey{"k1": key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := auth.NewVerifier(context.Background(), oidcConfig(path))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	es256 := jwt.SigningMethodES256
+	tests := []struct {
+		name  string
+		token string
+		// reason is part of the error of a rejected token
+		reason string
+		want   auth.Principal
+	}{
+		{
+			name:  "editor",
+			token: sign(t, es256, "k1", key, claims(nil)),
+			want:  auth.Principal{Name: "alice", Subject: "u-1", Roles: []string{auth.RoleEditor}, Scopes: []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}},
+		},
+		{
+			name:  "several roles, named by email",
+			token: sign(t, es256, "k1", key, claims(jwt.MapClaims{"preferred_username": nil, "email": "bob@example.com", "realm_access": map[string]any{"roles": []string{"ops", "music-viewer", "other"}}})),
+			want:  auth.Principal{Name: "bob@example.com", Subject: "u-1", Roles: []string{auth.RoleAdmin, auth.RoleViewer}, Scopes: []string{auth.ScopeAdmin, auth.ScopeSongsRead}},
+		},
+		{
+			name:  "no roles, named by subject",
+			token: sign(t, es256, "", key, claims(jwt.MapClaims{"preferred_username": nil, "realm_access": nil})),
+			want:  auth.Principal{Name: "u-1", Subject: "u-1"},
+		},
+		{name: "other key", token: sign(t, es256, "k1", other, claims(nil)), reason: "signature is invalid"},
+		{name: "unknown kid", token: sign(t, es256, "k2", key, claims(nil)), reason: `no key "k2"`},
+		{name: "hmac", token: sign(t, jwt.SigningMethodHS256, "k1", []byte("secret"), claims(nil)), reason: "signing method HS256 is invalid"},
+		{name: "none", token: sign(t, jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType, claims(nil)), reason: "signing method none is invalid"},
+		{name: "issuer", token: sign(t, es256, "k1", key, claims(jwt.MapClaims{"iss": "https://evil.example.com"})), reason: "invalid issuer"},
+		{name: "audience", token: sign(t, es256, "k1", key, claims(jwt.MapClaims{"aud": "other"})), reason: "invalid audience"},
+		{name: "expired", token: sign(t, es256, "k1", key, claims(jwt.MapClaims{"exp": time.Now().Add(-2 * time.Minute).Unix()})), reason: "token is expired"},
+		{name: "no expiry", token: sign(t, es256, "k1", key, claims(jwt.MapClaims{"exp": nil})), reason: "exp claim is required"},
+		{name: "no subject", token: sign(t, es256, "k1", key, claims(jwt.MapClaims{"sub": nil})), reason: "token has no subject"},
+		{name: "garbage", token: "not.a.token", reason: "token is malformed"},
+	}
+
+	for _, tt := range tests {
+		got, err := v.Verify(context.Background(), tt.token)
+		if tt.reason != "" {
+			if !errors.Is(err, customErrors.ErrUnauthorized) || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("%s: Verify error = %v, want unauthorized because %s", tt.name, err, tt.reason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Verify: %v", tt.name, err)
+			continue
+		}
+		if got.Name != tt.want.Name || got.Subject != tt.want.Subject || !slices.Equal(got.Roles, tt.want.Roles) || !slices.Equal(got.Scopes, tt.want.Scopes) {
+			t.Errorf("%s: Verify = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyScopeClaim(t *testing.T) {
+	key := newKey(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks(t, map[string]*ecdsa.PrivateKey{"k1": key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := oidcConfig(path)
+	cfg.RolesClaim = "scope"
+	v, err := auth.NewVerifier(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	p, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodES256, "k1", key, claims(jwt.MapClaims{"scope": "openid music-viewer"})))
+	if err != nil || !slices.Equal(p.Roles, []string{auth.RoleViewer}) {
+		t.Errorf("Verify = %+v, %v; want the viewer role from the space separated claim", p, err)
+	}
+}
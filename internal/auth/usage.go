@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"music-library/internal/database"
+)
+
+// Usage counts requests per key in memory and writes the counts out every
+// interval, so authenticating a request doesn't cost a write. Counts not yet
+// flushed are lost if the process dies; Stop flushes them on shutdown.
+type Usage struct {
+	keys     database.KeyStore
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[int]keyUsage
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type keyUsage struct {
+	requests int64
+	lastUsed time.Time
+}
+
+func NewUsage(keys database.KeyStore, interval time.Duration) *Usage {
+	return &Usage{
+		keys:     keys,
+		interval: interval,
+		pending:  make(map[int]keyUsage),
+	}
+}
+
+func (u *Usage) Record(keyId int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	usage := u.pending[keyId]
+	usage.requests++
+	usage.lastUsed = time.Now()
+	u.pending[keyId] = usage
+}
+
+func (u *Usage) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+
+	u.wg.Add(1)
+	go u.run(ctx)
+}
+
+// Stop ends the flush loop and writes what is left before returning, so it
+// has to run before the key store is closed.
+func (u *Usage) Stop() {
+	if u.cancel == nil {
+		return
+	}
+	u.cancel()
+	u.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	u.flush(ctx)
+}
+
+func (u *Usage) run(ctx context.Context) {
+	defer u.wg.Done()
+
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Stop waits for this flush, cancelling it would lose the counts
+			u.flush(context.WithoutCancel(ctx))
+		}
+	}
+}
+
+// flush writes the pending counts, putting back the ones that fail so the
+// next flush retries them.
+func (u *Usage) flush(ctx context.Context) {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[int]keyUsage)
+	u.mu.Unlock()
+
+	for id, usage := range pending {
+		if err := u.keys.RecordAPIKeyUsage(ctx, id, usage.requests, usage.lastUsed); err != nil {
+			slog.Warn("Can't record API key usage", "key", id, "requests", usage.requests, "error", err)
+			u.putBack(id, usage)
+		}
+	}
+}
+
+func (u *Usage) putBack(keyId int, usage keyUsage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	newer := u.pending[keyId]
+	newer.requests += usage.requests
+	if newer.lastUsed.Before(usage.lastUsed) {
+		newer.lastUsed = usage.lastUsed
+	}
+	u.pending[keyId] = newer
+}
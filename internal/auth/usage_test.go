@@ -0,0 +1,82 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"music-library/internal/auth"
+	"music-library/internal/database"
+	"music-library/internal/models"
+)
+
+func TestUsageStopFlushes(t *testing.T) {
+	ctx := context.Background()
+	db := database.NewMemory()
+	key, err := db.CreateAPIKey(ctx, models.APIKey{Name: "ci", Prefix: "ml_test"}, "hash")
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	usage := auth.NewUsage(db, time.Hour)
+	usage.Start()
+	for range 3 {
+		usage.Record(key.Id)
+	}
+	usage.Stop()
+
+	keys, err := db.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].RequestCount != 3 || keys[0].LastUsedAt == nil {
+		t.Errorf("key after Stop is %+v, want 3 requests and a last use", keys)
+	}
+}
+
+// flakyKeys fails the usage writes until up is set.
+type flakyKeys struct {
+	database.KeyStore
+	up       atomic.Bool
+	failures atomic.Int32
+}
+
+func (k *flakyKeys) RecordAPIKeyUsage(ctx context.Context, id int, requests int64, lastUsed time.Time) error {
+	if !k.up.Load() {
+		k.failures.Add(1)
+		return errors.New("database is down")
+	}
+	return k.KeyStore.RecordAPIKeyUsage(ctx, id, requests, lastUsed)
+}
+
+func TestUsageRetriesFailedFlush(t *testing.T) {
+	ctx := context.Background()
+	db := database.NewMemory()
+	key, err := db.CreateAPIKey(ctx, models.APIKey{Name: "ci", Prefix: "ml_test"}, "hash")
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	keys := &flakyKeys{KeyStore: db}
+
+	usage := auth.NewUsage(keys, time.Millisecond)
+	usage.Start()
+	usage.Record(key.Id)
+	usage.Record(key.Id)
+	deadline := time.Now().Add(5 * time.Second)
+	for keys.failures.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	keys.up.Store(true)
+	usage.Record(key.Id)
+	usage.Stop()
+
+	list, err := db.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(list) != 1 || list[0].RequestCount != 3 {
+		t.Errorf("key after a failed flush is %+v, want all 3 requests", list)
+	}
+}
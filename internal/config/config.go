@@ -0,0 +1,540 @@
+// Package config loads the service settings. Values come from, in rising
+// precedence, the defaults below, an optional YAML file, a .env file and the
+// environment. Every field names its environment variable in the env tag and
+// its YAML key in the yaml tag.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Env      string `env:"APP_ENV" yaml:"env"`
+	LogLevel string `env:"LOG_LEVEL" yaml:"logLevel"`
+
+	Server     Server     `yaml:"server"`
+	Database   Database   `yaml:"database"`
+	MusicAPI   MusicAPI   `yaml:"musicAPI"`
+	Cache      Cache      `yaml:"cache"`
+	Enrichment Enrichment `yaml:"enrichment"`
+	Tracing    Tracing    `yaml:"tracing"`
+	Auth       Auth       `yaml:"auth"`
+	RateLimit  RateLimit  `yaml:"rateLimit"`
+	Webhooks   Webhooks   `yaml:"webhooks"`
+	Outbox     Outbox     `yaml:"outbox"`
+	Events     Events     `yaml:"events"`
+	GraphQL    GraphQL    `yaml:"graphql"`
+}
+
+type Server struct {
+	Port int `env:"PORT" yaml:"port"`
+	// GRPCPort serves the gRPC API, 0 turns it off.
+	GRPCPort        int           `env:"GRPC_PORT" yaml:"grpcPort"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" yaml:"shutdownTimeout"`
+	// DrainDelay is how long /readyz fails before shutdown stops accepting
+	// connections, giving the orchestrator time to stop sending traffic.
+	DrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" yaml:"drainDelay"`
+	// ReadyCheckUpstream makes /readyz also require the music info service.
+	ReadyCheckUpstream bool `env:"READY_CHECK_UPSTREAM" yaml:"readyCheckUpstream"`
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For is
+	// believed; the client IP of other requests is the peer address.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" yaml:"trustedProxies"`
+}
+
+type Database struct {
+	// Driver is postgres, sqlite or memory.
+	Driver   string `env:"DB_DRIVER" yaml:"driver"`
+	Host     string `env:"DB_HOST" yaml:"host"`
+	Port     int    `env:"DB_PORT" yaml:"port"`
+	Name     string `env:"DB_DATABASE" yaml:"name"`
+	User     string `env:"DB_USERNAME" yaml:"user"`
+	Password Secret `env:"DB_PASSWORD" yaml:"password"`
+	Schema   string `env:"DB_SCHEMA" yaml:"schema"`
+
+	SQLitePath string `env:"SQLITE_PATH" yaml:"sqlitePath"`
+
+	// QueryTimeout bounds every Service call, 0 disables it.
+	QueryTimeout time.Duration `env:"DB_QUERY_TIMEOUT" yaml:"queryTimeout"`
+	AutoMigrate  bool          `env:"DB_AUTO_MIGRATE" yaml:"autoMigrate"`
+
+	// Pool settings left at 0 keep pgxpool's defaults.
+	MaxConns          int           `env:"DB_MAX_CONNS" yaml:"maxConns"`
+	MinConns          int           `env:"DB_MIN_CONNS" yaml:"minConns"`
+	MaxConnLifetime   time.Duration `env:"DB_MAX_CONN_LIFETIME" yaml:"maxConnLifetime"`
+	MaxConnIdleTime   time.Duration `env:"DB_MAX_CONN_IDLE_TIME" yaml:"maxConnIdleTime"`
+	HealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD" yaml:"healthCheckPeriod"`
+	ConnectTimeout    time.Duration `env:"DB_CONNECT_TIMEOUT" yaml:"connectTimeout"`
+}
+
+type MusicAPI struct {
+	URL              string        `env:"EXTERNAL_API_URL" yaml:"url"`
+	Timeout          time.Duration `env:"MUSIC_API_TIMEOUT" yaml:"timeout"`
+	MaxRetries       int           `env:"MUSIC_API_MAX_RETRIES" yaml:"maxRetries"`
+	BaseBackoff      time.Duration `env:"MUSIC_API_BASE_BACKOFF" yaml:"baseBackoff"`
+	MaxBackoff       time.Duration `env:"MUSIC_API_MAX_BACKOFF" yaml:"maxBackoff"`
+	BreakerThreshold int           `env:"MUSIC_API_BREAKER_THRESHOLD" yaml:"breakerThreshold"`
+	BreakerCooldown  time.Duration `env:"MUSIC_API_BREAKER_COOLDOWN" yaml:"breakerCooldown"`
+}
+
+type Cache struct {
+	TTL         time.Duration `env:"METADATA_CACHE_TTL" yaml:"ttl"`
+	NegativeTTL time.Duration `env:"METADATA_CACHE_NEGATIVE_TTL" yaml:"negativeTTL"`
+	MaxEntries  int           `env:"METADATA_CACHE_MAX_ENTRIES" yaml:"maxEntries"`
+}
+
+type Enrichment struct {
+	Workers int `env:"ENRICHMENT_WORKERS" yaml:"workers"`
+	// RefreshInterval 0 disables scheduled refreshes.
+	RefreshInterval time.Duration `env:"REFRESH_INTERVAL" yaml:"refreshInterval"`
+	RefreshMaxAge   time.Duration `env:"REFRESH_MAX_AGE" yaml:"refreshMaxAge"`
+}
+
+type Tracing struct {
+	// Exporter is none, otlp or stdout.
+	Exporter    string `env:"OTEL_TRACES_EXPORTER" yaml:"exporter"`
+	ServiceName string `env:"OTEL_SERVICE_NAME" yaml:"serviceName"`
+	// Endpoint is the OTLP/HTTP collector URL; empty leaves it to the
+	// exporter's defaults.
+	Endpoint    string  `env:"OTEL_EXPORTER_OTLP_ENDPOINT" yaml:"endpoint"`
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" yaml:"sampleRatio"`
+}
+
+type Auth struct {
+	// Enabled requires an API key on every route but the health, metrics and
+	// docs ones.
+	Enabled bool `env:"AUTH_ENABLED" yaml:"enabled"`
+	// AnonymousRead lets requests without a key use songs:read routes.
+	AnonymousRead bool `env:"AUTH_ANONYMOUS_READ" yaml:"anonymousRead"`
+	// UsageFlushInterval is how often key usage counts are written out.
+	UsageFlushInterval time.Duration `env:"AUTH_USAGE_FLUSH_INTERVAL" yaml:"usageFlushInterval"`
+
+	OIDC OIDC `yaml:"oidc"`
+}
+
+// OIDC accepts JWTs from the company SSO as bearer tokens once JWKS is set.
+type OIDC struct {
+	// JWKS is the URL of the issuer's key set, or the path of a local copy.
+	JWKS     string `env:"OIDC_JWKS" yaml:"jwks"`
+	Issuer   string `env:"OIDC_ISSUER" yaml:"issuer"`
+	Audience string `env:"OIDC_AUDIENCE" yaml:"audience"`
+	// RolesClaim holds the caller's roles or groups; dots reach into nested
+	// claims, as in realm_access.roles.
+	RolesClaim string `env:"OIDC_ROLES_CLAIM" yaml:"rolesClaim"`
+	// The claim values granting each role, comma separated in the env.
+	ViewerRoles []string `env:"OIDC_VIEWER_ROLES" yaml:"viewerRoles"`
+	EditorRoles []string `env:"OIDC_EDITOR_ROLES" yaml:"editorRoles"`
+	AdminRoles  []string `env:"OIDC_ADMIN_ROLES" yaml:"adminRoles"`
+}
+
+// RateLimit sets token buckets per client, by API key, token subject or IP,
+// and route class. Keys can override them with cmd/apikey.
+type RateLimit struct {
+	Enabled bool `env:"RATE_LIMIT_ENABLED" yaml:"enabled"`
+	// Store is memory, or postgres to share buckets between instances.
+	Store           string `env:"RATE_LIMIT_STORE" yaml:"store"`
+	ReadPerMinute   int    `env:"RATE_LIMIT_READ_PER_MINUTE" yaml:"readPerMinute"`
+	ReadBurst       int    `env:"RATE_LIMIT_READ_BURST" yaml:"readBurst"`
+	WritePerMinute  int    `env:"RATE_LIMIT_WRITE_PER_MINUTE" yaml:"writePerMinute"`
+	WriteBurst      int    `env:"RATE_LIMIT_WRITE_BURST" yaml:"writeBurst"`
+	EnrichPerMinute int    `env:"RATE_LIMIT_ENRICH_PER_MINUTE" yaml:"enrichPerMinute"`
+	EnrichBurst     int    `env:"RATE_LIMIT_ENRICH_BURST" yaml:"enrichBurst"`
+	// SignIn limits requests with credentials per client IP, before the
+	// credentials are checked.
+	SignInPerMinute int `env:"RATE_LIMIT_SIGNIN_PER_MINUTE" yaml:"signInPerMinute"`
+	SignInBurst     int `env:"RATE_LIMIT_SIGNIN_BURST" yaml:"signInBurst"`
+}
+
+type Webhooks struct {
+	Workers int `env:"WEBHOOK_WORKERS" yaml:"workers"`
+	// MaxAttempts is how many times a delivery is tried before it fails.
+	MaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS" yaml:"maxAttempts"`
+	Timeout     time.Duration `env:"WEBHOOK_TIMEOUT" yaml:"timeout"`
+}
+
+// Outbox sets how change events are relayed from the outbox table.
+type Outbox struct {
+	// Sinks get every event in order: webhooks, stdout, file (JSON lines
+	// appended to File) and bus (NATS-style subjects, in process unless
+	// the server is given a connection).
+	Sinks         []string `env:"OUTBOX_SINKS" yaml:"sinks"`
+	File          string   `env:"OUTBOX_FILE" yaml:"file"`
+	SubjectPrefix string   `env:"OUTBOX_SUBJECT_PREFIX" yaml:"subjectPrefix"`
+	// PollInterval is how often the relay looks for events without being
+	// told, such as those of enrichment jobs or other instances.
+	PollInterval time.Duration `env:"OUTBOX_POLL_INTERVAL" yaml:"pollInterval"`
+	BatchSize    int           `env:"OUTBOX_BATCH_SIZE" yaml:"batchSize"`
+	// Retention is how long relayed events are kept for sinks to resume.
+	Retention time.Duration `env:"OUTBOX_RETENTION" yaml:"retention"`
+}
+
+// Events sets the server-sent change feed at /events.
+type Events struct {
+	// History is how many recent events are kept for clients resuming
+	// with Last-Event-ID.
+	History int `env:"EVENTS_HISTORY" yaml:"history"`
+	// Heartbeat is how often idle streams get a comment, so proxies keep
+	// them open and gone clients are noticed.
+	Heartbeat time.Duration `env:"EVENTS_HEARTBEAT" yaml:"heartbeat"`
+}
+
+// GraphQL limits the operations accepted at /graphql.
+type GraphQL struct {
+	// MaxDepth is how deeply fields may be nested.
+	MaxDepth int `env:"GRAPHQL_MAX_DEPTH" yaml:"maxDepth"`
+	// MaxComplexity caps the fields an operation may resolve, counting the
+	// fields below a list once per item of its limit.
+	MaxComplexity int `env:"GRAPHQL_MAX_COMPLEXITY" yaml:"maxComplexity"`
+}
+
+func Default() Config {
+	return Config{
+		Env:      "local",
+		LogLevel: "info",
+		Server: Server{
+			Port:            4001,
+			GRPCPort:        4002,
+			ShutdownTimeout: 5 * time.Second,
+		},
+		Database: Database{
+			Driver:         "postgres",
+			Host:           "localhost",
+			Port:           5432,
+			Schema:         "public",
+			SQLitePath:     "musiclib.db",
+			QueryTimeout:   5 * time.Second,
+			ConnectTimeout: 30 * time.Second,
+		},
+		MusicAPI: MusicAPI{
+			Timeout:          5 * time.Second,
+			MaxRetries:       2,
+			BaseBackoff:      200 * time.Millisecond,
+			MaxBackoff:       2 * time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		Cache: Cache{
+			TTL:         24 * time.Hour,
+			NegativeTTL: 10 * time.Minute,
+			MaxEntries:  10000,
+		},
+		Enrichment: Enrichment{
+			Workers:         4,
+			RefreshInterval: time.Hour,
+			RefreshMaxAge:   30 * 24 * time.Hour,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "music-library",
+			SampleRatio: 1,
+		},
+		Auth: Auth{
+			Enabled:            true,
+			UsageFlushInterval: 10 * time.Second,
+			OIDC: OIDC{
+				RolesClaim:  "roles",
+				ViewerRoles: []string{"viewer"},
+				EditorRoles: []string{"editor"},
+				AdminRoles:  []string{"admin"},
+			},
+		},
+		RateLimit: RateLimit{
+			Enabled:         true,
+			Store:           "memory",
+			ReadPerMinute:   600,
+			ReadBurst:       100,
+			WritePerMinute:  120,
+			WriteBurst:      30,
+			EnrichPerMinute: 30,
+			EnrichBurst:     10,
+			SignInPerMinute: 1200,
+			SignInBurst:     200,
+		},
+		Webhooks: Webhooks{
+			Workers:     2,
+			MaxAttempts: 8,
+			Timeout:     10 * time.Second,
+		},
+		Outbox: Outbox{
+			Sinks:         []string{"webhooks"},
+			SubjectPrefix: "music-library",
+			PollInterval:  time.Second,
+			BatchSize:     100,
+			Retention:     7 * 24 * time.Hour,
+		},
+		Events: Events{
+			History:   1000,
+			Heartbeat: 15 * time.Second,
+		},
+		GraphQL: GraphQL{
+			MaxDepth:      10,
+			MaxComplexity: 1000,
+		},
+	}
+}
+
+// Load reads the configuration, with file naming an optional YAML file. The
+// error lists every invalid setting, not just the first.
+func Load(file string) (Config, error) {
+	return load(file, Config.validate)
+}
+
+// LoadDatabase is Load for tools that only need the database settings; the
+// other sections are read but not validated.
+func LoadDatabase(file string) (Config, error) {
+	return load(file, func(c Config) []error { return c.Database.validate() })
+}
+
+func load(file string, validate func(Config) []error) (Config, error) {
+	cfg := Default()
+
+	if file != "" {
+		if err := loadYAML(file, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, fmt.Errorf("can't read .env: %w", err)
+	}
+
+	errs := applyEnv(&cfg)
+	errs = append(errs, validate(cfg)...)
+	if len(errs) > 0 {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+func loadYAML(file string, cfg *Config) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// applyEnv overrides every field whose variable is set.
+func applyEnv(cfg *Config) []error {
+	var errs []error
+	walk(reflect.ValueOf(cfg).Elem(), "", func(f field) {
+		raw, ok := os.LookupEnv(f.env)
+		if !ok || f.env == "" {
+			return
+		}
+		if err := set(f.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+		}
+	})
+	return errs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func set(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// checker collects every failed check.
+type checker []error
+
+func (c *checker) check(ok bool, format string, args ...any) {
+	if !ok {
+		*c = append(*c, fmt.Errorf(format, args...))
+	}
+}
+
+func (c Config) validate() []error {
+	var v checker
+	v.check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.LogLevel), "LOG_LEVEL: must be debug, info, warn or error, got %q", c.LogLevel)
+	v.check(c.Server.Port > 0 && c.Server.Port < 65536, "PORT: must be between 1 and 65535, got %d", c.Server.Port)
+	v.check(c.Server.GRPCPort >= 0 && c.Server.GRPCPort < 65536, "GRPC_PORT: must be between 0 and 65535, got %d", c.Server.GRPCPort)
+	v.check(c.Server.GRPCPort != c.Server.Port, "GRPC_PORT: must differ from PORT")
+	v.check(c.Server.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT: must be positive")
+	v.check(c.Server.DrainDelay >= 0, "SHUTDOWN_DRAIN_DELAY: must not be negative")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		v.check(cidrErr == nil || net.ParseIP(proxy) != nil, "TRUSTED_PROXIES: %q is not an IP address or CIDR", proxy)
+	}
+
+	v = append(v, c.Database.validate()...)
+
+	api := c.MusicAPI
+	u, err := url.Parse(api.URL)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "EXTERNAL_API_URL: must be an http(s) URL, got %q", api.URL)
+	v.check(api.Timeout > 0, "MUSIC_API_TIMEOUT: must be positive")
+	v.check(api.MaxRetries >= 0, "MUSIC_API_MAX_RETRIES: must not be negative")
+	v.check(api.BaseBackoff > 0, "MUSIC_API_BASE_BACKOFF: must be positive")
+	v.check(api.MaxBackoff >= api.BaseBackoff, "MUSIC_API_MAX_BACKOFF: must not be below MUSIC_API_BASE_BACKOFF")
+	v.check(api.BreakerThreshold > 0, "MUSIC_API_BREAKER_THRESHOLD: must be positive")
+	v.check(api.BreakerCooldown > 0, "MUSIC_API_BREAKER_COOLDOWN: must be positive")
+
+	v.check(c.Cache.TTL > 0, "METADATA_CACHE_TTL: must be positive")
+	v.check(c.Cache.NegativeTTL >= 0, "METADATA_CACHE_NEGATIVE_TTL: must not be negative")
+	v.check(c.Cache.MaxEntries >= 0, "METADATA_CACHE_MAX_ENTRIES: must not be negative")
+
+	v.check(c.Enrichment.Workers > 0, "ENRICHMENT_WORKERS: must be positive")
+	v.check(c.Enrichment.RefreshInterval >= 0, "REFRESH_INTERVAL: must not be negative")
+	v.check(c.Enrichment.RefreshMaxAge > 0, "REFRESH_MAX_AGE: must be positive")
+
+	v.check(slices.Contains([]string{"none", "otlp", "stdout"}, c.Tracing.Exporter), "OTEL_TRACES_EXPORTER: must be none, otlp or stdout, got %q", c.Tracing.Exporter)
+	v.check(c.Tracing.ServiceName != "", "OTEL_SERVICE_NAME: required")
+	v.check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO: must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	// keys are created by cmd/apikey, which can't reach a memory database
+	v.check(!c.Auth.Enabled || c.Database.Driver != "memory" || c.Auth.OIDC.JWKS != "", "AUTH_ENABLED: the memory database can't hold API keys, set OIDC_JWKS or disable auth to use it")
+	v.check(c.Auth.UsageFlushInterval > 0, "AUTH_USAGE_FLUSH_INTERVAL: must be positive")
+	rl := c.RateLimit
+	v.check(slices.Contains([]string{"memory", "postgres"}, rl.Store), "RATE_LIMIT_STORE: must be memory or postgres, got %q", rl.Store)
+	v.check(!rl.Enabled || rl.Store != "postgres" || c.Database.Driver == "postgres", "RATE_LIMIT_STORE: postgres needs DB_DRIVER=postgres")
+	v.check(rl.ReadPerMinute > 0 && rl.ReadBurst > 0, "RATE_LIMIT_READ_PER_MINUTE, RATE_LIMIT_READ_BURST: must be positive")
+	v.check(rl.WritePerMinute > 0 && rl.WriteBurst > 0, "RATE_LIMIT_WRITE_PER_MINUTE, RATE_LIMIT_WRITE_BURST: must be positive")
+	v.check(rl.EnrichPerMinute > 0 && rl.EnrichBurst > 0, "RATE_LIMIT_ENRICH_PER_MINUTE, RATE_LIMIT_ENRICH_BURST: must be positive")
+	v.check(rl.SignInPerMinute > 0 && rl.SignInBurst > 0, "RATE_LIMIT_SIGNIN_PER_MINUTE, RATE_LIMIT_SIGNIN_BURST: must be positive")
+
+	v.check(c.Webhooks.Workers > 0, "WEBHOOK_WORKERS: must be positive")
+	v.check(c.Webhooks.MaxAttempts > 0, "WEBHOOK_MAX_ATTEMPTS: must be positive")
+	v.check(c.Webhooks.Timeout > 0, "WEBHOOK_TIMEOUT: must be positive")
+	ob := c.Outbox
+	for i, sink := range ob.Sinks {
+		v.check(slices.Contains([]string{"webhooks", "stdout", "file", "bus"}, sink), "OUTBOX_SINKS: must be webhooks, stdout, file or bus, got %q", sink)
+		v.check(!slices.Contains(ob.Sinks[:i], sink), "OUTBOX_SINKS: %q is listed twice", sink)
+	}
+	v.check(!slices.Contains(ob.Sinks, "file") || ob.File != "", "OUTBOX_FILE: required with the file sink")
+	v.check(ob.SubjectPrefix != "", "OUTBOX_SUBJECT_PREFIX: required")
+	v.check(ob.PollInterval > 0, "OUTBOX_POLL_INTERVAL: must be positive")
+	v.check(ob.BatchSize > 0, "OUTBOX_BATCH_SIZE: must be positive")
+	v.check(ob.Retention > 0, "OUTBOX_RETENTION: must be positive")
+	v.check(c.Events.History > 0, "EVENTS_HISTORY: must be positive")
+	v.check(c.Events.Heartbeat > 0, "EVENTS_HEARTBEAT: must be positive")
+	v.check(c.GraphQL.MaxDepth > 0, "GRAPHQL_MAX_DEPTH: must be positive")
+	v.check(c.GraphQL.MaxComplexity > 0, "GRAPHQL_MAX_COMPLEXITY: must be positive")
+
+	if oidc := c.Auth.OIDC; oidc.JWKS != "" {
+		u, err := url.Parse(oidc.JWKS)
+		v.check(!strings.Contains(oidc.JWKS, "://") || err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "OIDC_JWKS: must be an http(s) URL or a file path, got %q", oidc.JWKS)
+		v.check(oidc.Issuer != "", "OIDC_ISSUER: required with OIDC_JWKS")
+		v.check(oidc.RolesClaim != "", "OIDC_ROLES_CLAIM: required with OIDC_JWKS")
+	}
+	return v
+}
+
+func (db Database) validate() []error {
+	var v checker
+	v.check(slices.Contains([]string{"postgres", "sqlite", "memory"}, db.Driver), "DB_DRIVER: must be postgres, sqlite or memory, got %q", db.Driver)
+	switch db.Driver {
+	case "postgres":
+		v.check(db.Host != "", "DB_HOST: required for postgres")
+		v.check(db.Port > 0 && db.Port < 65536, "DB_PORT: must be between 1 and 65535, got %d", db.Port)
+		v.check(db.Name != "", "DB_DATABASE: required for postgres")
+		v.check(db.User != "", "DB_USERNAME: required for postgres")
+	case "sqlite":
+		v.check(db.SQLitePath != "", "SQLITE_PATH: required for sqlite")
+	}
+	v.check(db.QueryTimeout >= 0, "DB_QUERY_TIMEOUT: must not be negative")
+	v.check(db.MaxConns >= 0, "DB_MAX_CONNS: must not be negative")
+	v.check(db.MinConns >= 0, "DB_MIN_CONNS: must not be negative")
+	v.check(db.MaxConns == 0 || db.MinConns <= db.MaxConns, "DB_MIN_CONNS: must not exceed DB_MAX_CONNS (%d > %d)", db.MinConns, db.MaxConns)
+	v.check(db.MaxConnLifetime >= 0, "DB_MAX_CONN_LIFETIME: must not be negative")
+	v.check(db.MaxConnIdleTime >= 0, "DB_MAX_CONN_IDLE_TIME: must not be negative")
+	v.check(db.HealthCheckPeriod >= 0, "DB_HEALTH_CHECK_PERIOD: must not be negative")
+	v.check(db.ConnectTimeout > 0, "DB_CONNECT_TIMEOUT: must be positive")
+	return v
+}
+
+// LogValue lists every setting under its YAML key, secrets redacted.
+func (c Config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	walk(reflect.ValueOf(c), "", func(f field) {
+		var value any
+		switch v := f.value.Interface().(type) {
+		case time.Duration:
+			value = v.String()
+		case Secret:
+			value = v.String()
+		default:
+			value = v
+		}
+		attrs = append(attrs, slog.Any(f.path, value))
+	})
+	return slog.GroupValue(attrs...)
+}
+
+type field struct {
+	env   string
+	path  string
+	value reflect.Value
+}
+
+// walk calls fn for every leaf setting of the struct v, with its dotted YAML
+// path.
+func walk(v reflect.Value, prefix string, fn func(field)) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		path := prefix + sf.Tag.Get("yaml")
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			walk(v.Field(i), path+".", fn)
+			continue
+		}
+		fn(field{env: sf.Tag.Get("env"), path: path, value: v.Field(i)})
+	}
+}
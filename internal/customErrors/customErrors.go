@@ -3,7 +3,20 @@ package customErrors
 import "errors"
 
 var (
-	ErrNotFound    = errors.New("song not found")
-	ErrInvalidData = errors.New("invalid data")
-	ErrISE         = errors.New("internal server error")
+	ErrNotFound     = errors.New("song not found")
+	ErrNoVerse      = errors.New("verse not found")
+	ErrInvalidData  = errors.New("invalid data")
+	ErrISE          = errors.New("internal server error")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobActive    = errors.New("job is already queued or running")
+	ErrNoJobs       = errors.New("no jobs ready")
+	ErrKeyNotFound  = errors.New("API key not found")
+	ErrUnauthorized = errors.New("missing or invalid credentials")
+	ErrForbidden    = errors.New("caller lacks the required scope")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrNoDeliveries     = errors.New("no deliveries ready")
 )
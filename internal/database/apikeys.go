@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// KeyStore keeps API keys by the hash of the key. Revoked keys are listed
+// but never found by hash.
+type KeyStore interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	// SetAPIKeyRateLimits replaces the key's rate limit overrides.
+	SetAPIKeyRateLimits(ctx context.Context, id string, limits map[string]models.RateLimit) error
+	// RecordAPIKeyUsage adds requests to the key's count and moves its last
+	// use forward to lastUsed.
+	RecordAPIKeyUsage(ctx context.Context, id int, requests int64, lastUsed time.Time) error
+}
+
+const apiKeyColumns = "id, name, prefix, array_to_string(scopes, ','), created_at, last_used_at, request_count, revoked_at, rate_limits::text"
+
+func (s *service) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "CreateAPIKey")
+	defer done()
+
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	return scanAPIKey(s.q.QueryRow(ctx, "INSERT INTO api_keys (name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4) RETURNING "+apiKeyColumns,
+		key.Name, key.Prefix, hash, key.Scopes))
+}
+
+func (s *service) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetAPIKeyByHash")
+	defer done()
+
+	key, err := scanAPIKey(s.q.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL", hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return key, customErrors.ErrKeyNotFound
+	}
+	return key, err
+}
+
+func (s *service) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListAPIKeys")
+	defer done()
+
+	rows, err := s.q.Query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *service) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "RevokeAPIKey")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	res, err := s.q.Exec(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1", n)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *service) SetAPIKeyRateLimits(ctx context.Context, id string, limits map[string]models.RateLimit) error {
+	ctx, done := withTimeout(ctx, s.timeout, "SetAPIKeyRateLimits")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	data, err := rateLimitsJSON(limits)
+	if err != nil {
+		return err
+	}
+	res, err := s.q.Exec(ctx, "UPDATE api_keys SET rate_limits = $1::jsonb WHERE id = $2", data, n)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *service) RecordAPIKeyUsage(ctx context.Context, id int, requests int64, lastUsed time.Time) error {
+	ctx, done := withTimeout(ctx, s.timeout, "RecordAPIKeyUsage")
+	defer done()
+
+	_, err := s.q.Exec(ctx, "UPDATE api_keys SET request_count = request_count + $2, last_used_at = GREATEST(last_used_at, $3) WHERE id = $1", id, requests, lastUsed)
+	return err
+}
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes, rateLimits string
+	var lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(&key.Id, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &lastUsedAt, &key.RequestCount, &revokedAt, &rateLimits)
+	if err != nil {
+		return key, err
+	}
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if rateLimits != "" && rateLimits != "{}" {
+		if err := json.Unmarshal([]byte(rateLimits), &key.RateLimits); err != nil {
+			return key, fmt.Errorf("rate limits of key %d: %w", key.Id, err)
+		}
+	}
+	return key, nil
+}
+
+func rateLimitsJSON(limits map[string]models.RateLimit) (string, error) {
+	if limits == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(limits)
+	return string(data), err
+}
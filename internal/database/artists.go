@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+)
+
+// ArtistStore reads the artists songs refer to by their group.
+type ArtistStore interface {
+	// ListArtists returns a page of artists ordered by id.
+	ListArtists(ctx context.Context, paginator query.Paginator) ([]models.Artist, error)
+	// GetArtistsByName returns the artists with the given names in one
+	// query, in no particular order. Unknown names are left out.
+	GetArtistsByName(ctx context.Context, names []string) ([]models.Artist, error)
+}
+
+func (s *service) ListArtists(ctx context.Context, paginator query.Paginator) ([]models.Artist, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListArtists")
+	defer done()
+
+	return s.queryArtists(ctx, "SELECT id, artist FROM artists ORDER BY id"+getPaginatorString(paginator))
+}
+
+func (s *service) GetArtistsByName(ctx context.Context, names []string) ([]models.Artist, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetArtistsByName")
+	defer done()
+
+	return s.queryArtists(ctx, "SELECT id, artist FROM artists WHERE artist = ANY($1)", names)
+}
+
+func (s *service) queryArtists(ctx context.Context, stmt string, args ...any) ([]models.Artist, error) {
+	rows, err := s.q.Query(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []models.Artist{}
+	for rows.Next() {
+		var artist models.Artist
+		if err := rows.Scan(&artist.Id, &artist.Artist); err != nil {
+			return nil, err
+		}
+		artists = append(artists, artist)
+	}
+	return artists, rows.Err()
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"music-library/internal/models"
+)
+
+type AuditLog interface {
+	// RecordAudit appends an entry. Call it on the Service a WithTx func
+	// gets, so the entry is committed with the change it describes.
+	RecordAudit(ctx context.Context, entry models.AuditEntry) error
+	// ListAudit returns the entries matching filter, newest first.
+	ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+}
+
+func (s *service) RecordAudit(ctx context.Context, entry models.AuditEntry) error {
+	ctx, done := withTimeout(ctx, s.timeout, "RecordAudit")
+	defer done()
+
+	_, err := s.q.Exec(ctx, "INSERT INTO audit_log (actor, request_id, source_ip, action, resource, resource_id, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8::jsonb)",
+		entry.Actor, entry.RequestId, entry.SourceIP, entry.Action, entry.Resource, entry.ResourceId, nullJSON(entry.Before), nullJSON(entry.After))
+	return err
+}
+
+func (s *service) ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListAudit")
+	defer done()
+
+	w := &where{numbered: true}
+	auditConditions(w, filter, func(t time.Time) any { return t })
+
+	rows, err := s.q.Query(ctx, "SELECT id, at, actor, request_id, source_ip, action, resource, resource_id, before::text, after::text FROM audit_log"+w.String()+" ORDER BY id DESC"+auditPage(filter), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// auditConditions adds the filter's conditions; at converts times to what
+// the driver stores.
+func auditConditions(w *where, filter models.AuditFilter, at func(time.Time) any) {
+	if filter.Actor != "" {
+		w.add("actor = " + w.bind(filter.Actor))
+	}
+	if filter.Resource != "" {
+		w.add("resource = " + w.bind(filter.Resource))
+	}
+	if filter.ResourceId != "" {
+		w.add("resource_id = " + w.bind(filter.ResourceId))
+	}
+	if !filter.From.IsZero() {
+		w.add("at >= " + w.bind(at(filter.From)))
+	}
+	if !filter.To.IsZero() {
+		w.add("at < " + w.bind(at(filter.To)))
+	}
+}
+
+func auditPage(filter models.AuditFilter) string {
+	limit := filter.Limit
+	if limit < 1 {
+		limit = 10
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, max(filter.Offset, 0))
+}
+
+func scanAuditEntry(row scanner) (models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var before, after sql.NullString
+
+	err := row.Scan(&entry.Id, &entry.At, &entry.Actor, &entry.RequestId, &entry.SourceIP, &entry.Action, &entry.Resource, &entry.ResourceId, &before, &after)
+	if before.Valid {
+		entry.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		entry.After = json.RawMessage(after.String)
+	}
+	return entry, err
+}
+
+func nullJSON(data json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(data), Valid: data != nil}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"music-library/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type MetadataCache interface {
+	GetCachedInfo(ctx context.Context, key string) (models.CachedInfo, bool, error)
+	PutCachedInfo(ctx context.Context, key string, entry models.CachedInfo) error
+	// PruneCachedInfo deletes the entries that expired before cutoff and
+	// reports how many.
+	PruneCachedInfo(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+func (s *service) GetCachedInfo(ctx context.Context, key string) (models.CachedInfo, bool, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetCachedInfo")
+	defer done()
+
+	var entry models.CachedInfo
+	var releaseDate sql.NullTime
+
+	err := s.q.QueryRow(ctx, "SELECT release_date, lirycs, link, not_found, expires_at FROM metadata_cache WHERE key = $1 AND expires_at > now()", key).Scan(&releaseDate, &entry.Info.Text, &entry.Info.Link, &entry.NotFound, &entry.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entry, false, nil
+		}
+		return entry, false, err
+	}
+	if releaseDate.Valid {
+		entry.Info.ReleaseDate = models.Date(releaseDate.Time)
+	}
+
+	return entry, true, nil
+}
+
+func (s *service) PutCachedInfo(ctx context.Context, key string, entry models.CachedInfo) error {
+	ctx, done := withTimeout(ctx, s.timeout, "PutCachedInfo")
+	defer done()
+
+	_, err := s.q.Exec(ctx, `INSERT INTO metadata_cache (key, release_date, lirycs, link, not_found, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (key) DO UPDATE SET release_date = EXCLUDED.release_date, lirycs = EXCLUDED.lirycs, link = EXCLUDED.link,
+			not_found = EXCLUDED.not_found, expires_at = EXCLUDED.expires_at, updated_at = now()`,
+		key, nullDate(entry.Info.ReleaseDate), entry.Info.Text, entry.Info.Link, entry.NotFound, entry.ExpiresAt)
+	return err
+}
+
+func (s *service) PruneCachedInfo(ctx context.Context, cutoff time.Time) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "PruneCachedInfo")
+	defer done()
+
+	tag, err := s.q.Exec(ctx, "DELETE FROM metadata_cache WHERE expires_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -1,130 +1,210 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"music-library/internal/audit"
+	"music-library/internal/config"
 	"music-library/internal/customErrors"
+	"music-library/internal/logging"
 	"music-library/internal/models"
 	"music-library/internal/server/query"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/joho/godotenv/autoload"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 type Service interface {
 	Close() error
-	AddNewSong(song models.Song) error
-	GetSongs(opts query.Options) ([]models.Song, error)
-	GetSongById(id string) (models.Song, error)
-	UpdateSongById(id string, song models.Song) error
-	DeleteSongById(id string) error
+	AddNewSong(ctx context.Context, song models.Song) (int, error)
+	GetSongs(ctx context.Context, opts query.Options) ([]models.Song, error)
+	GetSongById(ctx context.Context, id string) (models.Song, error)
+	UpdateSongById(ctx context.Context, id string, song models.Song) error
+	// UpdateSongEnrichment fills in the release date, lyrics and link of song
+	// that are still empty, so edits made since the song was added are kept.
+	UpdateSongEnrichment(ctx context.Context, id int, song models.Song, status string) error
+	SetEnrichmentStatus(ctx context.Context, id int, status string) error
+	DeleteSongById(ctx context.Context, id string) error
+	// WithTx runs fn in a transaction and commits it if fn returns nil.
+	// fn may run again when the transaction hits a serialization failure.
+	WithTx(ctx context.Context, fn func(tx Service) error) error
+	ArtistStore
+	JobQueue
+	MetadataCache
+	RefreshStore
+	KeyStore
+	AuditLog
+	WebhookStore
+	Outbox
 }
 
 type service struct {
-	db *sql.DB
+	pool *pgxpool.Pool
+	// q is the pool, or tx for a Service handed to a WithTx func.
+	q        querier
+	tx       pgx.Tx
+	database string
+	timeout  time.Duration
 }
 
-var (
-	database   = os.Getenv("DB_DATABASE")
-	password   = os.Getenv("DB_PASSWORD")
-	username   = os.Getenv("DB_USERNAME")
-	port       = os.Getenv("DB_PORT")
-	host       = os.Getenv("DB_HOST")
-	schema     = os.Getenv("DB_SCHEMA")
-	dbInstance *service
-)
-
-func New() Service {
-	if dbInstance != nil {
-		return dbInstance
+// New opens the backend picked by cfg.Driver: postgres, sqlite or memory.
+func New(cfg config.Database) (Service, error) {
+	switch cfg.Driver {
+	case "postgres":
+		s, err := newPostgres(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("can't connect to database: %w", err)
+		}
+		return s, nil
+	case "sqlite":
+		s, err := NewSQLite(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("can't open SQLite database %s: %w", cfg.SQLitePath, err)
+		}
+		return s, nil
+	case "memory":
+		slog.Info("Using in-memory database, nothing will be persisted")
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
 	}
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
-	slog.Debug("Connecting to database: ", "connection string", connStr)
+}
 
-	db, err := sql.Open("pgx", connStr)
+func newPostgres(db config.Database) (Service, error) {
+	cfg, err := poolConfig(db)
 	if err != nil {
-		slog.Error("Cold not connect to database: ", "error", err)
+		return nil, err
 	}
+	slog.Info("Connecting to database", "host", cfg.ConnConfig.Host, "port", cfg.ConnConfig.Port, "db", cfg.ConnConfig.Database,
+		"maxConns", cfg.MaxConns, "minConns", cfg.MinConns)
 
+	pool, err := connect(cfg, db.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
 	slog.Info("Connected to database")
 
-	err = MigrateUp(db)
+	mg, err := newMigrator("postgres", stdlib.OpenDBFromPool(pool))
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	err = prepareSchema(mg, db.AutoMigrate)
+	mg.Close()
 	if err != nil {
-		slog.Debug("Migration error", "msg", err)
+		pool.Close()
+		return nil, err
 	}
-	dbInstance = &service{
-		db: db,
+
+	s := &service{
+		pool:     pool,
+		q:        pool,
+		database: cfg.ConnConfig.Database,
+		timeout:  db.QueryTimeout,
 	}
-	// FillTestData(dbInstance)
-	return dbInstance
+	// FillTestData(s)
+	return s, nil
 }
 
-func (s *service) AddNewSong(song models.Song) error {
-	id, err := s.AddNewArtist(song.Group)
-	if err != nil {
-		return err
+const songColumns = "songs.id, artist, song, release_date, lirycs, link, enrichment_status, enriched_at, array_to_string(edited_fields, ','), created_by, updated_by"
+
+func (s *service) AddNewSong(ctx context.Context, song models.Song) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "AddNewSong")
+	defer done()
+
+	if song.EnrichmentStatus == "" {
+		song.EnrichmentStatus = models.EnrichmentPending
 	}
 
-	_, err = s.db.Exec("INSERT INTO songs (artist_id, song, release_date, lirycs, link) VALUES ($1, $2, $3, $4, $5)", id, song.Song, song.ReleaseDate, song.Text, song.Link)
+	var id int
+	err := s.inTx(ctx, func(tx *service) error {
+		artistId, err := tx.AddNewArtist(ctx, song.Group)
+		if err != nil {
+			return err
+		}
+		return tx.q.QueryRow(ctx, "INSERT INTO songs (artist_id, song, release_date, lirycs, link, enrichment_status, created_by) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id", artistId, song.Song, nullDate(song.ReleaseDate), song.Text, song.Link, song.EnrichmentStatus, song.CreatedBy).Scan(&id)
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return id, nil
 }
 
-func (s *service) AddNewArtist(artist string) (int, error) {
+// AddNewArtist returns the id of the artist, adding it first if needed. The
+// unique constraint on the name makes concurrent adds of one artist safe:
+// whoever loses the race finds the winner's row. Call it in a transaction,
+// which new artists are audited in.
+func (s *service) AddNewArtist(ctx context.Context, artist string) (int, error) {
 	var id int
-	rows, err := s.db.Query("SELECT id FROM artists WHERE artist = $1 LIMIT 1", artist)
-	if err == nil && rows.Next() {
-		rows.Scan(&id)
-		return id, nil
+	err := s.q.QueryRow(ctx, "INSERT INTO artists (artist) VALUES ($1) ON CONFLICT (artist) DO NOTHING RETURNING id", artist).Scan(&id)
+	if err == nil {
+		logging.FromContext(ctx).InfoContext(ctx, "New artist added", "id", id, "artist", artist)
+		return id, audit.Record(ctx, s, audit.Create, audit.Artist, id, nil, models.Artist{Id: id, Artist: artist})
 	}
-
-	err = s.db.QueryRow("INSERT INTO artists (artist) VALUES ($1) RETURNING id", artist).Scan(&id)
-	if err != nil {
+	if !errors.Is(err, pgx.ErrNoRows) {
 		return 0, err
 	}
 
-	slog.Info("New artist added", "id", id, "artist", artist)
-	return id, nil
+	err = s.q.QueryRow(ctx, "SELECT id FROM artists WHERE artist = $1", artist).Scan(&id)
+	return id, err
 }
 
-func (s *service) GetSongs(opts query.Options) ([]models.Song, error) {
-	var songs []models.Song
+func (s *service) GetSongs(ctx context.Context, opts query.Options) ([]models.Song, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetSongs")
+	defer done()
+
+	songs := []models.Song{}
+
+	w := &where{numbered: true}
+	if err := getFilersString(w, opts.Filters, func(d models.Date) any { return time.Time(d) }); err != nil {
+		return nil, err
+	}
+	if opts.Search != "" {
+		w.add("to_tsvector('simple', songs.song || ' ' || songs.lirycs) @@ plainto_tsquery('simple', " + w.bind(opts.Search) + ")")
+	}
 
-	query := fmt.Sprintf("SELECT songs.id, artist, song, release_date, lirycs, link FROM songs LEFT JOIN artists ON songs.artist_id = artists.id ORDER BY songs.id %s %s", getFilersString(opts.Filters), getPaginatorString(opts.Paginator))
-	rows, err := s.db.Query(query)
+	query := fmt.Sprintf("SELECT "+songColumns+" FROM songs LEFT JOIN artists ON songs.artist_id = artists.id %s ORDER BY songs.id %s", w, getPaginatorString(opts.Paginator))
+	rows, err := s.q.Query(ctx, query, w.args...)
 
-	slog.Debug("Send query to db: ", "query", query)
+	logging.FromContext(ctx).DebugContext(ctx, "Send query to db: ", "query", query)
 
 	if err != nil {
 		return nil, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
-		var song models.Song
-		if err := rows.Scan(&song.Id, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link); err != nil {
+		song, err := scanSong(rows)
+		if err != nil {
 			return nil, err
 		}
 
 		songs = append(songs, song)
 	}
-	return songs, nil
+	return songs, rows.Err()
 }
 
-func (s *service) GetSongById(id string) (models.Song, error) {
-	var song models.Song
+func (s *service) GetSongById(ctx context.Context, id string) (models.Song, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetSongById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Song{}, customErrors.ErrNotFound
+	}
 
-	err := s.db.QueryRow("SELECT songs.id, artist, song, release_date, lirycs, link FROM songs LEFT JOIN artists ON songs.artist_id = artists.id WHERE songs.id = $1", id).Scan(&song.Id, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link)
+	song, err := scanSong(s.q.QueryRow(ctx, "SELECT "+songColumns+" FROM songs LEFT JOIN artists ON songs.artist_id = artists.id WHERE songs.id = $1", n))
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return song, customErrors.ErrNotFound
 		}
 		return song, err
@@ -133,66 +213,121 @@ func (s *service) GetSongById(id string) (models.Song, error) {
 	return song, nil
 }
 
-func (s *service) UpdateSongById(id string, song models.Song) error {
-	_, err := s.db.Exec("UPDATE songs SET song = $1, release_date = $2, lirycs = $3, link = $4 WHERE id = $5", song.Song, song.ReleaseDate, song.Text, song.Link, id)
+func (s *service) UpdateSongById(ctx context.Context, id string, song models.Song) error {
+	ctx, done := withTimeout(ctx, s.timeout, "UpdateSongById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+	if song.EditedFields == nil {
+		song.EditedFields = []string{}
+	}
+
+	res, err := s.q.Exec(ctx, "UPDATE songs SET song = $1, release_date = $2, lirycs = $3, link = $4, edited_fields = $5, updated_by = $6 WHERE id = $7", song.Song, nullDate(song.ReleaseDate), song.Text, song.Link, song.EditedFields, song.UpdatedBy, n)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *service) UpdateSongEnrichment(ctx context.Context, id int, song models.Song, status string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "UpdateSongEnrichment")
+	defer done()
+
+	res, err := s.q.Exec(ctx, "UPDATE songs SET release_date = COALESCE(release_date, $1), lirycs = COALESCE(NULLIF(lirycs, ''), $2), link = COALESCE(NULLIF(link, ''), $3), enrichment_status = $4, enriched_at = now() WHERE id = $5", nullDate(song.ReleaseDate), song.Text, song.Link, status, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return customErrors.ErrNotFound
-		}
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrNotFound
+	}
 
 	return nil
 }
 
-func (s *service) DeleteSongById(id string) error {
-	_, err := s.db.Exec("DELETE FROM songs WHERE id = $1", id)
+func (s *service) SetEnrichmentStatus(ctx context.Context, id int, status string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "SetEnrichmentStatus")
+	defer done()
+
+	res, err := s.q.Exec(ctx, "UPDATE songs SET enrichment_status = $1 WHERE id = $2", status, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return customErrors.ErrNotFound
-		}
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrNotFound
+	}
 
 	return nil
 }
 
-func getFilersString(filters []query.Filter) string {
-	if len(filters) == 0 {
-		return ""
+func (s *service) DeleteSongById(ctx context.Context, id string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "DeleteSongById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrNotFound
 	}
 
-	filtersString := " WHERE "
-	for _, filter := range filters {
-		filtersString += filter.Field + " = '" + filter.Value + "' AND "
+	res, err := s.q.Exec(ctx, "DELETE FROM songs WHERE id = $1", n)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrNotFound
 	}
-	filtersString = strings.TrimSuffix(filtersString, "AND ")
-	return filtersString
-}
 
-func getPaginatorString(paginator query.Paginator) string {
-	return " LIMIT " + fmt.Sprint(paginator.Limit) + " OFFSET " + fmt.Sprint(paginator.Offset)
+	return nil
 }
 
-func (s *service) Close() error {
-	slog.Info("Disconnecting from database: ", "db", database)
-	return s.db.Close()
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func MigrateUp(db *sql.DB) error {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
-	if err != nil {
-		slog.Debug("Can't create driver for migration : ", "error", err)
-		return err
+func scanSong(row scanner) (models.Song, error) {
+	var song models.Song
+	var releaseDate, enrichedAt sql.NullTime
+	var editedFields string
+
+	err := row.Scan(&song.Id, &song.Group, &song.Song, &releaseDate, &song.Text, &song.Link, &song.EnrichmentStatus, &enrichedAt, &editedFields, &song.CreatedBy, &song.UpdatedBy)
+	if releaseDate.Valid {
+		song.ReleaseDate = models.Date(releaseDate.Time)
 	}
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"postgres", driver)
-	if err != nil {
-		return err
+	if enrichedAt.Valid {
+		song.EnrichedAt = &enrichedAt.Time
 	}
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return err
+	if editedFields != "" {
+		song.EditedFields = strings.Split(editedFields, ",")
+	}
+	return song, err
+}
+
+func nullDate(d models.Date) sql.NullTime {
+	t := time.Time(d)
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func getPaginatorString(paginator query.Paginator) string {
+	limit, offset := paginator.Bounds()
+	return " LIMIT " + fmt.Sprint(limit) + " OFFSET " + fmt.Sprint(offset)
+}
+
+func parseId(id string) (int, bool) {
+	n, err := strconv.Atoi(id)
+	return n, err == nil && n > 0
+}
+
+func (s *service) Close() error {
+	if s.tx != nil {
+		return nil
 	}
+	slog.Info("Disconnecting from database: ", "db", s.database)
+	s.pool.Close()
 	return nil
 }
@@ -0,0 +1,1127 @@
+// Package dbtest is a conformance suite for database.Service
+// implementations. A backend's test calls Run with a factory returning a
+// fresh, empty service for every subtest.
+package dbtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+)
+
+var ctx = context.Background()
+
+type Factory func(t *testing.T) database.Service
+
+func Run(t *testing.T, newService Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, db database.Service)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"NotFound", testNotFound},
+		{"UpdateAndDelete", testUpdateAndDelete},
+		{"Filters", testFilters},
+		{"Search", testSearch},
+		{"Pagination", testPagination},
+		{"Enrichment", testEnrichment},
+		{"Jobs", testJobs},
+		{"MetadataCache", testMetadataCache},
+		{"Refresh", testRefresh},
+		{"APIKeys", testAPIKeys},
+		{"Audit", testAudit},
+		{"Webhooks", testWebhooks},
+		{"Outbox", testOutbox},
+		{"Cancelled", testCancelled},
+		{"Transactions", testTransactions},
+		{"Artists", testArtists},
+		{"ConcurrentArtists", testConcurrentArtists},
+		{"TokenBuckets", testTokenBuckets},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newService(t)
+			t.Cleanup(func() { db.Close() })
+			tt.run(t, db)
+		})
+	}
+}
+
+func date(s string) models.Date {
+	d, err := models.ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func addSong(t *testing.T, db database.Service, song models.Song) int {
+	t.Helper()
+	id, err := db.AddNewSong(ctx, song)
+	if err != nil {
+		t.Fatalf("AddNewSong(%q): %v", song.Song, err)
+	}
+	return id
+}
+
+func getSong(t *testing.T, db database.Service, id int) models.Song {
+	t.Helper()
+	song, err := db.GetSongById(ctx, strconv.Itoa(id))
+	if err != nil {
+		t.Fatalf("GetSongById(%d): %v", id, err)
+	}
+	return song
+}
+
+func ids(songs []models.Song) []int {
+	res := make([]int, len(songs))
+	for i, song := range songs {
+		res[i] = song.Id
+	}
+	return res
+}
+
+func options(page, limit int, filters ...query.Filter) query.Options {
+	return query.Options{
+		Paginator: query.Paginator{Limit: strconv.Itoa(limit), Offset: strconv.Itoa((page - 1) * limit)},
+		Filters:   filters,
+	}
+}
+
+func seed(t *testing.T, db database.Service) []int {
+	t.Helper()
+	return []int{
+		addSong(t, db, models.Song{Group: "Muse", Song: "Starlight", ReleaseDate: date("2006-09-04"), Text: "Far away", Link: "https://example.com/1"}),
+		addSong(t, db, models.Song{Group: "Muse", Song: "Uprising", ReleaseDate: date("2009-09-07"), Text: "Paranoia", Link: "https://example.com/2"}),
+		addSong(t, db, models.Song{Group: "Adele", Song: "Hello", ReleaseDate: date("2015-10-23"), Text: "Hello", Link: "https://example.com/3"}),
+		addSong(t, db, models.Song{Group: "Adele", Song: "Skyfall", ReleaseDate: date("2012-10-05"), Text: "This is the end", Link: "https://example.com/4"}),
+		addSong(t, db, models.Song{Group: "Queen", Song: "Starlight"}),
+	}
+}
+
+func testAddAndGet(t *testing.T, db database.Service) {
+	id := addSong(t, db, models.Song{Group: "Muse", Song: "Starlight", ReleaseDate: date("04.09.2006"), Text: "Far away\n\nStarlight", Link: "https://example.com"})
+	if id <= 0 {
+		t.Fatalf("AddNewSong returned id %d", id)
+	}
+
+	song := getSong(t, db, id)
+	if song.Id != id || song.Group != "Muse" || song.Song != "Starlight" || song.ReleaseDate.String() != "2006-09-04" ||
+		song.Text != "Far away\n\nStarlight" || song.Link != "https://example.com" {
+		t.Errorf("GetSongById returned %+v", song)
+	}
+	if song.EnrichmentStatus != models.EnrichmentPending {
+		t.Errorf("new song has enrichment status %q, want %q", song.EnrichmentStatus, models.EnrichmentPending)
+	}
+
+	pending := getSong(t, db, addSong(t, db, models.Song{Group: "Muse", Song: "Uprising"}))
+	if !time.Time(pending.ReleaseDate).IsZero() || pending.Text != "" || pending.Link != "" {
+		t.Errorf("song without metadata returned %+v", pending)
+	}
+
+	other := addSong(t, db, models.Song{Group: "Adele", Song: "Hello"})
+	if other == id {
+		t.Errorf("two songs got the same id %d", id)
+	}
+}
+
+func testNotFound(t *testing.T, db database.Service) {
+	id := addSong(t, db, models.Song{Group: "Muse", Song: "Starlight"})
+	missing := strconv.Itoa(id + 100)
+
+	for _, bad := range []string{missing, "0", "-1", "abc", ""} {
+		if _, err := db.GetSongById(ctx, bad); !errors.Is(err, customErrors.ErrNotFound) {
+			t.Errorf("GetSongById(%q) error = %v, want ErrNotFound", bad, err)
+		}
+		if err := db.UpdateSongById(ctx, bad, models.Song{Song: "x"}); !errors.Is(err, customErrors.ErrNotFound) {
+			t.Errorf("UpdateSongById(%q) error = %v, want ErrNotFound", bad, err)
+		}
+		if err := db.DeleteSongById(ctx, bad); !errors.Is(err, customErrors.ErrNotFound) {
+			t.Errorf("DeleteSongById(%q) error = %v, want ErrNotFound", bad, err)
+		}
+		if _, err := db.GetJobById(ctx, bad); !errors.Is(err, customErrors.ErrJobNotFound) {
+			t.Errorf("GetJobById(%q) error = %v, want ErrJobNotFound", bad, err)
+		}
+	}
+
+	if err := db.UpdateSongEnrichment(ctx, id+100, models.Song{}, models.EnrichmentDone); !errors.Is(err, customErrors.ErrNotFound) {
+		t.Errorf("UpdateSongEnrichment on missing song error = %v, want ErrNotFound", err)
+	}
+	if err := db.SetEnrichmentStatus(ctx, id+100, models.EnrichmentFailed); !errors.Is(err, customErrors.ErrNotFound) {
+		t.Errorf("SetEnrichmentStatus on missing song error = %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdateAndDelete(t *testing.T, db database.Service) {
+	id := addSong(t, db, models.Song{Group: "Muse", Song: "Starlight", Text: "old", CreatedBy: "alice"})
+
+	err := db.UpdateSongById(ctx, strconv.Itoa(id), models.Song{Song: "Starlight (live)", ReleaseDate: date("2006-09-04"), Text: "new", Link: "l", EditedFields: []string{models.FieldText}, UpdatedBy: "bob"})
+	if err != nil {
+		t.Fatalf("UpdateSongById: %v", err)
+	}
+	song := getSong(t, db, id)
+	if song.Song != "Starlight (live)" || song.Text != "new" || song.Link != "l" || song.ReleaseDate.String() != "2006-09-04" {
+		t.Errorf("updated song is %+v", song)
+	}
+	if !slices.Equal(song.EditedFields, []string{models.FieldText}) {
+		t.Errorf("edited fields = %v, want [text]", song.EditedFields)
+	}
+	if song.Group != "Muse" {
+		t.Errorf("update changed group to %q", song.Group)
+	}
+	if song.CreatedBy != "alice" || song.UpdatedBy != "bob" {
+		t.Errorf("created by %q, updated by %q; want alice and bob", song.CreatedBy, song.UpdatedBy)
+	}
+
+	if err := db.DeleteSongById(ctx, strconv.Itoa(id)); err != nil {
+		t.Fatalf("DeleteSongById: %v", err)
+	}
+	if _, err := db.GetSongById(ctx, strconv.Itoa(id)); !errors.Is(err, customErrors.ErrNotFound) {
+		t.Errorf("GetSongById after delete error = %v, want ErrNotFound", err)
+	}
+	if err := db.DeleteSongById(ctx, strconv.Itoa(id)); !errors.Is(err, customErrors.ErrNotFound) {
+		t.Errorf("second DeleteSongById error = %v, want ErrNotFound", err)
+	}
+}
+
+func testFilters(t *testing.T, db database.Service) {
+	all := seed(t, db)
+
+	tests := []struct {
+		name    string
+		filters []query.Filter
+		want    []int
+	}{
+		{"none", nil, all},
+		{"group", []query.Filter{{Field: "group", Value: "Muse"}}, all[0:2]},
+		{"song", []query.Filter{{Field: "song", Value: "Starlight"}}, []int{all[0], all[4]}},
+		{"group and song", []query.Filter{{Field: "group", Value: "Muse"}, {Field: "song", Value: "Starlight"}}, all[0:1]},
+		{"release date", []query.Filter{{Field: "releaseDate", Value: "2015-10-23"}}, all[2:3]},
+		{"release date dotted", []query.Filter{{Field: "releaseDate", Value: "05.10.2012"}}, all[3:4]},
+		{"text", []query.Filter{{Field: "text", Value: "Paranoia"}}, all[1:2]},
+		{"link", []query.Filter{{Field: "link", Value: "https://example.com/4"}}, all[3:4]},
+		{"exact match only", []query.Filter{{Field: "group", Value: "muse"}}, []int{}},
+		{"quotes are data", []query.Filter{{Field: "song", Value: "' OR '1'='1"}}, []int{}},
+	}
+
+	for _, tt := range tests {
+		songs, err := db.GetSongs(ctx, options(1, 100, tt.filters...))
+		if err != nil {
+			t.Errorf("%s: GetSongs: %v", tt.name, err)
+			continue
+		}
+		if got := ids(songs); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got songs %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, bad := range []query.Filter{{Field: "releaseDate", Value: "yesterday"}, {Field: "lirycs", Value: "x"}} {
+		if _, err := db.GetSongs(ctx, options(1, 10, bad)); !errors.Is(err, customErrors.ErrInvalidData) {
+			t.Errorf("GetSongs(%s=%q) error = %v, want ErrInvalidData", bad.Field, bad.Value, err)
+		}
+	}
+}
+
+// testSearch sticks to whole words: SQLite matches substrings, the other
+// backends don't.
+func testSearch(t *testing.T, db database.Service) {
+	all := seed(t, db)
+	all = append(all, addSong(t, db, models.Song{Group: "Кино", Song: "Группа крови", Text: "Группа крови на рукаве"}))
+
+	tests := []struct {
+		name    string
+		search  string
+		filters []query.Filter
+		want    []int
+	}{
+		{"title", "uprising", nil, all[1:2]},
+		{"lyrics", "paranoia", nil, all[1:2]},
+		{"case insensitive", "HELLO", nil, all[2:3]},
+		{"title and lyrics", "starlight far", nil, all[0:1]},
+		{"all words", "the end", nil, all[3:4]},
+		{"punctuation", "end!", nil, all[3:4]},
+		{"with filter", "starlight", []query.Filter{{Field: "group", Value: "Queen"}}, all[4:5]},
+		{"no match", "nothing", nil, []int{}},
+		{"whole words", "star", nil, []int{}},
+		{"unicode case", "группа", nil, all[5:6]},
+		{"unicode upper case", "РУКАВЕ", nil, all[5:6]},
+		{"unicode whole words", "груп", nil, []int{}},
+	}
+
+	for _, tt := range tests {
+		opts := options(1, 100, tt.filters...)
+		opts.Search = tt.search
+		songs, err := db.GetSongs(ctx, opts)
+		if err != nil {
+			t.Errorf("%s: GetSongs: %v", tt.name, err)
+			continue
+		}
+		if got := ids(songs); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got songs %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testPagination(t *testing.T, db database.Service) {
+	all := seed(t, db)
+
+	tests := []struct {
+		opts query.Options
+		want []int
+	}{
+		{options(1, 2), all[0:2]},
+		{options(2, 2), all[2:4]},
+		{options(3, 2), all[4:5]},
+		{options(4, 2), []int{}},
+		{options(1, 10), all},
+		{options(2, 1, query.Filter{Field: "group", Value: "Adele"}), all[3:4]},
+		{query.Options{}, all},
+	}
+
+	for _, tt := range tests {
+		songs, err := db.GetSongs(ctx, tt.opts)
+		if err != nil {
+			t.Errorf("GetSongs(%+v): %v", tt.opts.Paginator, err)
+			continue
+		}
+		if songs == nil {
+			t.Errorf("GetSongs(%+v) returned nil, want an empty slice", tt.opts.Paginator)
+		}
+		if got := ids(songs); !slices.Equal(got, tt.want) {
+			t.Errorf("GetSongs(%+v) = %v, want %v", tt.opts.Paginator, got, tt.want)
+		}
+	}
+}
+
+func testEnrichment(t *testing.T, db database.Service) {
+	id := addSong(t, db, models.Song{Group: "Muse", Song: "Starlight"})
+
+	if err := db.UpdateSongEnrichment(ctx, id, models.Song{ReleaseDate: date("2006-09-04"), Text: "Far away", Link: "l"}, models.EnrichmentDone); err != nil {
+		t.Fatalf("UpdateSongEnrichment: %v", err)
+	}
+	song := getSong(t, db, id)
+	if song.EnrichmentStatus != models.EnrichmentDone || song.Text != "Far away" || song.Link != "l" || song.ReleaseDate.String() != "2006-09-04" {
+		t.Errorf("enriched song is %+v", song)
+	}
+	if song.EnrichedAt == nil {
+		t.Error("enriched song has no enrichedAt")
+	}
+
+	if err := db.SetEnrichmentStatus(ctx, id, models.EnrichmentFailed); err != nil {
+		t.Fatalf("SetEnrichmentStatus: %v", err)
+	}
+	song = getSong(t, db, id)
+	if song.EnrichmentStatus != models.EnrichmentFailed || song.Text != "Far away" {
+		t.Errorf("song after SetEnrichmentStatus is %+v", song)
+	}
+
+	// fields set since the song was added are kept, and empty upstream
+	// values don't clear anything
+	edited := addSong(t, db, models.Song{Group: "Muse", Song: "Knights of Cydonia"})
+	if err := db.UpdateSongById(ctx, strconv.Itoa(edited), models.Song{Song: "Knights of Cydonia", Text: "Come ride with me", EditedFields: []string{"text"}}); err != nil {
+		t.Fatalf("UpdateSongById: %v", err)
+	}
+	if err := db.UpdateSongEnrichment(ctx, edited, models.Song{ReleaseDate: date("2006-06-12"), Text: "Upstream lyrics"}, models.EnrichmentDone); err != nil {
+		t.Fatalf("UpdateSongEnrichment: %v", err)
+	}
+	song = getSong(t, db, edited)
+	if song.Text != "Come ride with me" || song.ReleaseDate.String() != "2006-06-12" || song.Link != "" {
+		t.Errorf("enriched song after an edit is %+v, want the edited text kept and the date filled in", song)
+	}
+	if err := db.UpdateSongEnrichment(ctx, id, models.Song{}, models.EnrichmentDone); err != nil {
+		t.Fatalf("UpdateSongEnrichment: %v", err)
+	}
+	if song = getSong(t, db, id); song.Text != "Far away" || song.Link != "l" || song.ReleaseDate.String() != "2006-09-04" {
+		t.Errorf("song after an empty enrichment is %+v, want its fields kept", song)
+	}
+}
+
+func testJobs(t *testing.T, db database.Service) {
+	songId := addSong(t, db, models.Song{Group: "Muse", Song: "Starlight"})
+
+	if _, err := db.ClaimJob(ctx, time.Minute); !errors.Is(err, customErrors.ErrNoJobs) {
+		t.Fatalf("ClaimJob on empty queue error = %v, want ErrNoJobs", err)
+	}
+
+	first, err := db.EnqueueJob(ctx, models.Job{Kind: "enrich", SongId: songId, MaxAttempts: 2})
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	second, err := db.EnqueueJob(ctx, models.Job{Kind: "enrich", SongId: songId})
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if _, err := db.EnqueueJob(ctx, models.Job{Kind: "enrich", SongId: songId, RunAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	job, err := db.GetJobById(ctx, strconv.Itoa(first))
+	if err != nil {
+		t.Fatalf("GetJobById: %v", err)
+	}
+	if job.Status != models.JobQueued || job.Kind != "enrich" || job.SongId != songId || job.MaxAttempts != 2 || job.Attempts != 0 {
+		t.Errorf("queued job is %+v", job)
+	}
+
+	claimed, err := db.ClaimJob(ctx, time.Minute)
+	if err != nil || claimed.Id != first || claimed.Status != models.JobRunning || claimed.Attempts != 1 {
+		t.Fatalf("first ClaimJob = %+v, %v", claimed, err)
+	}
+	next, err := db.ClaimJob(ctx, time.Minute)
+	if err != nil || next.Id != second {
+		t.Fatalf("second ClaimJob = %+v, %v; want job %d", next, err, second)
+	}
+	if _, err := db.ClaimJob(ctx, time.Minute); !errors.Is(err, customErrors.ErrNoJobs) {
+		t.Errorf("ClaimJob with only future and leased jobs error = %v, want ErrNoJobs", err)
+	}
+
+	if err := db.CompleteJob(ctx, second); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+	if job, _ := db.GetJobById(ctx, strconv.Itoa(second)); job.Status != models.JobSucceeded {
+		t.Errorf("completed job has status %q", job.Status)
+	}
+
+	failed, err := db.FailJob(ctx, first, "boom", time.Now().Add(-time.Second))
+	if err != nil || failed.Status != models.JobQueued || failed.LastError != "boom" {
+		t.Fatalf("FailJob with retry = %+v, %v", failed, err)
+	}
+	if _, err := db.RetryJob(ctx, strconv.Itoa(first)); !errors.Is(err, customErrors.ErrJobActive) {
+		t.Errorf("RetryJob on queued job error = %v, want ErrJobActive", err)
+	}
+
+	claimed, err = db.ClaimJob(ctx, time.Minute)
+	if err != nil || claimed.Id != first || claimed.Attempts != 2 {
+		t.Fatalf("ClaimJob after retry = %+v, %v", claimed, err)
+	}
+	failed, err = db.FailJob(ctx, first, "boom again", time.Now())
+	if err != nil || failed.Status != models.JobFailed {
+		t.Fatalf("FailJob with attempts used up = %+v, %v; want failed", failed, err)
+	}
+
+	retried, err := db.RetryJob(ctx, strconv.Itoa(first))
+	if err != nil || retried.Status != models.JobQueued || retried.Attempts != 0 || retried.LastError != "" {
+		t.Fatalf("RetryJob = %+v, %v", retried, err)
+	}
+
+	claimed, err = db.ClaimJob(ctx, time.Minute)
+	if err != nil || claimed.Id != first {
+		t.Fatalf("ClaimJob after RetryJob = %+v, %v", claimed, err)
+	}
+	failed, err = db.FailJob(ctx, first, "permanent", time.Time{})
+	if err != nil || failed.Status != models.JobFailed {
+		t.Errorf("FailJob without retry = %+v, %v; want failed", failed, err)
+	}
+
+	leased, err := db.EnqueueJob(ctx, models.Job{Kind: "enrich", SongId: songId})
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if claimed, err := db.ClaimJob(ctx, -time.Second); err != nil || claimed.Id != leased {
+		t.Fatalf("ClaimJob = %+v, %v; want job %d", claimed, err, leased)
+	}
+	if claimed, err := db.ClaimJob(ctx, time.Minute); err != nil || claimed.Id != leased || claimed.Attempts != 2 {
+		t.Errorf("ClaimJob of expired lease = %+v, %v; want job %d on attempt 2", claimed, err, leased)
+	}
+
+	if err := db.DeleteSongById(ctx, strconv.Itoa(songId)); err != nil {
+		t.Fatalf("DeleteSongById: %v", err)
+	}
+	if _, err := db.GetJobById(ctx, strconv.Itoa(first)); !errors.Is(err, customErrors.ErrJobNotFound) {
+		t.Errorf("job of deleted song: error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func testMetadataCache(t *testing.T, db database.Service) {
+	if _, ok, err := db.GetCachedInfo(ctx, "muse\x1fstarlight"); ok || err != nil {
+		t.Fatalf("GetCachedInfo on empty cache = %v, %v", ok, err)
+	}
+
+	entry := models.CachedInfo{
+		Info:      models.Song{ReleaseDate: date("2006-09-04"), Text: "Far away", Link: "l"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := db.PutCachedInfo(ctx, "muse\x1fstarlight", entry); err != nil {
+		t.Fatalf("PutCachedInfo: %v", err)
+	}
+	got, ok, err := db.GetCachedInfo(ctx, "muse\x1fstarlight")
+	if err != nil || !ok || got.NotFound || got.Info.Text != "Far away" || got.Info.Link != "l" || got.Info.ReleaseDate.String() != "2006-09-04" {
+		t.Errorf("GetCachedInfo = %+v, %v, %v", got, ok, err)
+	}
+
+	if err := db.PutCachedInfo(ctx, "muse\x1fstarlight", models.CachedInfo{NotFound: true, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("PutCachedInfo: %v", err)
+	}
+	if got, ok, _ := db.GetCachedInfo(ctx, "muse\x1fstarlight"); !ok || !got.NotFound {
+		t.Errorf("overwritten entry = %+v, %v; want a negative entry", got, ok)
+	}
+
+	if err := db.PutCachedInfo(ctx, "expired", models.CachedInfo{ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("PutCachedInfo: %v", err)
+	}
+	if _, ok, _ := db.GetCachedInfo(ctx, "expired"); ok {
+		t.Error("expired entry was returned")
+	}
+
+	if n, err := db.PruneCachedInfo(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("PruneCachedInfo = %d, %v; want 1, the expired entry", n, err)
+	}
+	if _, ok, _ := db.GetCachedInfo(ctx, "muse\x1fstarlight"); !ok {
+		t.Error("PruneCachedInfo dropped an entry that hasn't expired")
+	}
+}
+
+func testRefresh(t *testing.T, db database.Service) {
+	all := seed(t, db)
+	for _, id := range all[:4] {
+		if err := db.UpdateSongEnrichment(ctx, id, getSong(t, db, id), models.EnrichmentDone); err != nil {
+			t.Fatalf("UpdateSongEnrichment: %v", err)
+		}
+	}
+
+	n, err := db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{SongId: all[0]})
+	if err != nil || n != 1 {
+		t.Fatalf("EnqueueRefreshJobs(song) = %d, %v; want 1", n, err)
+	}
+	n, err = db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{Group: "Muse"})
+	if err != nil || n != 1 {
+		t.Errorf("EnqueueRefreshJobs(group) = %d, %v; want 1, the other song already has a job", n, err)
+	}
+	n, err = db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{EnrichedBefore: time.Now().Add(-time.Hour)})
+	if err != nil || n != 0 {
+		t.Errorf("EnqueueRefreshJobs(fresh songs) = %d, %v; want 0", n, err)
+	}
+	n, err = db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{EnrichedBefore: time.Now().Add(time.Hour), Limit: 1})
+	if err != nil || n != 1 {
+		t.Errorf("EnqueueRefreshJobs(limit 1) = %d, %v; want 1", n, err)
+	}
+	n, err = db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{})
+	if err != nil || n != 1 {
+		t.Errorf("EnqueueRefreshJobs(all) = %d, %v; want 1, pending songs are skipped", n, err)
+	}
+
+	// songs queued since QueuedBefore wait, even once their jobs are done
+	for {
+		job, err := db.ClaimJob(ctx, time.Minute)
+		if errors.Is(err, customErrors.ErrNoJobs) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ClaimJob: %v", err)
+		}
+		if err := db.CompleteJob(ctx, job.Id); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+	}
+	n, err = db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{QueuedBefore: time.Now().Add(-time.Hour)})
+	if err != nil || n != 0 {
+		t.Errorf("EnqueueRefreshJobs(queued before an hour ago) = %d, %v; want 0, every song was just queued", n, err)
+	}
+	n, err = db.EnqueueRefreshJobs(ctx, "refresh", models.RefreshFilter{QueuedBefore: time.Now().Add(time.Hour), Limit: 2})
+	if err != nil || n != 2 {
+		t.Errorf("EnqueueRefreshJobs(queued before an hour from now) = %d, %v; want 2", n, err)
+	}
+
+	changes := []models.FieldChange{{Field: models.FieldText, Old: "Far away", New: "Far, far away"}}
+	refreshed := getSong(t, db, all[0])
+	refreshed.Text = "Far, far away"
+	if err := db.ApplySongRefresh(ctx, all[0], refreshed, changes); err != nil {
+		t.Fatalf("ApplySongRefresh: %v", err)
+	}
+	if err := db.ApplySongRefresh(ctx, all[0], refreshed, nil); err != nil {
+		t.Fatalf("ApplySongRefresh without changes: %v", err)
+	}
+	if song := getSong(t, db, all[0]); song.Text != "Far, far away" || song.EnrichmentStatus != models.EnrichmentDone {
+		t.Errorf("refreshed song is %+v", song)
+	}
+
+	history, err := db.GetSongRefreshes(ctx, strconv.Itoa(all[0]))
+	if err != nil || len(history) != 1 || !slices.Equal(history[0].Changes, changes) || history[0].SongId != all[0] {
+		t.Errorf("GetSongRefreshes = %+v, %v", history, err)
+	}
+	if history, err := db.GetSongRefreshes(ctx, strconv.Itoa(all[1])); err != nil || len(history) != 0 || history == nil {
+		t.Errorf("GetSongRefreshes of unchanged song = %#v, %v; want empty", history, err)
+	}
+
+	if err := db.ApplySongRefresh(ctx, all[4]+100, refreshed, changes); !errors.Is(err, customErrors.ErrNotFound) {
+		t.Errorf("ApplySongRefresh on missing song error = %v, want ErrNotFound", err)
+	}
+}
+
+func testAPIKeys(t *testing.T, db database.Service) {
+	if _, err := db.GetAPIKeyByHash(ctx, "missing"); !errors.Is(err, customErrors.ErrKeyNotFound) {
+		t.Errorf("GetAPIKeyByHash on no keys error = %v, want ErrKeyNotFound", err)
+	}
+
+	created, err := db.CreateAPIKey(ctx, models.APIKey{Name: "ci", Prefix: "mlk_0123", Scopes: []string{"songs:read", "songs:write"}}, "hash-ci")
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if created.Id == 0 || created.CreatedAt.IsZero() || created.LastUsedAt != nil || created.RevokedAt != nil {
+		t.Errorf("created key = %+v", created)
+	}
+	if _, err := db.CreateAPIKey(ctx, models.APIKey{Name: "reader", Prefix: "mlk_4567"}, "hash-reader"); err != nil {
+		t.Fatalf("CreateAPIKey without scopes: %v", err)
+	}
+
+	got, err := db.GetAPIKeyByHash(ctx, "hash-ci")
+	if err != nil || got.Id != created.Id || got.Name != "ci" || got.Prefix != "mlk_0123" || !slices.Equal(got.Scopes, []string{"songs:read", "songs:write"}) {
+		t.Errorf("GetAPIKeyByHash = %+v, %v", got, err)
+	}
+
+	if got.RateLimits != nil {
+		t.Errorf("new key rate limits = %v, want none", got.RateLimits)
+	}
+	limits := map[string]models.RateLimit{"read": {PerMinute: 60, Burst: 5}}
+	if err := db.SetAPIKeyRateLimits(ctx, strconv.Itoa(created.Id), limits); err != nil {
+		t.Fatalf("SetAPIKeyRateLimits: %v", err)
+	}
+	if got, _ = db.GetAPIKeyByHash(ctx, "hash-ci"); !maps.Equal(got.RateLimits, limits) {
+		t.Errorf("rate limits = %v, want %v", got.RateLimits, limits)
+	}
+	if err := db.SetAPIKeyRateLimits(ctx, "999", limits); !errors.Is(err, customErrors.ErrKeyNotFound) {
+		t.Errorf("SetAPIKeyRateLimits of missing key error = %v, want ErrKeyNotFound", err)
+	}
+
+	used := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := db.RecordAPIKeyUsage(ctx, created.Id, 3, used); err != nil {
+		t.Fatalf("RecordAPIKeyUsage: %v", err)
+	}
+	if err := db.RecordAPIKeyUsage(ctx, created.Id, 2, used.Add(-time.Hour)); err != nil {
+		t.Fatalf("RecordAPIKeyUsage: %v", err)
+	}
+	got, _ = db.GetAPIKeyByHash(ctx, "hash-ci")
+	if got.RequestCount != 5 || got.LastUsedAt == nil || !got.LastUsedAt.Equal(used) {
+		t.Errorf("after usage key = %+v, want 5 requests last used at %v", got, used)
+	}
+
+	if err := db.RevokeAPIKey(ctx, strconv.Itoa(created.Id)); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err := db.GetAPIKeyByHash(ctx, "hash-ci"); !errors.Is(err, customErrors.ErrKeyNotFound) {
+		t.Errorf("GetAPIKeyByHash of revoked key error = %v, want ErrKeyNotFound", err)
+	}
+	if err := db.RevokeAPIKey(ctx, "999"); !errors.Is(err, customErrors.ErrKeyNotFound) {
+		t.Errorf("RevokeAPIKey of missing key error = %v, want ErrKeyNotFound", err)
+	}
+
+	keys, err := db.ListAPIKeys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("ListAPIKeys = %+v, %v; want 2 keys", keys, err)
+	}
+	if keys[0].Name != "ci" || keys[0].RevokedAt == nil || keys[1].Name != "reader" || keys[1].RevokedAt != nil || keys[1].Scopes == nil {
+		t.Errorf("ListAPIKeys = %+v", keys)
+	}
+}
+
+func testAudit(t *testing.T, db database.Service) {
+	entries := []models.AuditEntry{
+		{Actor: "key:ci", RequestId: "req-1", SourceIP: "10.0.0.1", Action: "create", Resource: "song", ResourceId: "1", After: json.RawMessage(`{"song": "Starlight"}`)},
+		{Actor: "alice", Action: "update", Resource: "song", ResourceId: "1", Before: json.RawMessage(`{"song": "Starlight"}`), After: json.RawMessage(`{"song": "Uprising"}`)},
+		{Actor: "alice", Action: "delete", Resource: "apiKey", ResourceId: "2", Before: json.RawMessage(`{"name": "ci"}`)},
+	}
+	for _, entry := range entries {
+		err := db.WithTx(ctx, func(tx database.Service) error {
+			return tx.RecordAudit(ctx, entry)
+		})
+		if err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+	rollback := errors.New("rollback")
+	err := db.WithTx(ctx, func(tx database.Service) error {
+		if err := tx.RecordAudit(ctx, models.AuditEntry{Actor: "bob", Action: "delete", Resource: "song", ResourceId: "1"}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithTx error = %v, want the rollback error", err)
+	}
+
+	all, err := db.ListAudit(ctx, models.AuditFilter{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListAudit = %+v, %v; want the 3 committed entries", all, err)
+	}
+	first := all[2]
+	if all[0].Action != "delete" || first.Actor != "key:ci" || first.RequestId != "req-1" || first.SourceIP != "10.0.0.1" ||
+		first.Id == 0 || first.At.IsZero() || first.Before != nil || !sameJSON(first.After, entries[0].After) {
+		t.Errorf("ListAudit = %+v, want newest first with fields kept", all)
+	}
+	if update := all[1]; !sameJSON(update.Before, entries[1].Before) || !sameJSON(update.After, entries[1].After) {
+		t.Errorf("update entry = %s -> %s", update.Before, update.After)
+	}
+
+	now := time.Now()
+	filters := []struct {
+		filter models.AuditFilter
+		want   int
+	}{
+		{models.AuditFilter{Actor: "alice"}, 2},
+		{models.AuditFilter{Resource: "song"}, 2},
+		{models.AuditFilter{Resource: "song", ResourceId: "1", Actor: "alice"}, 1},
+		{models.AuditFilter{ResourceId: "3"}, 0},
+		{models.AuditFilter{From: now.Add(-time.Minute)}, 3},
+		{models.AuditFilter{From: now.Add(time.Minute)}, 0},
+		{models.AuditFilter{To: now.Add(-time.Minute)}, 0},
+		{models.AuditFilter{From: now.Add(-time.Minute), To: now.Add(time.Minute)}, 3},
+		{models.AuditFilter{Limit: 2}, 2},
+		{models.AuditFilter{Limit: 2, Offset: 2}, 1},
+	}
+	for _, tt := range filters {
+		got, err := db.ListAudit(ctx, tt.filter)
+		if err != nil || len(got) != tt.want {
+			t.Errorf("ListAudit(%+v) = %d entries, %v; want %d", tt.filter, len(got), err, tt.want)
+		}
+	}
+}
+
+// sameJSON compares documents the way Postgres' jsonb stores them, without
+// regard to spacing or key order.
+func testWebhooks(t *testing.T, db database.Service) {
+	created, err := db.CreateWebhook(ctx, models.Webhook{URL: "https://example.com/hook", Events: []string{"song.created", "song.deleted"}, Description: "ci", Active: true, Secret: "s1"})
+	if err != nil || created.Id == 0 || created.Secret != "s1" || len(created.Events) != 2 || created.CreatedAt.IsZero() {
+		t.Fatalf("CreateWebhook = %+v, %v", created, err)
+	}
+	inactive, err := db.CreateWebhook(ctx, models.Webhook{URL: "https://example.com/off", Events: []string{"song.created"}, Secret: "s2"})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	id := strconv.Itoa(created.Id)
+
+	updated, err := db.UpdateWebhook(ctx, id, models.Webhook{URL: "https://example.com/v2", Events: []string{"song.created", "song.updated"}, Active: true})
+	if err != nil || updated.URL != "https://example.com/v2" || updated.Secret != "s1" || !slices.Equal(updated.Events, []string{"song.created", "song.updated"}) || updated.Description != "" {
+		t.Fatalf("UpdateWebhook = %+v, %v; want the secret kept", updated, err)
+	}
+	if hooks, err := db.ListWebhooks(ctx); err != nil || len(hooks) != 2 || hooks[0].Id != created.Id {
+		t.Errorf("ListWebhooks = %+v, %v", hooks, err)
+	}
+
+	for _, event := range []models.Event{
+		{Id: "e1", Type: "song.created", CreatedAt: time.Now(), Data: json.RawMessage(`{"song": {"id": 1}}`)},
+		{Id: "e2", Type: "song.deleted", CreatedAt: time.Now(), Data: json.RawMessage(`{"song": {"id": 1}}`)},
+	} {
+		if _, err := db.EnqueueWebhookEvent(ctx, event); err != nil {
+			t.Fatalf("EnqueueWebhookEvent: %v", err)
+		}
+	}
+	deliveries, err := db.ListDeliveries(ctx, id, 10, 0)
+	if err != nil || len(deliveries) != 1 || deliveries[0].EventId != "e1" || deliveries[0].Status != models.DeliveryPending {
+		t.Fatalf("ListDeliveries = %+v, %v; want only the subscribed event", deliveries, err)
+	}
+	if got, err := db.ListDeliveries(ctx, strconv.Itoa(inactive.Id), 10, 0); err != nil || len(got) != 0 {
+		t.Errorf("inactive webhook deliveries = %+v, %v; want none", got, err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(deliveries[0].Payload, &payload); err != nil || payload["id"] != "e1" || payload["type"] != "song.created" {
+		t.Errorf("payload = %s, %v", deliveries[0].Payload, err)
+	}
+
+	claimed, err := db.ClaimDelivery(ctx, time.Minute)
+	if err != nil || claimed.Id != deliveries[0].Id || claimed.Attempts != 1 || claimed.Status != models.DeliveryDelivering {
+		t.Fatalf("ClaimDelivery = %+v, %v", claimed, err)
+	}
+	if _, err := db.ClaimDelivery(ctx, time.Minute); !errors.Is(err, customErrors.ErrNoDeliveries) {
+		t.Errorf("ClaimDelivery while leased = %v, want ErrNoDeliveries", err)
+	}
+	failed, err := db.FailDelivery(ctx, claimed.Id, 500, "server error", time.Now().Add(-time.Second))
+	if err != nil || failed.Status != models.DeliveryPending || failed.LastError != "server error" || failed.ResponseStatus != 500 {
+		t.Fatalf("FailDelivery = %+v, %v", failed, err)
+	}
+	claimed, err = db.ClaimDelivery(ctx, time.Minute)
+	if err != nil || claimed.Attempts != 2 {
+		t.Fatalf("ClaimDelivery after retry = %+v, %v", claimed, err)
+	}
+	if err := db.CompleteDelivery(ctx, claimed.Id, 204); err != nil {
+		t.Fatalf("CompleteDelivery: %v", err)
+	}
+
+	again, err := db.Redeliver(ctx, id, strconv.Itoa(claimed.Id))
+	if err != nil || again.Id == claimed.Id || again.Status != models.DeliveryPending || again.EventId != "e1" || !sameJSON(again.Payload, claimed.Payload) {
+		t.Fatalf("Redeliver = %+v, %v", again, err)
+	}
+	claimed, err = db.ClaimDelivery(ctx, time.Minute)
+	if err != nil || claimed.Id != again.Id {
+		t.Fatalf("ClaimDelivery redelivery = %+v, %v", claimed, err)
+	}
+	if failed, err := db.FailDelivery(ctx, claimed.Id, 0, "timeout", time.Time{}); err != nil || failed.Status != models.DeliveryFailed {
+		t.Errorf("FailDelivery without retry = %+v, %v; want failed", failed, err)
+	}
+
+	deliveries, err = db.ListDeliveries(ctx, id, 10, 0)
+	if err != nil || len(deliveries) != 2 || deliveries[0].Id != again.Id || deliveries[1].Status != models.DeliverySucceeded ||
+		deliveries[1].ResponseStatus != 204 || deliveries[1].DeliveredAt == nil {
+		t.Errorf("ListDeliveries = %+v, %v; want newest first", deliveries, err)
+	}
+	if page, err := db.ListDeliveries(ctx, id, 1, 1); err != nil || len(page) != 1 || page[0].Id != deliveries[1].Id {
+		t.Errorf("ListDeliveries page 2 = %+v, %v", page, err)
+	}
+
+	if _, err := db.Redeliver(ctx, strconv.Itoa(inactive.Id), strconv.Itoa(again.Id)); !errors.Is(err, customErrors.ErrDeliveryNotFound) {
+		t.Errorf("Redeliver on another webhook = %v, want ErrDeliveryNotFound", err)
+	}
+	if _, err := db.ListDeliveries(ctx, "999", 10, 0); !errors.Is(err, customErrors.ErrWebhookNotFound) {
+		t.Errorf("ListDeliveries(999) = %v, want ErrWebhookNotFound", err)
+	}
+	if _, err := db.GetWebhook(ctx, "abc"); !errors.Is(err, customErrors.ErrWebhookNotFound) {
+		t.Errorf("GetWebhook(abc) = %v, want ErrWebhookNotFound", err)
+	}
+	if err := db.DeleteWebhook(ctx, id); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if err := db.DeleteWebhook(ctx, id); !errors.Is(err, customErrors.ErrWebhookNotFound) {
+		t.Errorf("second DeleteWebhook = %v, want ErrWebhookNotFound", err)
+	}
+	if _, err := db.ClaimDelivery(ctx, time.Minute); !errors.Is(err, customErrors.ErrNoDeliveries) {
+		t.Errorf("ClaimDelivery after delete = %v, want the deliveries gone", err)
+	}
+}
+
+func testOutbox(t *testing.T, db database.Service) {
+	event := func(id string) models.Event {
+		return models.Event{Id: id, Type: "song.created", CreatedAt: time.Now(), Data: json.RawMessage(`{"song": {"id": 1}}`)}
+	}
+	claim := func(sink string, limit int) []models.Event {
+		t.Helper()
+		var events []models.Event
+		err := db.WithTx(ctx, func(tx database.Service) error {
+			var err error
+			events, err = tx.ClaimOutbox(ctx, sink, limit)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("ClaimOutbox(%s): %v", sink, err)
+		}
+		return events
+	}
+	seqs := func(events []models.Event) []int64 {
+		var seqs []int64
+		for _, event := range events {
+			seqs = append(seqs, event.Seq)
+		}
+		return seqs
+	}
+
+	rollback := errors.New("rollback")
+	err := db.WithTx(ctx, func(tx database.Service) error {
+		if err := tx.AppendOutbox(ctx, event("lost")); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithTx error = %v, want the rollback error", err)
+	}
+	for _, id := range []string{"e1", "e2"} {
+		if err := db.WithTx(ctx, func(tx database.Service) error { return tx.AppendOutbox(ctx, event(id)) }); err != nil {
+			t.Fatalf("AppendOutbox: %v", err)
+		}
+	}
+	if events := claim("a", 10); len(events) != 0 {
+		t.Errorf("ClaimOutbox before SequenceOutbox = %+v, want none", events)
+	}
+
+	if n, err := db.SequenceOutbox(ctx); err != nil || n != 2 {
+		t.Fatalf("SequenceOutbox = %d, %v; want 2", n, err)
+	}
+	events := claim("a", 10)
+	if !slices.Equal(seqs(events), []int64{1, 2}) || events[0].Id != "e1" || events[0].Type != "song.created" ||
+		!sameJSON(events[0].Data, event("e1").Data) || events[0].CreatedAt.IsZero() {
+		t.Fatalf("ClaimOutbox = %+v, want e1 and e2 numbered 1 and 2", events)
+	}
+	if err := db.AckOutbox(ctx, "a", 2); err != nil {
+		t.Fatalf("AckOutbox: %v", err)
+	}
+	if events := claim("a", 10); len(events) != 0 {
+		t.Errorf("ClaimOutbox after ack = %+v, want none", events)
+	}
+	if events := claim("b", 1); !slices.Equal(seqs(events), []int64{1}) {
+		t.Errorf("ClaimOutbox(b, 1) = %v, want its own cursor from the start", seqs(events))
+	}
+
+	if err := db.WithTx(ctx, func(tx database.Service) error { return tx.AppendOutbox(ctx, event("e3")) }); err != nil {
+		t.Fatalf("AppendOutbox: %v", err)
+	}
+	if n, err := db.SequenceOutbox(ctx); err != nil || n != 1 {
+		t.Fatalf("SequenceOutbox = %d, %v; want 1", n, err)
+	}
+	prune := func(ackB int64, want int, why string) {
+		t.Helper()
+		if ackB > 0 {
+			if err := db.AckOutbox(ctx, "b", ackB); err != nil {
+				t.Fatalf("AckOutbox: %v", err)
+			}
+		}
+		if n, err := db.PruneOutbox(ctx, time.Now().Add(time.Minute), []string{"a", "b"}); err != nil || n != want {
+			t.Errorf("PruneOutbox = %d, %v; want %d, %s", n, err, want, why)
+		}
+	}
+	prune(0, 0, "b hasn't had any")
+	prune(1, 1, "those b had")
+	// a sink that was configured once and never got further
+	claim("gone", 1)
+	if n, err := db.PruneOutbox(ctx, time.Now().Add(time.Minute), []string{"a", "b", "gone"}); err != nil || n != 0 {
+		t.Errorf("PruneOutbox with gone = %d, %v; want 0, gone hasn't had any", n, err)
+	}
+	if n, err := db.PruneOutbox(ctx, time.Now().Add(time.Minute), []string{"a", "b", "new"}); err != nil || n != 0 {
+		t.Errorf("PruneOutbox with new = %d, %v; want 0, new has no cursor yet", n, err)
+	}
+	prune(3, 1, "all but the newest")
+	if err := db.WithTx(ctx, func(tx database.Service) error { return tx.AppendOutbox(ctx, event("e4")) }); err != nil {
+		t.Fatalf("AppendOutbox: %v", err)
+	}
+	if _, err := db.SequenceOutbox(ctx); err != nil {
+		t.Fatalf("SequenceOutbox: %v", err)
+	}
+	if events := claim("a", 10); !slices.Equal(seqs(events), []int64{3, 4}) || events[1].Id != "e4" {
+		t.Errorf("ClaimOutbox after prune = %v, want the numbering continued", seqs(events))
+	}
+
+	lists := []struct {
+		name string
+		list func() ([]models.Event, error)
+		want []int64
+	}{
+		{"ListOutbox(0, 1)", func() ([]models.Event, error) { return db.ListOutbox(ctx, 0, 1) }, []int64{3}},
+		{"ListOutbox(3, 10)", func() ([]models.Event, error) { return db.ListOutbox(ctx, 3, 10) }, []int64{4}},
+		{"LatestOutbox(1)", func() ([]models.Event, error) { return db.LatestOutbox(ctx, 1) }, []int64{4}},
+		{"LatestOutbox(10)", func() ([]models.Event, error) { return db.LatestOutbox(ctx, 10) }, []int64{3, 4}},
+	}
+	for _, tt := range lists {
+		if events, err := tt.list(); err != nil || !slices.Equal(seqs(events), tt.want) {
+			t.Errorf("%s = %v, %v; want %v", tt.name, seqs(events), err, tt.want)
+		}
+	}
+}
+
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func testCancelled(t *testing.T, db database.Service) {
+	id := addSong(t, db, models.Song{Group: "Muse", Song: "Starlight"})
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := db.GetSongs(cancelled, options(1, 10)); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetSongs error = %v, want context.Canceled", err)
+	}
+	if _, err := db.AddNewSong(cancelled, models.Song{Group: "Muse", Song: "Uprising"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddNewSong error = %v, want context.Canceled", err)
+	}
+	if err := db.DeleteSongById(cancelled, strconv.Itoa(id)); !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteSongById error = %v, want context.Canceled", err)
+	}
+
+	if songs, err := db.GetSongs(ctx, options(1, 10)); err != nil || len(songs) != 1 {
+		t.Errorf("after cancelled calls got %d songs, %v; want the one song", len(songs), err)
+	}
+}
+
+func testTransactions(t *testing.T, db database.Service) {
+	var songId, jobId int
+	err := db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		if songId, err = tx.AddNewSong(ctx, models.Song{Group: "Muse", Song: "Starlight"}); err != nil {
+			return err
+		}
+		jobId, err = tx.EnqueueJob(ctx, models.Job{Kind: "enrich", SongId: songId})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	getSong(t, db, songId)
+	if _, err := db.GetJobById(ctx, strconv.Itoa(jobId)); err != nil {
+		t.Errorf("GetJobById after commit: %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = db.WithTx(ctx, func(tx database.Service) error {
+		id, err := tx.AddNewSong(ctx, models.Song{Group: "Adele", Song: "Hello"})
+		if err != nil {
+			return err
+		}
+		if jobId, err = tx.EnqueueJob(ctx, models.Job{Kind: "enrich", SongId: id}); err != nil {
+			return err
+		}
+		if err := tx.UpdateSongById(ctx, strconv.Itoa(songId), models.Song{Song: "Changed"}); err != nil {
+			return err
+		}
+		// nested calls join the outer transaction
+		return tx.WithTx(ctx, func(tx database.Service) error {
+			if _, err := tx.AddNewSong(ctx, models.Song{Group: "Queen", Song: "Starlight"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx error = %v, want %v", err, errRollback)
+	}
+
+	songs, err := db.GetSongs(ctx, options(1, 100))
+	if err != nil {
+		t.Fatalf("GetSongs: %v", err)
+	}
+	if got := ids(songs); !slices.Equal(got, []int{songId}) {
+		t.Errorf("after rollback got songs %v, want %v", got, []int{songId})
+	}
+	if got := getSong(t, db, songId); got.Song != "Starlight" {
+		t.Errorf("after rollback song = %q, want the update undone", got.Song)
+	}
+	if _, err := db.GetJobById(ctx, strconv.Itoa(jobId)); !errors.Is(err, customErrors.ErrJobNotFound) {
+		t.Errorf("GetJobById after rollback error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func testArtists(t *testing.T, db database.Service) {
+	for _, song := range []models.Song{
+		{Group: "Muse", Song: "Uprising"},
+		{Group: "Queen", Song: "Bohemian Rhapsody"},
+		{Group: "Muse", Song: "Hysteria"},
+	} {
+		if _, err := db.AddNewSong(ctx, song); err != nil {
+			t.Fatalf("AddNewSong: %v", err)
+		}
+	}
+
+	artists, err := db.ListArtists(ctx, query.Paginator{Limit: "10", Offset: "0"})
+	if err != nil {
+		t.Fatalf("ListArtists: %v", err)
+	}
+	if len(artists) != 2 || artists[0].Artist != "Muse" || artists[1].Artist != "Queen" {
+		t.Fatalf("ListArtists = %+v, want Muse and Queen", artists)
+	}
+	page, err := db.ListArtists(ctx, query.Paginator{Limit: "1", Offset: "1"})
+	if err != nil {
+		t.Fatalf("ListArtists: %v", err)
+	}
+	if len(page) != 1 || page[0] != artists[1] {
+		t.Errorf("second page = %+v, want %+v", page, artists[1:])
+	}
+
+	found, err := db.GetArtistsByName(ctx, []string{"Queen", "Unknown", "Muse"})
+	if err != nil {
+		t.Fatalf("GetArtistsByName: %v", err)
+	}
+	slices.SortFunc(found, func(a, b models.Artist) int { return a.Id - b.Id })
+	if !slices.Equal(found, artists) {
+		t.Errorf("GetArtistsByName = %+v, want %+v", found, artists)
+	}
+	if found, err := db.GetArtistsByName(ctx, nil); err != nil || len(found) != 0 {
+		t.Errorf("GetArtistsByName(nil) = %+v, %v, want none", found, err)
+	}
+}
+
+func testConcurrentArtists(t *testing.T, db database.Service) {
+	const n = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.AddNewSong(ctx, models.Song{Group: "Muse", Song: "Song " + strconv.Itoa(i)})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("AddNewSong: %v", err)
+		}
+	}
+
+	songs, err := db.GetSongs(ctx, options(1, 100, query.Filter{Field: "group", Value: "Muse"}))
+	if err != nil {
+		t.Fatalf("GetSongs: %v", err)
+	}
+	if len(songs) != n {
+		t.Errorf("got %d songs by the artist, want %d", len(songs), n)
+	}
+}
+
+// testTokenBuckets runs for backends that can share rate limit buckets.
+func testTokenBuckets(t *testing.T, db database.Service) {
+	buckets, ok := db.(database.TokenBuckets)
+	if !ok {
+		t.Skip("no shared rate limit buckets")
+	}
+	take := func(key string, perSecond float64, burst int) (float64, bool) {
+		t.Helper()
+		tokens, allowed, err := buckets.TakeToken(ctx, key, perSecond, burst)
+		if err != nil {
+			t.Fatalf("TakeToken(%s): %v", key, err)
+		}
+		return tokens, allowed
+	}
+
+	// a new bucket starts full, then runs dry
+	for i, want := range []float64{2, 1, 0} {
+		if tokens, allowed := take("slow", 0.001, 3); !allowed || tokens < want || tokens >= want+0.1 {
+			t.Errorf("take %d = %v, %v; want allowed with %v left", i+1, tokens, allowed, want)
+		}
+	}
+	if tokens, allowed := take("slow", 0.001, 3); allowed || tokens >= 1 {
+		t.Errorf("take past the burst = %v, %v; want refused", tokens, allowed)
+	}
+
+	// a fast bucket refills between takes
+	take("fast", 100, 1)
+	time.Sleep(50 * time.Millisecond)
+	if tokens, allowed := take("fast", 100, 1); !allowed || tokens < 0 || tokens >= 1 {
+		t.Errorf("take after refilling = %v, %v; want allowed", tokens, allowed)
+	}
+
+	// concurrent takes, on any connection, see each other
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, allowed, err := buckets.TakeToken(ctx, "shared", 0.001, 4); err != nil {
+				t.Errorf("TakeToken: %v", err)
+			} else if allowed {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 4 {
+		t.Errorf("%d of 10 concurrent takes from a bucket of 4 were allowed", granted)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := buckets.PruneTokenBuckets(ctx, 0); err != nil {
+		t.Fatalf("PruneTokenBuckets: %v", err)
+	}
+	if tokens, allowed := take("slow", 0.001, 3); !allowed || tokens < 2 || tokens >= 2.1 {
+		t.Errorf("take after pruning = %v, %v; want a new full bucket", tokens, allowed)
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"log"
 
 	"music-library/internal/models"
@@ -52,7 +53,7 @@ func FillTestData(s *service) {
 		},
 	}
 	for _, song := range songs {
-		err := s.AddNewSong(song)
+		_, err := s.AddNewSong(context.Background(), song)
 		if err != nil {
 			log.Fatalf("Can't add new song : %v\n", err)
 		}
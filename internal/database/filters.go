@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+)
+
+// filterColumns maps the query filters to the columns they match exactly.
+var filterColumns = map[string]string{
+	"group":       "artists.artist",
+	"song":        "songs.song",
+	"releaseDate": "songs.release_date",
+	"text":        "songs.lirycs",
+	"link":        "songs.link",
+}
+
+// where collects the conditions and arguments of a WHERE clause. Postgres
+// wants numbered placeholders, SQLite gets plain ones.
+type where struct {
+	numbered   bool
+	conditions []string
+	args       []any
+}
+
+func (w *where) bind(value any) string {
+	w.args = append(w.args, value)
+	if w.numbered {
+		return fmt.Sprintf("$%d", len(w.args))
+	}
+	return "?"
+}
+
+func (w *where) add(condition string) {
+	w.conditions = append(w.conditions, condition)
+}
+
+func (w *where) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+// getFilersString adds an exact match condition for every filter; date
+// converts a releaseDate filter to what the driver stores.
+func getFilersString(w *where, filters []query.Filter, date func(models.Date) any) error {
+	for _, filter := range filters {
+		column, ok := filterColumns[filter.Field]
+		if !ok {
+			return customErrors.ErrInvalidData
+		}
+
+		var value any = filter.Value
+		if filter.Field == "releaseDate" {
+			d, err := models.ParseDate(filter.Value)
+			if err != nil {
+				return customErrors.ErrInvalidData
+			}
+			value = date(d)
+		}
+
+		w.add(column + " = " + w.bind(value))
+	}
+	return nil
+}
+
+// searchWords splits a search the way Postgres' simple text search config
+// does: lower case, broken up on anything that isn't a letter or digit.
+func searchWords(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
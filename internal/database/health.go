@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"music-library/migrations"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+)
+
+// HealthChecker is implemented by backends that depend on a database which
+// can go away or fall behind the build's migrations.
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+	SchemaStatus(ctx context.Context) (MigrationStatus, error)
+}
+
+// schemaQuery reads the version golang-migrate records.
+const schemaQuery = "SELECT version, dirty FROM schema_migrations LIMIT 1"
+
+func (s *service) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+func (s *service) SchemaStatus(ctx context.Context) (MigrationStatus, error) {
+	var status MigrationStatus
+	var version int64
+
+	err := s.pool.QueryRow(ctx, schemaQuery).Scan(&version, &status.Dirty)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return status, err
+	}
+	status.Version = uint(version)
+	status.Latest, err = embeddedLatest("postgres")
+	return status, err
+}
+
+func (s *sqliteService) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteService) SchemaStatus(ctx context.Context) (MigrationStatus, error) {
+	var status MigrationStatus
+	var version int64
+
+	err := s.db.QueryRowContext(ctx, schemaQuery).Scan(&version, &status.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return status, err
+	}
+	status.Version = uint(version)
+	status.Latest, err = embeddedLatest("sqlite")
+	return status, err
+}
+
+// embeddedLatest is the newest migration this build carries for a driver.
+func embeddedLatest(name string) (uint, error) {
+	source, err := iofs.New(migrations.FS, name)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	return latestVersion(source)
+}
@@ -0,0 +1,391 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The method label only takes Service method names and kind the values
+// returned by errorKind, so the series stay bounded.
+var (
+	callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_call_duration_seconds",
+		Help:    "Duration of database.Service calls by method.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"method"})
+
+	callErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_call_errors_total",
+		Help: "Failed database.Service calls by method and kind: not_found, rejected, timeout, canceled or error.",
+	}, []string{"method", "kind"})
+)
+
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, customErrors.ErrNotFound), errors.Is(err, customErrors.ErrJobNotFound), errors.Is(err, customErrors.ErrNoJobs),
+		errors.Is(err, customErrors.ErrKeyNotFound), errors.Is(err, customErrors.ErrWebhookNotFound), errors.Is(err, customErrors.ErrDeliveryNotFound),
+		errors.Is(err, customErrors.ErrNoDeliveries):
+		return "not_found"
+	case errors.Is(err, customErrors.ErrInvalidData), errors.Is(err, customErrors.ErrJobActive):
+		return "rejected"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+var tracer = otel.Tracer("music-library/internal/database")
+
+// begin starts the span for a Service call. The returned func ends it and
+// records the call's metrics. Calls outside any trace, like the workers
+// polling for jobs, get no span of their own so they don't flood the
+// exporter with single-span traces.
+func begin(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	span := trace.SpanFromContext(ctx)
+	if span.SpanContext().IsValid() {
+		ctx, span = tracer.Start(ctx, "database."+method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.operation.name", method)))
+	}
+
+	return ctx, func(err error) {
+		callDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			kind := errorKind(err)
+			callErrors.WithLabelValues(method, kind).Inc()
+			span.SetAttributes(attribute.String("error.type", kind))
+			if kind != "not_found" && kind != "rejected" {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+		span.End()
+	}
+}
+
+// instrumented traces and records metrics for every call to the Service it
+// wraps.
+type instrumented struct {
+	next Service
+}
+
+// Instrument wraps s so every call gets a span, is timed and failures are
+// counted.
+// Optional interfaces like PoolReporter aren't passed through, check them on
+// s itself.
+func Instrument(s Service) Service {
+	return &instrumented{next: s}
+}
+
+func (i *instrumented) Close() error {
+	return i.next.Close()
+}
+
+func (i *instrumented) AddNewSong(ctx context.Context, song models.Song) (id int, err error) {
+	ctx, end := begin(ctx, "AddNewSong")
+	defer func() { end(err) }()
+	return i.next.AddNewSong(ctx, song)
+}
+
+func (i *instrumented) GetSongs(ctx context.Context, opts query.Options) (songs []models.Song, err error) {
+	ctx, end := begin(ctx, "GetSongs")
+	defer func() { end(err) }()
+	return i.next.GetSongs(ctx, opts)
+}
+
+func (i *instrumented) GetSongById(ctx context.Context, id string) (song models.Song, err error) {
+	ctx, end := begin(ctx, "GetSongById")
+	defer func() { end(err) }()
+	return i.next.GetSongById(ctx, id)
+}
+
+func (i *instrumented) UpdateSongById(ctx context.Context, id string, song models.Song) (err error) {
+	ctx, end := begin(ctx, "UpdateSongById")
+	defer func() { end(err) }()
+	return i.next.UpdateSongById(ctx, id, song)
+}
+
+func (i *instrumented) UpdateSongEnrichment(ctx context.Context, id int, song models.Song, status string) (err error) {
+	ctx, end := begin(ctx, "UpdateSongEnrichment")
+	defer func() { end(err) }()
+	return i.next.UpdateSongEnrichment(ctx, id, song, status)
+}
+
+func (i *instrumented) SetEnrichmentStatus(ctx context.Context, id int, status string) (err error) {
+	ctx, end := begin(ctx, "SetEnrichmentStatus")
+	defer func() { end(err) }()
+	return i.next.SetEnrichmentStatus(ctx, id, status)
+}
+
+func (i *instrumented) DeleteSongById(ctx context.Context, id string) (err error) {
+	ctx, end := begin(ctx, "DeleteSongById")
+	defer func() { end(err) }()
+	return i.next.DeleteSongById(ctx, id)
+}
+
+// WithTx is measured as a whole, retries included, and the calls made
+// through tx are measured on their own. Their spans hang off the context fn
+// passes them, not off the WithTx span.
+func (i *instrumented) WithTx(ctx context.Context, fn func(tx Service) error) (err error) {
+	ctx, end := begin(ctx, "WithTx")
+	defer func() { end(err) }()
+	return i.next.WithTx(ctx, func(tx Service) error {
+		return fn(&instrumented{next: tx})
+	})
+}
+
+func (i *instrumented) EnqueueJob(ctx context.Context, job models.Job) (id int, err error) {
+	ctx, end := begin(ctx, "EnqueueJob")
+	defer func() { end(err) }()
+	return i.next.EnqueueJob(ctx, job)
+}
+
+func (i *instrumented) ClaimJob(ctx context.Context, lease time.Duration) (job models.Job, err error) {
+	ctx, end := begin(ctx, "ClaimJob")
+	defer func() { end(err) }()
+	return i.next.ClaimJob(ctx, lease)
+}
+
+func (i *instrumented) CompleteJob(ctx context.Context, id int) (err error) {
+	ctx, end := begin(ctx, "CompleteJob")
+	defer func() { end(err) }()
+	return i.next.CompleteJob(ctx, id)
+}
+
+func (i *instrumented) FailJob(ctx context.Context, id int, reason string, retryAt time.Time) (job models.Job, err error) {
+	ctx, end := begin(ctx, "FailJob")
+	defer func() { end(err) }()
+	return i.next.FailJob(ctx, id, reason, retryAt)
+}
+
+func (i *instrumented) GetJobById(ctx context.Context, id string) (job models.Job, err error) {
+	ctx, end := begin(ctx, "GetJobById")
+	defer func() { end(err) }()
+	return i.next.GetJobById(ctx, id)
+}
+
+func (i *instrumented) RetryJob(ctx context.Context, id string) (job models.Job, err error) {
+	ctx, end := begin(ctx, "RetryJob")
+	defer func() { end(err) }()
+	return i.next.RetryJob(ctx, id)
+}
+
+func (i *instrumented) GetCachedInfo(ctx context.Context, key string) (entry models.CachedInfo, ok bool, err error) {
+	ctx, end := begin(ctx, "GetCachedInfo")
+	defer func() { end(err) }()
+	return i.next.GetCachedInfo(ctx, key)
+}
+
+func (i *instrumented) PutCachedInfo(ctx context.Context, key string, entry models.CachedInfo) (err error) {
+	ctx, end := begin(ctx, "PutCachedInfo")
+	defer func() { end(err) }()
+	return i.next.PutCachedInfo(ctx, key, entry)
+}
+
+func (i *instrumented) PruneCachedInfo(ctx context.Context, cutoff time.Time) (n int, err error) {
+	ctx, end := begin(ctx, "PruneCachedInfo")
+	defer func() { end(err) }()
+	return i.next.PruneCachedInfo(ctx, cutoff)
+}
+
+func (i *instrumented) EnqueueRefreshJobs(ctx context.Context, kind string, filter models.RefreshFilter) (n int, err error) {
+	ctx, end := begin(ctx, "EnqueueRefreshJobs")
+	defer func() { end(err) }()
+	return i.next.EnqueueRefreshJobs(ctx, kind, filter)
+}
+
+func (i *instrumented) ApplySongRefresh(ctx context.Context, id int, song models.Song, changes []models.FieldChange) (err error) {
+	ctx, end := begin(ctx, "ApplySongRefresh")
+	defer func() { end(err) }()
+	return i.next.ApplySongRefresh(ctx, id, song, changes)
+}
+
+func (i *instrumented) GetSongRefreshes(ctx context.Context, id string) (refreshes []models.SongRefresh, err error) {
+	ctx, end := begin(ctx, "GetSongRefreshes")
+	defer func() { end(err) }()
+	return i.next.GetSongRefreshes(ctx, id)
+}
+
+func (i *instrumented) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (created models.APIKey, err error) {
+	ctx, end := begin(ctx, "CreateAPIKey")
+	defer func() { end(err) }()
+	return i.next.CreateAPIKey(ctx, key, hash)
+}
+
+func (i *instrumented) GetAPIKeyByHash(ctx context.Context, hash string) (key models.APIKey, err error) {
+	ctx, end := begin(ctx, "GetAPIKeyByHash")
+	defer func() { end(err) }()
+	return i.next.GetAPIKeyByHash(ctx, hash)
+}
+
+func (i *instrumented) ListAPIKeys(ctx context.Context) (keys []models.APIKey, err error) {
+	ctx, end := begin(ctx, "ListAPIKeys")
+	defer func() { end(err) }()
+	return i.next.ListAPIKeys(ctx)
+}
+
+func (i *instrumented) RevokeAPIKey(ctx context.Context, id string) (err error) {
+	ctx, end := begin(ctx, "RevokeAPIKey")
+	defer func() { end(err) }()
+	return i.next.RevokeAPIKey(ctx, id)
+}
+
+func (i *instrumented) SetAPIKeyRateLimits(ctx context.Context, id string, limits map[string]models.RateLimit) (err error) {
+	ctx, end := begin(ctx, "SetAPIKeyRateLimits")
+	defer func() { end(err) }()
+	return i.next.SetAPIKeyRateLimits(ctx, id, limits)
+}
+
+func (i *instrumented) RecordAPIKeyUsage(ctx context.Context, id int, requests int64, lastUsed time.Time) (err error) {
+	ctx, end := begin(ctx, "RecordAPIKeyUsage")
+	defer func() { end(err) }()
+	return i.next.RecordAPIKeyUsage(ctx, id, requests, lastUsed)
+}
+
+func (i *instrumented) RecordAudit(ctx context.Context, entry models.AuditEntry) (err error) {
+	ctx, end := begin(ctx, "RecordAudit")
+	defer func() { end(err) }()
+	return i.next.RecordAudit(ctx, entry)
+}
+
+func (i *instrumented) ListAudit(ctx context.Context, filter models.AuditFilter) (entries []models.AuditEntry, err error) {
+	ctx, end := begin(ctx, "ListAudit")
+	defer func() { end(err) }()
+	return i.next.ListAudit(ctx, filter)
+}
+
+func (i *instrumented) CreateWebhook(ctx context.Context, hook models.Webhook) (created models.Webhook, err error) {
+	ctx, end := begin(ctx, "CreateWebhook")
+	defer func() { end(err) }()
+	return i.next.CreateWebhook(ctx, hook)
+}
+
+func (i *instrumented) GetWebhook(ctx context.Context, id string) (hook models.Webhook, err error) {
+	ctx, end := begin(ctx, "GetWebhook")
+	defer func() { end(err) }()
+	return i.next.GetWebhook(ctx, id)
+}
+
+func (i *instrumented) ListWebhooks(ctx context.Context) (hooks []models.Webhook, err error) {
+	ctx, end := begin(ctx, "ListWebhooks")
+	defer func() { end(err) }()
+	return i.next.ListWebhooks(ctx)
+}
+
+func (i *instrumented) UpdateWebhook(ctx context.Context, id string, hook models.Webhook) (updated models.Webhook, err error) {
+	ctx, end := begin(ctx, "UpdateWebhook")
+	defer func() { end(err) }()
+	return i.next.UpdateWebhook(ctx, id, hook)
+}
+
+func (i *instrumented) DeleteWebhook(ctx context.Context, id string) (err error) {
+	ctx, end := begin(ctx, "DeleteWebhook")
+	defer func() { end(err) }()
+	return i.next.DeleteWebhook(ctx, id)
+}
+
+func (i *instrumented) EnqueueWebhookEvent(ctx context.Context, event models.Event) (n int, err error) {
+	ctx, end := begin(ctx, "EnqueueWebhookEvent")
+	defer func() { end(err) }()
+	return i.next.EnqueueWebhookEvent(ctx, event)
+}
+
+func (i *instrumented) ClaimDelivery(ctx context.Context, lease time.Duration) (delivery models.WebhookDelivery, err error) {
+	ctx, end := begin(ctx, "ClaimDelivery")
+	defer func() { end(err) }()
+	return i.next.ClaimDelivery(ctx, lease)
+}
+
+func (i *instrumented) CompleteDelivery(ctx context.Context, id int, responseStatus int) (err error) {
+	ctx, end := begin(ctx, "CompleteDelivery")
+	defer func() { end(err) }()
+	return i.next.CompleteDelivery(ctx, id, responseStatus)
+}
+
+func (i *instrumented) FailDelivery(ctx context.Context, id int, responseStatus int, reason string, retryAt time.Time) (delivery models.WebhookDelivery, err error) {
+	ctx, end := begin(ctx, "FailDelivery")
+	defer func() { end(err) }()
+	return i.next.FailDelivery(ctx, id, responseStatus, reason, retryAt)
+}
+
+func (i *instrumented) ListDeliveries(ctx context.Context, webhookId string, limit, offset int) (deliveries []models.WebhookDelivery, err error) {
+	ctx, end := begin(ctx, "ListDeliveries")
+	defer func() { end(err) }()
+	return i.next.ListDeliveries(ctx, webhookId, limit, offset)
+}
+
+func (i *instrumented) Redeliver(ctx context.Context, webhookId, deliveryId string) (delivery models.WebhookDelivery, err error) {
+	ctx, end := begin(ctx, "Redeliver")
+	defer func() { end(err) }()
+	return i.next.Redeliver(ctx, webhookId, deliveryId)
+}
+
+func (i *instrumented) AppendOutbox(ctx context.Context, event models.Event) (err error) {
+	ctx, end := begin(ctx, "AppendOutbox")
+	defer func() { end(err) }()
+	return i.next.AppendOutbox(ctx, event)
+}
+
+func (i *instrumented) SequenceOutbox(ctx context.Context) (n int, err error) {
+	ctx, end := begin(ctx, "SequenceOutbox")
+	defer func() { end(err) }()
+	return i.next.SequenceOutbox(ctx)
+}
+
+func (i *instrumented) ClaimOutbox(ctx context.Context, sink string, limit int) (events []models.Event, err error) {
+	ctx, end := begin(ctx, "ClaimOutbox")
+	defer func() { end(err) }()
+	return i.next.ClaimOutbox(ctx, sink, limit)
+}
+
+func (i *instrumented) AckOutbox(ctx context.Context, sink string, seq int64) (err error) {
+	ctx, end := begin(ctx, "AckOutbox")
+	defer func() { end(err) }()
+	return i.next.AckOutbox(ctx, sink, seq)
+}
+
+func (i *instrumented) ListOutbox(ctx context.Context, after int64, limit int) (events []models.Event, err error) {
+	ctx, end := begin(ctx, "ListOutbox")
+	defer func() { end(err) }()
+	return i.next.ListOutbox(ctx, after, limit)
+}
+
+func (i *instrumented) LatestOutbox(ctx context.Context, limit int) (events []models.Event, err error) {
+	ctx, end := begin(ctx, "LatestOutbox")
+	defer func() { end(err) }()
+	return i.next.LatestOutbox(ctx, limit)
+}
+
+func (i *instrumented) PruneOutbox(ctx context.Context, cutoff time.Time, sinks []string) (n int, err error) {
+	ctx, end := begin(ctx, "PruneOutbox")
+	defer func() { end(err) }()
+	return i.next.PruneOutbox(ctx, cutoff, sinks)
+}
+
+func (i *instrumented) ListArtists(ctx context.Context, paginator query.Paginator) (artists []models.Artist, err error) {
+	ctx, end := begin(ctx, "ListArtists")
+	defer func() { end(err) }()
+	return i.next.ListArtists(ctx, paginator)
+}
+
+func (i *instrumented) GetArtistsByName(ctx context.Context, names []string) (artists []models.Artist, err error) {
+	ctx, end := begin(ctx, "GetArtistsByName")
+	defer func() { end(err) }()
+	return i.next.GetArtistsByName(ctx, names)
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type JobQueue interface {
+	EnqueueJob(ctx context.Context, job models.Job) (int, error)
+	ClaimJob(ctx context.Context, lease time.Duration) (models.Job, error)
+	CompleteJob(ctx context.Context, id int) error
+	FailJob(ctx context.Context, id int, reason string, retryAt time.Time) (models.Job, error)
+	GetJobById(ctx context.Context, id string) (models.Job, error)
+	RetryJob(ctx context.Context, id string) (models.Job, error)
+}
+
+const jobColumns = "id, kind, song_id, status, attempts, max_attempts, last_error, run_at, created_at, updated_at"
+
+func (s *service) EnqueueJob(ctx context.Context, job models.Job) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "EnqueueJob")
+	defer done()
+
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = 5
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+
+	var id int
+	err := s.q.QueryRow(ctx, "INSERT INTO jobs (kind, song_id, max_attempts, run_at) VALUES ($1, $2, $3, $4) RETURNING id", job.Kind, job.SongId, job.MaxAttempts, job.RunAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ClaimJob hands out the oldest due job, or one whose previous worker let its
+// lease expire. SKIP LOCKED lets any number of workers poll concurrently.
+func (s *service) ClaimJob(ctx context.Context, lease time.Duration) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ClaimJob")
+	defer done()
+
+	job, err := scanJob(s.q.QueryRow(ctx, `UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_until = now() + make_interval(secs => $1), updated_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'queued' AND run_at <= now()) OR (status = 'running' AND locked_until < now())
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, lease.Seconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return job, customErrors.ErrNoJobs
+	}
+	return job, err
+}
+
+func (s *service) CompleteJob(ctx context.Context, id int) error {
+	ctx, done := withTimeout(ctx, s.timeout, "CompleteJob")
+	defer done()
+
+	_, err := s.q.Exec(ctx, "UPDATE jobs SET status = 'succeeded', last_error = '', locked_until = NULL, updated_at = now() WHERE id = $1", id)
+	return err
+}
+
+// FailJob puts the job back in the queue until retryAt, or marks it failed
+// once it has used up its attempts or retryAt is zero.
+func (s *service) FailJob(ctx context.Context, id int, reason string, retryAt time.Time) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "FailJob")
+	defer done()
+
+	job, err := scanJob(s.q.QueryRow(ctx, `UPDATE jobs
+		SET status = CASE WHEN $3 AND attempts < max_attempts THEN 'queued' ELSE 'failed' END,
+			last_error = $2, run_at = COALESCE($4, run_at), locked_until = NULL, updated_at = now()
+		WHERE id = $1
+		RETURNING `+jobColumns, id, reason, !retryAt.IsZero(), sql.NullTime{Time: retryAt, Valid: !retryAt.IsZero()}))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return job, customErrors.ErrJobNotFound
+	}
+	return job, err
+}
+
+func (s *service) GetJobById(ctx context.Context, id string) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetJobById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+
+	job, err := scanJob(s.q.QueryRow(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", n))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return job, customErrors.ErrJobNotFound
+	}
+	return job, err
+}
+
+func (s *service) RetryJob(ctx context.Context, id string) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "RetryJob")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+
+	job, err := scanJob(s.q.QueryRow(ctx, `UPDATE jobs
+		SET status = 'queued', attempts = 0, last_error = '', run_at = now(), locked_until = NULL, updated_at = now()
+		WHERE id = $1 AND status NOT IN ('queued', 'running')
+		RETURNING `+jobColumns, n))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := s.GetJobById(ctx, id); err != nil {
+			return job, err
+		}
+		return job, customErrors.ErrJobActive
+	}
+	return job, err
+}
+
+func scanJob(row scanner) (models.Job, error) {
+	var job models.Job
+	err := row.Scan(&job.Id, &job.Kind, &job.SongId, &job.Status, &job.Attempts, &job.MaxAttempts, &job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+	return job, err
+}
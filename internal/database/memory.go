@@ -0,0 +1,1273 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+)
+
+// memory is a Service that keeps everything in process memory. It follows
+// the Postgres implementation's filtering, pagination and not-found rules,
+// which the dbtest conformance suite checks for both. It has nothing to
+// cancel, so a context only matters if it is already done.
+type memory struct {
+	// mu guards the state. A Service handed to a WithTx func gets a no-op
+	// lock instead, the transaction holds the real one while it runs.
+	mu sync.Locker
+	tx bool
+	*memoryState
+}
+
+type memoryState struct {
+	songs     map[int]models.Song
+	lastSong  int
+	artists   []models.Artist
+	jobs      map[int]memoryJob
+	lastJob   int
+	cache     map[string]models.CachedInfo
+	refreshes []models.SongRefresh
+	// refreshQueued is when a refresh was last queued for each song.
+	refreshQueued map[int]time.Time
+	keys          map[int]memoryKey
+	lastKey       int
+	audit         []models.AuditEntry
+	hooks         map[int]models.Webhook
+	lastHook      int
+	deliveries    map[int]memoryDelivery
+	lastDelivery  int
+	outbox        []models.Event
+	lastSeq       int64
+	cursors       map[string]int64
+	now           func() time.Time
+}
+
+type memoryKey struct {
+	models.APIKey
+	hash string
+}
+
+type memoryJob struct {
+	models.Job
+	lockedUntil time.Time
+}
+
+type memoryDelivery struct {
+	models.WebhookDelivery
+	lockedUntil time.Time
+}
+
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+func NewMemory() Service {
+	return &memory{
+		mu: &sync.Mutex{},
+		memoryState: &memoryState{
+			songs:         make(map[int]models.Song),
+			jobs:          make(map[int]memoryJob),
+			cache:         make(map[string]models.CachedInfo),
+			refreshQueued: make(map[int]time.Time),
+			keys:          make(map[int]memoryKey),
+			hooks:         make(map[int]models.Webhook),
+			deliveries:    make(map[int]memoryDelivery),
+			cursors:       make(map[string]int64),
+			now:           time.Now,
+		},
+	}
+}
+
+// WithTx runs fn with every other call blocked and puts the previous state
+// back if fn fails. Calls inside fn must go through tx, m would deadlock.
+func (m *memory) WithTx(ctx context.Context, fn func(tx Service) error) error {
+	if m.tx {
+		return fn(m)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := m.memoryState.clone()
+	if err := fn(&memory{mu: noLock{}, tx: true, memoryState: m.memoryState}); err != nil {
+		*m.memoryState = *snapshot
+		return err
+	}
+	return nil
+}
+
+// clone copies the state deep enough for a rollback: stored values are
+// replaced on update, never changed in place.
+func (st *memoryState) clone() *memoryState {
+	c := *st
+	c.songs = maps.Clone(st.songs)
+	c.artists = slices.Clone(st.artists)
+	c.jobs = maps.Clone(st.jobs)
+	c.cache = maps.Clone(st.cache)
+	c.refreshes = slices.Clone(st.refreshes)
+	c.refreshQueued = maps.Clone(st.refreshQueued)
+	c.keys = maps.Clone(st.keys)
+	c.audit = slices.Clone(st.audit)
+	c.hooks = maps.Clone(st.hooks)
+	c.deliveries = maps.Clone(st.deliveries)
+	c.outbox = slices.Clone(st.outbox)
+	c.cursors = maps.Clone(st.cursors)
+	return &c
+}
+
+func (m *memory) Close() error {
+	return nil
+}
+
+func (m *memory) AddNewSong(ctx context.Context, song models.Song) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if song.EnrichmentStatus == "" {
+		song.EnrichmentStatus = models.EnrichmentPending
+	}
+	m.lastSong++
+	song.Id = m.lastSong
+	song.EnrichedAt = nil
+	song.EditedFields = nil
+	song.UpdatedBy = ""
+	m.songs[song.Id] = song
+	if !slices.ContainsFunc(m.artists, func(a models.Artist) bool { return a.Artist == song.Group }) {
+		m.artists = append(m.artists, models.Artist{Id: len(m.artists) + 1, Artist: song.Group})
+	}
+
+	return song.Id, nil
+}
+
+func (m *memory) GetSongs(ctx context.Context, opts query.Options) ([]models.Song, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	match := make([]func(models.Song) bool, 0, len(opts.Filters))
+	for _, filter := range opts.Filters {
+		f, err := memoryFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		match = append(match, f)
+	}
+	if opts.Search != "" {
+		match = append(match, memorySearch(opts.Search))
+	}
+
+	songs := []models.Song{}
+	for _, song := range m.songs {
+		if !slices.ContainsFunc(match, func(f func(models.Song) bool) bool { return !f(song) }) {
+			songs = append(songs, cloneSong(song))
+		}
+	}
+	slices.SortFunc(songs, func(a, b models.Song) int { return cmp.Compare(a.Id, b.Id) })
+
+	return paginate(songs, opts.Paginator), nil
+}
+
+func (m *memory) GetSongById(ctx context.Context, id string) (models.Song, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Song{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Song{}, customErrors.ErrNotFound
+	}
+	song, ok := m.songs[n]
+	if !ok {
+		return models.Song{}, customErrors.ErrNotFound
+	}
+	return cloneSong(song), nil
+}
+
+func (m *memory) UpdateSongById(ctx context.Context, id string, song models.Song) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+	stored, ok := m.songs[n]
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+
+	stored.Song = song.Song
+	stored.ReleaseDate = song.ReleaseDate
+	stored.Text = song.Text
+	stored.Link = song.Link
+	stored.EditedFields = slices.Clone(song.EditedFields)
+	stored.UpdatedBy = song.UpdatedBy
+	m.songs[n] = stored
+
+	return nil
+}
+
+func (m *memory) UpdateSongEnrichment(ctx context.Context, id int, song models.Song, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.songs[id]
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+
+	if time.Time(stored.ReleaseDate).IsZero() {
+		stored.ReleaseDate = song.ReleaseDate
+	}
+	if stored.Text == "" {
+		stored.Text = song.Text
+	}
+	if stored.Link == "" {
+		stored.Link = song.Link
+	}
+	stored.EnrichmentStatus = status
+	now := m.now()
+	stored.EnrichedAt = &now
+	m.songs[id] = stored
+
+	return nil
+}
+
+func (m *memory) SetEnrichmentStatus(ctx context.Context, id int, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.songs[id]
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+	stored.EnrichmentStatus = status
+	m.songs[id] = stored
+
+	return nil
+}
+
+func (m *memory) DeleteSongById(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+	if _, ok := m.songs[n]; !ok {
+		return customErrors.ErrNotFound
+	}
+
+	delete(m.songs, n)
+	for jobId, job := range m.jobs {
+		if job.SongId == n {
+			delete(m.jobs, jobId)
+		}
+	}
+	m.refreshes = slices.DeleteFunc(m.refreshes, func(r models.SongRefresh) bool { return r.SongId == n })
+
+	return nil
+}
+
+func (m *memory) ListArtists(ctx context.Context, paginator query.Paginator) ([]models.Artist, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(paginate(m.artists, paginator)), nil
+}
+
+func (m *memory) GetArtistsByName(ctx context.Context, names []string) ([]models.Artist, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	artists := []models.Artist{}
+	for _, artist := range m.artists {
+		if slices.Contains(names, artist.Artist) {
+			artists = append(artists, artist)
+		}
+	}
+	return artists, nil
+}
+
+func (m *memory) EnqueueJob(ctx context.Context, job models.Job) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.enqueue(job)
+}
+
+func (m *memory) enqueue(job models.Job) (int, error) {
+	if _, ok := m.songs[job.SongId]; !ok {
+		return 0, customErrors.ErrNotFound
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = 5
+	}
+	now := m.now()
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	m.lastJob++
+	job.Id = m.lastJob
+	job.Status = models.JobQueued
+	job.Attempts = 0
+	job.LastError = ""
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	m.jobs[job.Id] = memoryJob{Job: job}
+
+	return job.Id, nil
+}
+
+func (m *memory) ClaimJob(ctx context.Context, lease time.Duration) (models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var next *memoryJob
+	for _, job := range m.jobs {
+		ready := (job.Status == models.JobQueued && !job.RunAt.After(now)) ||
+			(job.Status == models.JobRunning && job.lockedUntil.Before(now))
+		if !ready {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) || (job.RunAt.Equal(next.RunAt) && job.Id < next.Id) {
+			next = &job
+		}
+	}
+	if next == nil {
+		return models.Job{}, customErrors.ErrNoJobs
+	}
+
+	next.Status = models.JobRunning
+	next.Attempts++
+	next.lockedUntil = now.Add(lease)
+	next.UpdatedAt = now
+	m.jobs[next.Id] = *next
+
+	return next.Job, nil
+}
+
+func (m *memory) CompleteJob(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil
+	}
+	job.Status = models.JobSucceeded
+	job.LastError = ""
+	job.lockedUntil = time.Time{}
+	job.UpdatedAt = m.now()
+	m.jobs[id] = job
+
+	return nil
+}
+
+func (m *memory) FailJob(ctx context.Context, id int, reason string, retryAt time.Time) (models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+
+	if !retryAt.IsZero() && job.Attempts < job.MaxAttempts {
+		job.Status = models.JobQueued
+		job.RunAt = retryAt
+	} else {
+		job.Status = models.JobFailed
+	}
+	job.LastError = reason
+	job.lockedUntil = time.Time{}
+	job.UpdatedAt = m.now()
+	m.jobs[id] = job
+
+	return job.Job, nil
+}
+
+func (m *memory) GetJobById(ctx context.Context, id string) (models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+	job, ok := m.jobs[n]
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+	return job.Job, nil
+}
+
+func (m *memory) RetryJob(ctx context.Context, id string) (models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+	job, ok := m.jobs[n]
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+	if job.Status == models.JobQueued || job.Status == models.JobRunning {
+		return models.Job{}, customErrors.ErrJobActive
+	}
+
+	now := m.now()
+	job.Status = models.JobQueued
+	job.Attempts = 0
+	job.LastError = ""
+	job.RunAt = now
+	job.lockedUntil = time.Time{}
+	job.UpdatedAt = now
+	m.jobs[n] = job
+
+	return job.Job, nil
+}
+
+func (m *memory) GetCachedInfo(ctx context.Context, key string) (models.CachedInfo, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return models.CachedInfo{}, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.cache[key]
+	if !ok || !m.now().Before(entry.ExpiresAt) {
+		return models.CachedInfo{}, false, nil
+	}
+	return entry, true, nil
+}
+
+func (m *memory) PutCachedInfo(ctx context.Context, key string, entry models.CachedInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cache[key] = entry
+	return nil
+}
+
+func (m *memory) PruneCachedInfo(ctx context.Context, cutoff time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for key, entry := range m.cache {
+		if entry.ExpiresAt.Before(cutoff) {
+			delete(m.cache, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memory) EnqueueRefreshJobs(ctx context.Context, kind string, filter models.RefreshFilter) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	busy := make(map[int]bool)
+	for _, job := range m.jobs {
+		if job.Status == models.JobQueued || job.Status == models.JobRunning {
+			busy[job.SongId] = true
+		}
+	}
+
+	var songs []models.Song
+	for _, song := range m.songs {
+		switch {
+		case song.EnrichmentStatus == models.EnrichmentPending || busy[song.Id]:
+		case filter.SongId != 0 && song.Id != filter.SongId:
+		case filter.Group != "" && song.Group != filter.Group:
+		case !filter.EnrichedBefore.IsZero() && (song.EnrichedAt == nil || !song.EnrichedAt.Before(filter.EnrichedBefore)):
+		case !filter.QueuedBefore.IsZero() && !m.refreshQueued[song.Id].IsZero() && !m.refreshQueued[song.Id].Before(filter.QueuedBefore):
+		default:
+			songs = append(songs, song)
+		}
+	}
+
+	// never enriched first, then oldest, like NULLS FIRST in Postgres
+	slices.SortFunc(songs, func(a, b models.Song) int {
+		switch {
+		case a.EnrichedAt == nil && b.EnrichedAt != nil:
+			return -1
+		case a.EnrichedAt != nil && b.EnrichedAt == nil:
+			return 1
+		case a.EnrichedAt != nil && !a.EnrichedAt.Equal(*b.EnrichedAt):
+			return a.EnrichedAt.Compare(*b.EnrichedAt)
+		default:
+			return cmp.Compare(a.Id, b.Id)
+		}
+	})
+	if filter.Limit > 0 && len(songs) > filter.Limit {
+		songs = songs[:filter.Limit]
+	}
+
+	for _, song := range songs {
+		if _, err := m.enqueue(models.Job{Kind: kind, SongId: song.Id}); err != nil {
+			return 0, err
+		}
+		m.refreshQueued[song.Id] = m.now()
+	}
+	return len(songs), nil
+}
+
+func (m *memory) ApplySongRefresh(ctx context.Context, id int, song models.Song, changes []models.FieldChange) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.songs[id]
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+
+	now := m.now()
+	stored.ReleaseDate = song.ReleaseDate
+	stored.Text = song.Text
+	stored.Link = song.Link
+	stored.EnrichmentStatus = models.EnrichmentDone
+	stored.EnrichedAt = &now
+	m.songs[id] = stored
+
+	if len(changes) > 0 {
+		m.refreshes = append(m.refreshes, models.SongRefresh{
+			Id:        len(m.refreshes) + 1,
+			SongId:    id,
+			Changes:   slices.Clone(changes),
+			CreatedAt: now,
+		})
+	}
+	return nil
+}
+
+func (m *memory) GetSongRefreshes(ctx context.Context, id string) ([]models.SongRefresh, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshes := []models.SongRefresh{}
+	n, ok := parseId(id)
+	if !ok {
+		return refreshes, nil
+	}
+	for i := len(m.refreshes) - 1; i >= 0; i-- {
+		if m.refreshes[i].SongId == n {
+			refreshes = append(refreshes, m.refreshes[i])
+		}
+	}
+	return refreshes, nil
+}
+
+func (m *memory) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return models.APIKey{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.keys {
+		if stored.hash == hash {
+			return models.APIKey{}, customErrors.ErrInvalidData
+		}
+	}
+
+	m.lastKey++
+	key.Id = m.lastKey
+	key.Scopes = slices.Clone(key.Scopes)
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	key.CreatedAt = m.now()
+	key.LastUsedAt = nil
+	key.RequestCount = 0
+	key.RevokedAt = nil
+	key.RateLimits = nil
+	m.keys[key.Id] = memoryKey{APIKey: key, hash: hash}
+	return cloneAPIKey(key), nil
+}
+
+func (m *memory) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return models.APIKey{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.keys {
+		if stored.hash == hash && stored.RevokedAt == nil {
+			return cloneAPIKey(stored.APIKey), nil
+		}
+	}
+	return models.APIKey{}, customErrors.ErrKeyNotFound
+}
+
+func (m *memory) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := []models.APIKey{}
+	for _, id := range slices.Sorted(maps.Keys(m.keys)) {
+		keys = append(keys, cloneAPIKey(m.keys[id].APIKey))
+	}
+	return keys, nil
+}
+
+func (m *memory) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	stored, ok := m.keys[n]
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	if stored.RevokedAt == nil {
+		now := m.now()
+		stored.RevokedAt = &now
+		m.keys[n] = stored
+	}
+	return nil
+}
+
+func (m *memory) SetAPIKeyRateLimits(ctx context.Context, id string, limits map[string]models.RateLimit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	stored, ok := m.keys[n]
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	stored.RateLimits = maps.Clone(limits)
+	if len(stored.RateLimits) == 0 {
+		stored.RateLimits = nil
+	}
+	m.keys[n] = stored
+	return nil
+}
+
+func (m *memory) RecordAPIKeyUsage(ctx context.Context, id int, requests int64, lastUsed time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.keys[id]
+	if !ok {
+		return nil
+	}
+	stored.RequestCount += requests
+	if stored.LastUsedAt == nil || stored.LastUsedAt.Before(lastUsed) {
+		stored.LastUsedAt = &lastUsed
+	}
+	m.keys[id] = stored
+	return nil
+}
+
+// RecordAudit keeps entries for the process lifetime. Unlike the SQL
+// backends memory doesn't record artists being added.
+func (m *memory) RecordAudit(ctx context.Context, entry models.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.Id = int64(len(m.audit) + 1)
+	entry.At = m.now()
+	entry.Before = slices.Clone(entry.Before)
+	entry.After = slices.Clone(entry.After)
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *memory) ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []models.AuditEntry{}
+	for _, entry := range slices.Backward(m.audit) {
+		if (filter.Actor == "" || entry.Actor == filter.Actor) &&
+			(filter.Resource == "" || entry.Resource == filter.Resource) &&
+			(filter.ResourceId == "" || entry.ResourceId == filter.ResourceId) &&
+			(filter.From.IsZero() || !entry.At.Before(filter.From)) &&
+			(filter.To.IsZero() || entry.At.Before(filter.To)) {
+			entries = append(entries, entry)
+		}
+	}
+
+	limit := filter.Limit
+	if limit < 1 {
+		limit = 10
+	}
+	offset := min(max(filter.Offset, 0), len(entries))
+	return entries[offset:min(offset+limit, len(entries))], nil
+}
+
+func (m *memory) CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Webhook{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.lastHook++
+	hook.Id = m.lastHook
+	hook.Events = slices.Clone(hook.Events)
+	hook.CreatedAt = now
+	hook.UpdatedAt = now
+	m.hooks[hook.Id] = hook
+	return cloneWebhook(hook), nil
+}
+
+func (m *memory) GetWebhook(ctx context.Context, id string) (models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Webhook{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.webhook(id)
+}
+
+func (m *memory) webhook(id string) (models.Webhook, error) {
+	n, ok := parseId(id)
+	if !ok {
+		return models.Webhook{}, customErrors.ErrWebhookNotFound
+	}
+	hook, ok := m.hooks[n]
+	if !ok {
+		return models.Webhook{}, customErrors.ErrWebhookNotFound
+	}
+	return cloneWebhook(hook), nil
+}
+
+func (m *memory) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hooks := []models.Webhook{}
+	for _, id := range slices.Sorted(maps.Keys(m.hooks)) {
+		hooks = append(hooks, cloneWebhook(m.hooks[id]))
+	}
+	return hooks, nil
+}
+
+func (m *memory) UpdateWebhook(ctx context.Context, id string, hook models.Webhook) (models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Webhook{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.webhook(id)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	stored.URL = hook.URL
+	stored.Events = slices.Clone(hook.Events)
+	stored.Description = hook.Description
+	stored.Active = hook.Active
+	if hook.Secret != "" {
+		stored.Secret = hook.Secret
+	}
+	stored.UpdatedAt = m.now()
+	m.hooks[stored.Id] = stored
+	return cloneWebhook(stored), nil
+}
+
+func (m *memory) DeleteWebhook(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hook, err := m.webhook(id)
+	if err != nil {
+		return err
+	}
+	delete(m.hooks, hook.Id)
+	maps.DeleteFunc(m.deliveries, func(_ int, d memoryDelivery) bool { return d.WebhookId == hook.Id })
+	return nil
+}
+
+func (m *memory) EnqueueWebhookEvent(ctx context.Context, event models.Event) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payload, err := eventPayload(event)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range slices.Sorted(maps.Keys(m.hooks)) {
+		if hook := m.hooks[id]; hook.Active && slices.Contains(hook.Events, event.Type) {
+			m.addDelivery(models.WebhookDelivery{WebhookId: id, EventId: event.Id, EventType: event.Type, Payload: json.RawMessage(payload)})
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memory) addDelivery(delivery models.WebhookDelivery) models.WebhookDelivery {
+	now := m.now()
+	m.lastDelivery++
+	delivery.Id = m.lastDelivery
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.ResponseStatus = 0
+	delivery.LastError = ""
+	delivery.NextAttemptAt = now
+	delivery.DeliveredAt = nil
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+	m.deliveries[delivery.Id] = memoryDelivery{WebhookDelivery: delivery}
+	return delivery
+}
+
+func (m *memory) ClaimDelivery(ctx context.Context, lease time.Duration) (models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var next *memoryDelivery
+	for _, d := range m.deliveries {
+		ready := (d.Status == models.DeliveryPending && !d.NextAttemptAt.After(now)) ||
+			(d.Status == models.DeliveryDelivering && d.lockedUntil.Before(now))
+		if !ready {
+			continue
+		}
+		if next == nil || d.NextAttemptAt.Before(next.NextAttemptAt) || (d.NextAttemptAt.Equal(next.NextAttemptAt) && d.Id < next.Id) {
+			next = &d
+		}
+	}
+	if next == nil {
+		return models.WebhookDelivery{}, customErrors.ErrNoDeliveries
+	}
+
+	next.Status = models.DeliveryDelivering
+	next.Attempts++
+	next.lockedUntil = now.Add(lease)
+	next.UpdatedAt = now
+	m.deliveries[next.Id] = *next
+	return next.WebhookDelivery, nil
+}
+
+func (m *memory) CompleteDelivery(ctx context.Context, id int, responseStatus int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok {
+		return nil
+	}
+	now := m.now()
+	d.Status = models.DeliverySucceeded
+	d.ResponseStatus = responseStatus
+	d.LastError = ""
+	d.lockedUntil = time.Time{}
+	d.DeliveredAt = &now
+	d.UpdatedAt = now
+	m.deliveries[id] = d
+	return nil
+}
+
+func (m *memory) FailDelivery(ctx context.Context, id int, responseStatus int, reason string, retryAt time.Time) (models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok {
+		return models.WebhookDelivery{}, customErrors.ErrDeliveryNotFound
+	}
+	if retryAt.IsZero() {
+		d.Status = models.DeliveryFailed
+	} else {
+		d.Status = models.DeliveryPending
+		d.NextAttemptAt = retryAt
+	}
+	d.ResponseStatus = responseStatus
+	d.LastError = reason
+	d.lockedUntil = time.Time{}
+	d.UpdatedAt = m.now()
+	m.deliveries[id] = d
+	return d.WebhookDelivery, nil
+}
+
+func (m *memory) ListDeliveries(ctx context.Context, webhookId string, limit, offset int) ([]models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hook, err := m.webhook(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []models.WebhookDelivery{}
+	for _, id := range slices.Backward(slices.Sorted(maps.Keys(m.deliveries))) {
+		if d := m.deliveries[id]; d.WebhookId == hook.Id {
+			deliveries = append(deliveries, d.WebhookDelivery)
+		}
+	}
+	offset = min(offset, len(deliveries))
+	return deliveries[offset:min(offset+limit, len(deliveries))], nil
+}
+
+func (m *memory) Redeliver(ctx context.Context, webhookId, deliveryId string) (models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hookId, hookOk := parseId(webhookId)
+	n, ok := parseId(deliveryId)
+	if !hookOk || !ok {
+		return models.WebhookDelivery{}, customErrors.ErrDeliveryNotFound
+	}
+	d, ok := m.deliveries[n]
+	if !ok || d.WebhookId != hookId {
+		return models.WebhookDelivery{}, customErrors.ErrDeliveryNotFound
+	}
+	return m.addDelivery(models.WebhookDelivery{WebhookId: d.WebhookId, EventId: d.EventId, EventType: d.EventType, Payload: d.Payload}), nil
+}
+
+func (m *memory) AppendOutbox(ctx context.Context, event models.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.Seq = 0
+	event.Data = slices.Clone(event.Data)
+	m.outbox = append(m.outbox, event)
+	return nil
+}
+
+func (m *memory) SequenceOutbox(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for i := range m.outbox {
+		if m.outbox[i].Seq == 0 {
+			m.lastSeq++
+			m.outbox[i].Seq = m.lastSeq
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ClaimOutbox needs no lock of its own, WithTx blocks every other call.
+func (m *memory) ClaimOutbox(ctx context.Context, sink string, limit int) ([]models.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// like the cursor rows, a sink holds back pruning from its first claim
+	after, ok := m.cursors[sink]
+	if !ok {
+		m.cursors[sink] = 0
+	}
+	return m.listOutbox(after, limit), nil
+}
+
+func (m *memory) AckOutbox(ctx context.Context, sink string, seq int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cursors[sink] = seq
+	return nil
+}
+
+func (m *memory) ListOutbox(ctx context.Context, after int64, limit int) ([]models.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.listOutbox(after, limit), nil
+}
+
+func (m *memory) listOutbox(after int64, limit int) []models.Event {
+	events := []models.Event{}
+	for _, event := range m.outbox {
+		if len(events) == limit {
+			break
+		}
+		if event.Seq > after {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (m *memory) LatestOutbox(ctx context.Context, limit int) ([]models.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []models.Event{}
+	for _, event := range m.outbox {
+		if event.Seq != 0 {
+			events = append(events, event)
+		}
+	}
+	return events[max(len(events)-limit, 0):], nil
+}
+
+func (m *memory) PruneOutbox(ctx context.Context, cutoff time.Time, sinks []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivered := m.lastSeq
+	for _, sink := range sinks {
+		// a sink without a cursor has had none
+		delivered = min(delivered, m.cursors[sink])
+	}
+	n := len(m.outbox)
+	m.outbox = slices.DeleteFunc(m.outbox, func(event models.Event) bool {
+		return event.Seq != 0 && event.Seq < m.lastSeq && event.Seq <= delivered && event.CreatedAt.Before(cutoff)
+	})
+	return n - len(m.outbox), nil
+}
+
+func memoryFilter(filter query.Filter) (func(models.Song) bool, error) {
+	switch filter.Field {
+	case "group":
+		return func(s models.Song) bool { return s.Group == filter.Value }, nil
+	case "song":
+		return func(s models.Song) bool { return s.Song == filter.Value }, nil
+	case "releaseDate":
+		date, err := models.ParseDate(filter.Value)
+		if err != nil {
+			return nil, customErrors.ErrInvalidData
+		}
+		return func(s models.Song) bool {
+			return !time.Time(s.ReleaseDate).IsZero() && s.ReleaseDate.String() == date.String()
+		}, nil
+	case "text":
+		return func(s models.Song) bool { return s.Text == filter.Value }, nil
+	case "link":
+		return func(s models.Song) bool { return s.Link == filter.Value }, nil
+	default:
+		return nil, customErrors.ErrInvalidData
+	}
+}
+
+func paginate[T any](items []T, paginator query.Paginator) []T {
+	limit, offset := paginator.Bounds()
+	if offset >= len(items) {
+		return []T{}
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
+
+func cloneSong(song models.Song) models.Song {
+	song.EditedFields = slices.Clone(song.EditedFields)
+	if song.EnrichedAt != nil {
+		enrichedAt := *song.EnrichedAt
+		song.EnrichedAt = &enrichedAt
+	}
+	return song
+}
+
+func cloneWebhook(hook models.Webhook) models.Webhook {
+	hook.Events = slices.Clone(hook.Events)
+	return hook
+}
+
+func cloneAPIKey(key models.APIKey) models.APIKey {
+	key.Scopes = slices.Clone(key.Scopes)
+	key.RateLimits = maps.Clone(key.RateLimits)
+	return key
+}
+
+func memorySearch(search string) func(models.Song) bool {
+	words := searchWords(search)
+	return func(s models.Song) bool {
+		tokens := searchWords(s.Song + " " + s.Text)
+		for _, word := range words {
+			if !slices.Contains(tokens, word) {
+				return false
+			}
+		}
+		return true
+	}
+}
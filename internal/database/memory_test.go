@@ -0,0 +1,12 @@
+package database_test
+
+import (
+	"testing"
+
+	"music-library/internal/database"
+	"music-library/internal/database/dbtest"
+)
+
+func TestMemory(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.Service { return database.NewMemory() })
+}
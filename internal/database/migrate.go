@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"music-library/internal/config"
+	"music-library/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratedb "github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	migratesource "github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Migrator applies a backend's embedded migrations to its database.
+type Migrator struct {
+	m      *migrate.Migrate
+	db     *sql.DB
+	latest uint
+}
+
+type MigrationStatus struct {
+	Version uint
+	Latest  uint
+	Dirty   bool
+}
+
+// OpenMigrator connects to the database cfg points at.
+func OpenMigrator(db config.Database) (*Migrator, error) {
+	switch db.Driver {
+	case "postgres":
+		cfg, err := poolConfig(db)
+		if err != nil {
+			return nil, err
+		}
+		return newMigrator("postgres", stdlib.OpenDB(*cfg.ConnConfig))
+	case "sqlite":
+		sqlDB, err := openSQLite(db.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		return newMigrator("sqlite", sqlDB)
+	default:
+		return nil, fmt.Errorf("driver %q has no migrations", db.Driver)
+	}
+}
+
+// newMigrator takes over db and closes it with the Migrator.
+func newMigrator(name string, db *sql.DB) (*Migrator, error) {
+	var driver migratedb.Driver
+	var err error
+	switch name {
+	case "postgres":
+		driver, err = postgres.WithInstance(db, &postgres.Config{})
+	case "sqlite":
+		driver, err = migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	default:
+		err = fmt.Errorf("driver %q has no migrations", name)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	source, err := iofs.New(migrations.FS, name)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	latest, err := latestVersion(source)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, name, driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Migrator{m: m, db: db, latest: latest}, nil
+}
+
+func latestVersion(source migratesource.Driver) (uint, error) {
+	version, err := source.First()
+	for err == nil {
+		var next uint
+		if next, err = source.Next(version); err == nil {
+			version = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (mg *Migrator) Status() (MigrationStatus, error) {
+	version, dirty, err := mg.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, err
+	}
+	return MigrationStatus{Version: version, Latest: mg.latest, Dirty: dirty}, nil
+}
+
+func (mg *Migrator) Up() error {
+	return noChange(mg.m.Up())
+}
+
+// Down rolls back the last n migrations.
+func (mg *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("can't roll back %d migrations", n)
+	}
+	return noChange(mg.m.Steps(-n))
+}
+
+// Goto migrates up or down to version.
+func (mg *Migrator) Goto(version uint) error {
+	return noChange(mg.m.Migrate(version))
+}
+
+// Force records version as applied and clean without running anything, for
+// after a failed migration has been repaired by hand.
+func (mg *Migrator) Force(version int) error {
+	return mg.m.Force(version)
+}
+
+func (mg *Migrator) Close() error {
+	mg.m.Close()
+	return mg.db.Close()
+}
+
+func noChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// prepareSchema refuses a dirty schema and one behind this build, unless
+// autoMigrate allows bringing it up to date.
+func prepareSchema(mg *Migrator, autoMigrate bool) error {
+	status, err := mg.Status()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("schema is dirty at version %d: repair it, then run `migrate force %d`", status.Version, status.Version)
+	case status.Version > status.Latest:
+		slog.Warn("Schema is newer than this build", "version", status.Version, "latest", status.Latest)
+	case status.Version < status.Latest:
+		if !autoMigrate {
+			return fmt.Errorf("schema is at version %d, this build needs %d: run `migrate up` or set DB_AUTO_MIGRATE=true", status.Version, status.Latest)
+		}
+		slog.Info("Applying migrations", "from", status.Version, "to", status.Latest)
+		return mg.Up()
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"music-library/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Outbox holds change events until every sink has them. Events get their
+// sequence numbers once committed, so a transaction committing late can't
+// slip in behind events a sink has already passed.
+type Outbox interface {
+	// AppendOutbox stores an event. Call it on the Service a WithTx func
+	// gets, so the event is committed with the change it describes.
+	AppendOutbox(ctx context.Context, event models.Event) error
+	// SequenceOutbox numbers the committed events that have no sequence
+	// number yet, in the order they were appended, and reports how many.
+	SequenceOutbox(ctx context.Context) (int, error)
+	// ClaimOutbox locks the cursor of sink and returns up to limit events
+	// after it. Call it inside WithTx; while another transaction holds the
+	// cursor it returns no events.
+	ClaimOutbox(ctx context.Context, sink string, limit int) ([]models.Event, error)
+	// AckOutbox moves the cursor of sink to seq.
+	AckOutbox(ctx context.Context, sink string, seq int64) error
+	// ListOutbox returns up to limit numbered events after seq, in order.
+	ListOutbox(ctx context.Context, after int64, limit int) ([]models.Event, error)
+	// LatestOutbox returns the newest limit numbered events, in order.
+	LatestOutbox(ctx context.Context, limit int) ([]models.Event, error)
+	// PruneOutbox deletes numbered events created before cutoff that every
+	// one of sinks has had, except the newest, which the numbering continues
+	// from. A sink without a cursor has had none. Cursors of other sinks,
+	// e.g. ones no longer configured, don't hold events back.
+	PruneOutbox(ctx context.Context, cutoff time.Time, sinks []string) (int, error)
+}
+
+const outboxColumns = "seq, event_id, type, created_at, data"
+
+// sequenceOutbox continues the numbering after the highest sequence number.
+const sequenceOutbox = `UPDATE outbox SET seq = p.base + p.n
+	FROM (SELECT id, (SELECT COALESCE(MAX(seq), 0) FROM outbox) AS base, row_number() OVER (ORDER BY id) AS n
+		FROM outbox WHERE seq IS NULL) AS p
+	WHERE outbox.id = p.id`
+
+func (s *service) AppendOutbox(ctx context.Context, event models.Event) error {
+	ctx, done := withTimeout(ctx, s.timeout, "AppendOutbox")
+	defer done()
+
+	_, err := s.q.Exec(ctx, "INSERT INTO outbox (event_id, type, data, created_at) VALUES ($1, $2, $3, $4)",
+		event.Id, event.Type, string(event.Data), event.CreatedAt)
+	return err
+}
+
+func (s *service) SequenceOutbox(ctx context.Context) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "SequenceOutbox")
+	defer done()
+
+	var n int
+	err := s.inTx(ctx, func(tx *service) error {
+		tag, err := tx.q.Exec(ctx, sequenceOutbox)
+		n = int(tag.RowsAffected())
+		return err
+	})
+	return n, err
+}
+
+func (s *service) ClaimOutbox(ctx context.Context, sink string, limit int) ([]models.Event, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ClaimOutbox")
+	defer done()
+
+	if _, err := s.q.Exec(ctx, "INSERT INTO outbox_cursors (sink) VALUES ($1) ON CONFLICT DO NOTHING", sink); err != nil {
+		return nil, err
+	}
+	var after int64
+	err := s.q.QueryRow(ctx, "SELECT seq FROM outbox_cursors WHERE sink = $1 FOR UPDATE SKIP LOCKED", sink).Scan(&after)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.listOutbox(ctx, after, limit)
+}
+
+func (s *service) ListOutbox(ctx context.Context, after int64, limit int) ([]models.Event, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListOutbox")
+	defer done()
+
+	return s.listOutbox(ctx, after, limit)
+}
+
+func (s *service) listOutbox(ctx context.Context, after int64, limit int) ([]models.Event, error) {
+	rows, err := s.q.Query(ctx, "SELECT "+outboxColumns+" FROM outbox WHERE seq > $1 ORDER BY seq LIMIT $2", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		event, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *service) LatestOutbox(ctx context.Context, limit int) ([]models.Event, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "LatestOutbox")
+	defer done()
+
+	// the sequence number the newest events follow
+	var after int64
+	err := s.q.QueryRow(ctx, "SELECT COALESCE(MIN(seq), 1) - 1 FROM (SELECT seq FROM outbox WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT $1) AS latest", limit).Scan(&after)
+	if err != nil {
+		return nil, err
+	}
+	return s.listOutbox(ctx, after, limit)
+}
+
+func (s *service) AckOutbox(ctx context.Context, sink string, seq int64) error {
+	ctx, done := withTimeout(ctx, s.timeout, "AckOutbox")
+	defer done()
+
+	_, err := s.q.Exec(ctx, "UPDATE outbox_cursors SET seq = $1, updated_at = now() WHERE sink = $2", seq, sink)
+	return err
+}
+
+func (s *service) PruneOutbox(ctx context.Context, cutoff time.Time, sinks []string) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "PruneOutbox")
+	defer done()
+
+	tag, err := s.q.Exec(ctx, `DELETE FROM outbox WHERE created_at < $1 AND seq < (SELECT MAX(seq) FROM outbox)
+		AND seq <= COALESCE((SELECT MIN(seq) FROM outbox_cursors WHERE sink = ANY($2)), seq)
+		AND (SELECT COUNT(*) FROM outbox_cursors WHERE sink = ANY($2)) = $3`, cutoff, sinks, len(sinks))
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func scanOutboxEvent(row scanner) (models.Event, error) {
+	var event models.Event
+	var data string
+	if err := row.Scan(&event.Seq, &event.Id, &event.Type, &event.CreatedAt, &data); err != nil {
+		return models.Event{}, err
+	}
+	event.Data = []byte(data)
+	return event, nil
+}
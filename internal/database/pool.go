@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"music-library/internal/config"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	connectBackoff    = 500 * time.Millisecond
+	maxConnectBackoff = 5 * time.Second
+)
+
+// PoolStats is a snapshot of a backend's connection pool.
+type PoolStats struct {
+	MaxConns      int32 `json:"maxConns"`
+	TotalConns    int32 `json:"totalConns"`
+	AcquiredConns int32 `json:"acquiredConns"`
+	IdleConns     int32 `json:"idleConns"`
+	// WaitCount is how many acquires found no idle connection and had to wait.
+	WaitCount   int64   `json:"waitCount"`
+	WaitSeconds float64 `json:"waitSeconds"`
+}
+
+// PoolReporter is implemented by backends that keep a connection pool.
+type PoolReporter interface {
+	PoolStats() PoolStats
+}
+
+// poolConfig builds the pgxpool config. Pool settings left at 0 keep
+// pgxpool's defaults.
+func poolConfig(db config.Database) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig("sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+
+	conn := cfg.ConnConfig
+	conn.Host = db.Host
+	conn.Port = uint16(db.Port)
+	conn.Database = db.Name
+	conn.User = db.User
+	conn.Password = string(db.Password)
+	conn.RuntimeParams["search_path"] = db.Schema
+
+	if db.MaxConns > 0 {
+		cfg.MaxConns = int32(db.MaxConns)
+	}
+	if db.MinConns > 0 {
+		cfg.MinConns = int32(min(db.MinConns, int(cfg.MaxConns)))
+	}
+	if db.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = db.MaxConnLifetime
+	}
+	if db.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = db.MaxConnIdleTime
+	}
+	if db.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = db.HealthCheckPeriod
+	}
+	return cfg, nil
+}
+
+// connect opens the pool and pings until Postgres answers, backing off
+// between attempts, so the service can start alongside its database.
+func connect(cfg *pgxpool.Config, timeout time.Duration) (*pgxpool.Pool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := connectBackoff
+	for attempt := 1; ; attempt++ {
+		err := pool.Ping(ctx)
+		if err == nil {
+			return pool, nil
+		}
+		slog.Warn("Database not ready", "attempt", attempt, "retryIn", backoff.String(), "error", err)
+
+		select {
+		case <-ctx.Done():
+			pool.Close()
+			return nil, fmt.Errorf("database not reachable after %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
+
+// PoolStats reports WaitSeconds as the total time spent acquiring
+// connections, which pgxpool doesn't split from waiting.
+func (s *service) PoolStats() PoolStats {
+	stat := s.pool.Stat()
+	return PoolStats{
+		MaxConns:      stat.MaxConns(),
+		TotalConns:    stat.TotalConns(),
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+		WaitCount:     stat.EmptyAcquireCount(),
+		WaitSeconds:   stat.AcquireDuration().Seconds(),
+	}
+}
+
+func (s *sqliteService) PoolStats() PoolStats {
+	return sqlPoolStats(s.db.Stats())
+}
+
+func sqlPoolStats(stat sql.DBStats) PoolStats {
+	return PoolStats{
+		MaxConns:      int32(stat.MaxOpenConnections),
+		TotalConns:    int32(stat.OpenConnections),
+		AcquiredConns: int32(stat.InUse),
+		IdleConns:     int32(stat.Idle),
+		WaitCount:     stat.WaitCount,
+		WaitSeconds:   stat.WaitDuration.Seconds(),
+	}
+}
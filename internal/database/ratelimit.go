@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// TokenBuckets is implemented by backends that can hold rate limit buckets
+// for several instances of the service.
+type TokenBuckets interface {
+	// TakeToken refills the bucket at perSecond up to burst and takes a
+	// token if there is one, returning what is left.
+	TakeToken(ctx context.Context, key string, perSecond float64, burst int) (tokens float64, allowed bool, err error)
+	// PruneTokenBuckets drops buckets untouched for idle, which are full
+	// again by then.
+	PruneTokenBuckets(ctx context.Context, idle time.Duration) error
+}
+
+// refill is a bucket's tokens after refilling since its last update. In an
+// ON CONFLICT update b is the row as locked, so concurrent requests on any
+// instance see each other's takes and can't both get the last token.
+const refill = "LEAST($3::float8, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM statement_timestamp() - b.updated_at)) * $2::float8)"
+
+func (s *service) TakeToken(ctx context.Context, key string, perSecond float64, burst int) (float64, bool, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "TakeToken")
+	defer done()
+
+	var tokens float64
+	var allowed bool
+	err := s.q.QueryRow(ctx, `INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at) VALUES ($1, $3::float8 - 1, true, statement_timestamp())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = `+refill+` - CASE WHEN `+refill+` >= 1 THEN 1 ELSE 0 END,
+			allowed = `+refill+` >= 1,
+			updated_at = GREATEST(b.updated_at, statement_timestamp())
+		RETURNING tokens, allowed`, key, perSecond, float64(burst)).Scan(&tokens, &allowed)
+	if err != nil {
+		return 0, false, err
+	}
+	return tokens, allowed, nil
+}
+
+func (s *service) PruneTokenBuckets(ctx context.Context, idle time.Duration) error {
+	ctx, done := withTimeout(ctx, s.timeout, "PruneTokenBuckets")
+	defer done()
+
+	_, err := s.q.Exec(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < now() - make_interval(secs => $1)", idle.Seconds())
+	return err
+}
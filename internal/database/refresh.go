@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+)
+
+type RefreshStore interface {
+	EnqueueRefreshJobs(ctx context.Context, kind string, filter models.RefreshFilter) (int, error)
+	ApplySongRefresh(ctx context.Context, id int, song models.Song, changes []models.FieldChange) error
+	GetSongRefreshes(ctx context.Context, id string) ([]models.SongRefresh, error)
+}
+
+// EnqueueRefreshJobs queues a job of the given kind for every song matching
+// the filter that has been enriched before and has no job in flight, and
+// notes when on the song.
+func (s *service) EnqueueRefreshJobs(ctx context.Context, kind string, filter models.RefreshFilter) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "EnqueueRefreshJobs")
+	defer done()
+
+	conditions := []string{"songs.enrichment_status <> 'pending'", "NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.song_id = songs.id AND jobs.status IN ('queued', 'running'))"}
+	args := []any{kind}
+
+	if filter.SongId != 0 {
+		args = append(args, filter.SongId)
+		conditions = append(conditions, "songs.id = $"+strconv.Itoa(len(args)))
+	}
+	if filter.Group != "" {
+		args = append(args, filter.Group)
+		conditions = append(conditions, "artists.artist = $"+strconv.Itoa(len(args)))
+	}
+	if !filter.EnrichedBefore.IsZero() {
+		args = append(args, filter.EnrichedBefore)
+		conditions = append(conditions, "songs.enriched_at < $"+strconv.Itoa(len(args)))
+	}
+	if !filter.QueuedBefore.IsZero() {
+		args = append(args, filter.QueuedBefore)
+		conditions = append(conditions, "(songs.refresh_queued_at IS NULL OR songs.refresh_queued_at < $"+strconv.Itoa(len(args))+")")
+	}
+
+	limit := ""
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		limit = " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	res, err := s.q.Exec(ctx, `WITH queued AS (
+			INSERT INTO jobs (kind, song_id)
+			SELECT $1, songs.id FROM songs LEFT JOIN artists ON songs.artist_id = artists.id
+			WHERE `+strings.Join(conditions, " AND ")+`
+			ORDER BY songs.enriched_at NULLS FIRST, songs.id`+limit+`
+			RETURNING song_id
+		)
+		UPDATE songs SET refresh_queued_at = now() FROM queued WHERE songs.id = queued.song_id`, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res.RowsAffected()), nil
+}
+
+// ApplySongRefresh stores refreshed metadata and, if anything changed, a
+// record of what did.
+func (s *service) ApplySongRefresh(ctx context.Context, id int, song models.Song, changes []models.FieldChange) error {
+	ctx, done := withTimeout(ctx, s.timeout, "ApplySongRefresh")
+	defer done()
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(tx *service) error {
+		res, err := tx.q.Exec(ctx, "UPDATE songs SET release_date = $1, lirycs = $2, link = $3, enrichment_status = $4, enriched_at = now() WHERE id = $5", nullDate(song.ReleaseDate), song.Text, song.Link, models.EnrichmentDone, id)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return customErrors.ErrNotFound
+		}
+
+		if len(changes) > 0 {
+			_, err = tx.q.Exec(ctx, "INSERT INTO song_refreshes (song_id, changes) VALUES ($1, $2::jsonb)", id, string(data))
+		}
+		return err
+	})
+}
+
+func (s *service) GetSongRefreshes(ctx context.Context, id string) ([]models.SongRefresh, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetSongRefreshes")
+	defer done()
+
+	refreshes := []models.SongRefresh{}
+
+	n, ok := parseId(id)
+	if !ok {
+		return refreshes, nil
+	}
+
+	rows, err := s.q.Query(ctx, "SELECT id, song_id, changes, created_at FROM song_refreshes WHERE song_id = $1 ORDER BY id DESC", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var refresh models.SongRefresh
+		var changes []byte
+		if err := rows.Scan(&refresh.Id, &refresh.SongId, &changes, &refresh.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &refresh.Changes); err != nil {
+			return nil, err
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	return refreshes, rows.Err()
+}
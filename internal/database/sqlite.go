@@ -0,0 +1,1052 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+	"music-library/internal/logging"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteService stores the library in a single SQLite file. It mirrors the
+// Postgres service; the differences are dates kept as text, edited fields as
+// a comma separated list and search going through the songs_search FTS5
+// table instead of a tsvector.
+type sqliteService struct {
+	db *sql.DB
+	// q is db, or tx for a Service handed to a WithTx func.
+	q       sqliteQuerier
+	tx      *sql.Tx
+	path    string
+	timeout time.Duration
+}
+
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func NewSQLite(cfg config.Database) (Service, error) {
+	path := cfg.SQLitePath
+	migrationDB, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	mg, err := newMigrator("sqlite", migrationDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareSchema(mg, cfg.AutoMigrate)
+	mg.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Opened SQLite database", "path", path)
+
+	return &sqliteService{db: db, q: db, path: path, timeout: cfg.QueryTimeout}, nil
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer; one connection saves us from SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+const sqliteSongColumns = "songs.id, artist, song, release_date, lirycs, link, enrichment_status, enriched_at, edited_fields, created_by, updated_by"
+
+func (s *sqliteService) Close() error {
+	if s.tx != nil {
+		return nil
+	}
+	slog.Info("Closing SQLite database", "path", s.path)
+	return s.db.Close()
+}
+
+func (s *sqliteService) AddNewSong(ctx context.Context, song models.Song) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "AddNewSong")
+	defer done()
+
+	if song.EnrichmentStatus == "" {
+		song.EnrichmentStatus = models.EnrichmentPending
+	}
+
+	var id int
+	err := s.inTx(ctx, func(tx *sqliteService) error {
+		artistId, err := tx.addNewArtist(ctx, song.Group)
+		if err != nil {
+			return err
+		}
+		return tx.q.QueryRowContext(ctx, "INSERT INTO songs (artist_id, song, release_date, lirycs, link, enrichment_status, created_by) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id",
+			artistId, song.Song, sqliteDate(song.ReleaseDate), song.Text, song.Link, song.EnrichmentStatus, song.CreatedBy).Scan(&id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *sqliteService) addNewArtist(ctx context.Context, artist string) (int, error) {
+	var id int
+	err := s.q.QueryRowContext(ctx, "INSERT INTO artists (artist) VALUES (?) ON CONFLICT (artist) DO NOTHING RETURNING id", artist).Scan(&id)
+	if err == nil {
+		logging.FromContext(ctx).InfoContext(ctx, "New artist added", "id", id, "artist", artist)
+		return id, audit.Record(ctx, s, audit.Create, audit.Artist, id, nil, models.Artist{Id: id, Artist: artist})
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	err = s.q.QueryRowContext(ctx, "SELECT id FROM artists WHERE artist = ?", artist).Scan(&id)
+	return id, err
+}
+
+// ftsQuery matches songs_search rows holding every word. The words are
+// only letters and digits, quoting keeps them from being read as operators.
+func ftsQuery(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = `"` + word + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s *sqliteService) GetSongs(ctx context.Context, opts query.Options) ([]models.Song, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetSongs")
+	defer done()
+
+	songs := []models.Song{}
+
+	w := &where{}
+	if err := getFilersString(w, opts.Filters, func(d models.Date) any { return d.String() }); err != nil {
+		return nil, err
+	}
+	if words := searchWords(opts.Search); len(words) > 0 {
+		w.add("songs.id IN (SELECT rowid FROM songs_search WHERE songs_search MATCH " + w.bind(ftsQuery(words)) + ")")
+	}
+
+	query := fmt.Sprintf("SELECT "+sqliteSongColumns+" FROM songs LEFT JOIN artists ON songs.artist_id = artists.id %s ORDER BY songs.id %s", w, getPaginatorString(opts.Paginator))
+	logging.FromContext(ctx).DebugContext(ctx, "Send query to db: ", "query", query)
+
+	rows, err := s.q.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		song, err := scanSQLiteSong(rows)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	return songs, rows.Err()
+}
+
+func (s *sqliteService) GetSongById(ctx context.Context, id string) (models.Song, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetSongById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Song{}, customErrors.ErrNotFound
+	}
+
+	song, err := scanSQLiteSong(s.q.QueryRowContext(ctx, "SELECT "+sqliteSongColumns+" FROM songs LEFT JOIN artists ON songs.artist_id = artists.id WHERE songs.id = ?", n))
+	if errors.Is(err, sql.ErrNoRows) {
+		return song, customErrors.ErrNotFound
+	}
+	return song, err
+}
+
+func (s *sqliteService) UpdateSongById(ctx context.Context, id string, song models.Song) error {
+	ctx, done := withTimeout(ctx, s.timeout, "UpdateSongById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+
+	res, err := s.q.ExecContext(ctx, "UPDATE songs SET song = ?, release_date = ?, lirycs = ?, link = ?, edited_fields = ?, updated_by = ? WHERE id = ?",
+		song.Song, sqliteDate(song.ReleaseDate), song.Text, song.Link, strings.Join(song.EditedFields, ","), song.UpdatedBy, n)
+	return affected(res, err)
+}
+
+func (s *sqliteService) UpdateSongEnrichment(ctx context.Context, id int, song models.Song, status string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "UpdateSongEnrichment")
+	defer done()
+
+	res, err := s.q.ExecContext(ctx, "UPDATE songs SET release_date = COALESCE(release_date, ?), lirycs = COALESCE(NULLIF(lirycs, ''), ?), link = COALESCE(NULLIF(link, ''), ?), enrichment_status = ?, enriched_at = ? WHERE id = ?",
+		sqliteDate(song.ReleaseDate), song.Text, song.Link, status, time.Now().UTC(), id)
+	return affected(res, err)
+}
+
+func (s *sqliteService) SetEnrichmentStatus(ctx context.Context, id int, status string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "SetEnrichmentStatus")
+	defer done()
+
+	res, err := s.q.ExecContext(ctx, "UPDATE songs SET enrichment_status = ? WHERE id = ?", status, id)
+	return affected(res, err)
+}
+
+func (s *sqliteService) DeleteSongById(ctx context.Context, id string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "DeleteSongById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrNotFound
+	}
+
+	res, err := s.q.ExecContext(ctx, "DELETE FROM songs WHERE id = ?", n)
+	return affected(res, err)
+}
+
+func (s *sqliteService) EnqueueJob(ctx context.Context, job models.Job) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "EnqueueJob")
+	defer done()
+
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = 5
+	}
+	now := time.Now().UTC()
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	var id int
+	err := s.q.QueryRowContext(ctx, "INSERT INTO jobs (kind, song_id, max_attempts, run_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
+		job.Kind, job.SongId, job.MaxAttempts, job.RunAt.UTC(), now, now).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ClaimJob needs no SKIP LOCKED: with one connection the statement is the
+// only writer.
+func (s *sqliteService) ClaimJob(ctx context.Context, lease time.Duration) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ClaimJob")
+	defer done()
+
+	now := time.Now().UTC()
+	job, err := scanJob(s.q.QueryRowContext(ctx, `UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_until = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'queued' AND run_at <= ?) OR (status = 'running' AND locked_until < ?)
+			ORDER BY run_at, id
+			LIMIT 1
+		)
+		RETURNING `+jobColumns, now.Add(lease), now, now, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, customErrors.ErrNoJobs
+	}
+	return job, err
+}
+
+func (s *sqliteService) CompleteJob(ctx context.Context, id int) error {
+	ctx, done := withTimeout(ctx, s.timeout, "CompleteJob")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, "UPDATE jobs SET status = 'succeeded', last_error = '', locked_until = NULL, updated_at = ? WHERE id = ?", time.Now().UTC(), id)
+	return err
+}
+
+func (s *sqliteService) FailJob(ctx context.Context, id int, reason string, retryAt time.Time) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "FailJob")
+	defer done()
+
+	var runAt any
+	if !retryAt.IsZero() {
+		runAt = retryAt.UTC()
+	}
+
+	job, err := scanJob(s.q.QueryRowContext(ctx, `UPDATE jobs
+		SET status = CASE WHEN ? AND attempts < max_attempts THEN 'queued' ELSE 'failed' END,
+			last_error = ?, run_at = COALESCE(?, run_at), locked_until = NULL, updated_at = ?
+		WHERE id = ?
+		RETURNING `+jobColumns, !retryAt.IsZero(), reason, runAt, time.Now().UTC(), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, customErrors.ErrJobNotFound
+	}
+	return job, err
+}
+
+func (s *sqliteService) GetJobById(ctx context.Context, id string) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetJobById")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+
+	job, err := scanJob(s.q.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", n))
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, customErrors.ErrJobNotFound
+	}
+	return job, err
+}
+
+func (s *sqliteService) RetryJob(ctx context.Context, id string) (models.Job, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "RetryJob")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Job{}, customErrors.ErrJobNotFound
+	}
+
+	now := time.Now().UTC()
+	job, err := scanJob(s.q.QueryRowContext(ctx, `UPDATE jobs
+		SET status = 'queued', attempts = 0, last_error = '', run_at = ?, locked_until = NULL, updated_at = ?
+		WHERE id = ? AND status NOT IN ('queued', 'running')
+		RETURNING `+jobColumns, now, now, n))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.GetJobById(ctx, id); err != nil {
+			return job, err
+		}
+		return job, customErrors.ErrJobActive
+	}
+	return job, err
+}
+
+func (s *sqliteService) GetCachedInfo(ctx context.Context, key string) (models.CachedInfo, bool, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetCachedInfo")
+	defer done()
+
+	var entry models.CachedInfo
+	var releaseDate sql.NullString
+
+	err := s.q.QueryRowContext(ctx, "SELECT release_date, lirycs, link, not_found, expires_at FROM metadata_cache WHERE key = ? AND expires_at > ?", key, time.Now().UTC()).
+		Scan(&releaseDate, &entry.Info.Text, &entry.Info.Link, &entry.NotFound, &entry.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entry, false, nil
+		}
+		return entry, false, err
+	}
+	entry.Info.ReleaseDate = parseSQLiteDate(releaseDate)
+
+	return entry, true, nil
+}
+
+func (s *sqliteService) PutCachedInfo(ctx context.Context, key string, entry models.CachedInfo) error {
+	ctx, done := withTimeout(ctx, s.timeout, "PutCachedInfo")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, `INSERT INTO metadata_cache (key, release_date, lirycs, link, not_found, expires_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET release_date = excluded.release_date, lirycs = excluded.lirycs, link = excluded.link,
+			not_found = excluded.not_found, expires_at = excluded.expires_at, updated_at = excluded.updated_at`,
+		key, sqliteDate(entry.Info.ReleaseDate), entry.Info.Text, entry.Info.Link, entry.NotFound, entry.ExpiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+func (s *sqliteService) PruneCachedInfo(ctx context.Context, cutoff time.Time) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "PruneCachedInfo")
+	defer done()
+
+	res, err := s.q.ExecContext(ctx, "DELETE FROM metadata_cache WHERE expires_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqliteService) EnqueueRefreshJobs(ctx context.Context, kind string, filter models.RefreshFilter) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "EnqueueRefreshJobs")
+	defer done()
+
+	w := &where{}
+	w.add("songs.enrichment_status <> 'pending'")
+	w.add("NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.song_id = songs.id AND jobs.status IN ('queued', 'running'))")
+
+	if filter.SongId != 0 {
+		w.add("songs.id = " + w.bind(filter.SongId))
+	}
+	if filter.Group != "" {
+		w.add("artists.artist = " + w.bind(filter.Group))
+	}
+	if !filter.EnrichedBefore.IsZero() {
+		w.add("songs.enriched_at < " + w.bind(filter.EnrichedBefore.UTC()))
+	}
+	if !filter.QueuedBefore.IsZero() {
+		w.add("(songs.refresh_queued_at IS NULL OR songs.refresh_queued_at < " + w.bind(filter.QueuedBefore.UTC()) + ")")
+	}
+
+	limit := ""
+	if filter.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	now := time.Now().UTC()
+	args := append([]any{kind, now, now, now}, w.args...)
+	var queued []any
+	err := s.inTx(ctx, func(tx *sqliteService) error {
+		rows, err := tx.q.QueryContext(ctx, `INSERT INTO jobs (kind, song_id, run_at, created_at, updated_at)
+			SELECT ?, songs.id, ?, ?, ? FROM songs LEFT JOIN artists ON songs.artist_id = artists.id`+
+			w.String()+`
+			ORDER BY songs.enriched_at NULLS FIRST, songs.id`+limit+`
+			RETURNING song_id`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		queued = queued[:0]
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			queued = append(queued, id)
+		}
+		if err := rows.Err(); err != nil || len(queued) == 0 {
+			return err
+		}
+		rows.Close()
+
+		placeholders := strings.Repeat(", ?", len(queued))[2:]
+		_, err = tx.q.ExecContext(ctx, "UPDATE songs SET refresh_queued_at = ? WHERE id IN ("+placeholders+")", append([]any{now}, queued...)...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(queued), nil
+}
+
+func (s *sqliteService) ApplySongRefresh(ctx context.Context, id int, song models.Song, changes []models.FieldChange) error {
+	ctx, done := withTimeout(ctx, s.timeout, "ApplySongRefresh")
+	defer done()
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	return s.inTx(ctx, func(tx *sqliteService) error {
+		res, err := tx.q.ExecContext(ctx, "UPDATE songs SET release_date = ?, lirycs = ?, link = ?, enrichment_status = ?, enriched_at = ? WHERE id = ?",
+			sqliteDate(song.ReleaseDate), song.Text, song.Link, models.EnrichmentDone, now, id)
+		if err := affected(res, err); err != nil {
+			return err
+		}
+
+		if len(changes) > 0 {
+			_, err = tx.q.ExecContext(ctx, "INSERT INTO song_refreshes (song_id, changes, created_at) VALUES (?, ?, ?)", id, string(data), now)
+		}
+		return err
+	})
+}
+
+func (s *sqliteService) GetSongRefreshes(ctx context.Context, id string) ([]models.SongRefresh, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetSongRefreshes")
+	defer done()
+
+	refreshes := []models.SongRefresh{}
+
+	n, ok := parseId(id)
+	if !ok {
+		return refreshes, nil
+	}
+
+	rows, err := s.q.QueryContext(ctx, "SELECT id, song_id, changes, created_at FROM song_refreshes WHERE song_id = ? ORDER BY id DESC", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var refresh models.SongRefresh
+		var changes string
+		if err := rows.Scan(&refresh.Id, &refresh.SongId, &changes, &refresh.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &refresh.Changes); err != nil {
+			return nil, err
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	return refreshes, rows.Err()
+}
+
+const sqliteAPIKeyColumns = "id, name, prefix, scopes, created_at, last_used_at, request_count, revoked_at, rate_limits"
+
+func (s *sqliteService) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "CreateAPIKey")
+	defer done()
+
+	return scanAPIKey(s.q.QueryRowContext(ctx, "INSERT INTO api_keys (name, prefix, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?) RETURNING "+sqliteAPIKeyColumns,
+		key.Name, key.Prefix, hash, strings.Join(key.Scopes, ","), time.Now().UTC()))
+}
+
+func (s *sqliteService) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetAPIKeyByHash")
+	defer done()
+
+	key, err := scanAPIKey(s.q.QueryRowContext(ctx, "SELECT "+sqliteAPIKeyColumns+" FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, customErrors.ErrKeyNotFound
+	}
+	return key, err
+}
+
+func (s *sqliteService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListAPIKeys")
+	defer done()
+
+	rows, err := s.q.QueryContext(ctx, "SELECT "+sqliteAPIKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqliteService) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "RevokeAPIKey")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	res, err := s.q.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", time.Now().UTC(), n)
+	if err := affected(res, err); err != nil {
+		if errors.Is(err, customErrors.ErrNotFound) {
+			return customErrors.ErrKeyNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *sqliteService) SetAPIKeyRateLimits(ctx context.Context, id string, limits map[string]models.RateLimit) error {
+	ctx, done := withTimeout(ctx, s.timeout, "SetAPIKeyRateLimits")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrKeyNotFound
+	}
+	data, err := rateLimitsJSON(limits)
+	if err != nil {
+		return err
+	}
+	res, err := s.q.ExecContext(ctx, "UPDATE api_keys SET rate_limits = ? WHERE id = ?", data, n)
+	if err := affected(res, err); err != nil {
+		if errors.Is(err, customErrors.ErrNotFound) {
+			return customErrors.ErrKeyNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *sqliteService) RecordAPIKeyUsage(ctx context.Context, id int, requests int64, lastUsed time.Time) error {
+	ctx, done := withTimeout(ctx, s.timeout, "RecordAPIKeyUsage")
+	defer done()
+
+	lastUsed = lastUsed.UTC()
+	_, err := s.q.ExecContext(ctx, `UPDATE api_keys SET request_count = request_count + ?,
+		last_used_at = CASE WHEN last_used_at IS NULL OR last_used_at < ? THEN ? ELSE last_used_at END
+		WHERE id = ?`, requests, lastUsed, lastUsed, id)
+	return err
+}
+
+const sqliteAuditColumns = "id, at, actor, request_id, source_ip, action, resource, resource_id, before, after"
+
+func (s *sqliteService) RecordAudit(ctx context.Context, entry models.AuditEntry) error {
+	ctx, done := withTimeout(ctx, s.timeout, "RecordAudit")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, "INSERT INTO audit_log (at, actor, request_id, source_ip, action, resource, resource_id, before, after) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		time.Now().UTC(), entry.Actor, entry.RequestId, entry.SourceIP, entry.Action, entry.Resource, entry.ResourceId, nullJSON(entry.Before), nullJSON(entry.After))
+	return err
+}
+
+func (s *sqliteService) ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListAudit")
+	defer done()
+
+	w := &where{}
+	auditConditions(w, filter, func(t time.Time) any { return t.UTC() })
+
+	rows, err := s.q.QueryContext(ctx, "SELECT "+sqliteAuditColumns+" FROM audit_log"+w.String()+" ORDER BY id DESC"+auditPage(filter), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+const sqliteWebhookColumns = "id, url, events, description, active, secret, created_at, updated_at"
+
+func (s *sqliteService) CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "CreateWebhook")
+	defer done()
+
+	now := time.Now().UTC()
+	return scanWebhook(s.q.QueryRowContext(ctx, "INSERT INTO webhooks (url, events, description, active, secret, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING "+sqliteWebhookColumns,
+		hook.URL, strings.Join(hook.Events, ","), hook.Description, hook.Active, hook.Secret, now, now))
+}
+
+func (s *sqliteService) GetWebhook(ctx context.Context, id string) (models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetWebhook")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Webhook{}, customErrors.ErrWebhookNotFound
+	}
+	hook, err := scanWebhook(s.q.QueryRowContext(ctx, "SELECT "+sqliteWebhookColumns+" FROM webhooks WHERE id = ?", n))
+	if errors.Is(err, sql.ErrNoRows) {
+		return hook, customErrors.ErrWebhookNotFound
+	}
+	return hook, err
+}
+
+func (s *sqliteService) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListWebhooks")
+	defer done()
+
+	rows, err := s.q.QueryContext(ctx, "SELECT "+sqliteWebhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *sqliteService) UpdateWebhook(ctx context.Context, id string, hook models.Webhook) (models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "UpdateWebhook")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Webhook{}, customErrors.ErrWebhookNotFound
+	}
+	updated, err := scanWebhook(s.q.QueryRowContext(ctx, `UPDATE webhooks
+		SET url = ?, events = ?, description = ?, active = ?, secret = COALESCE(NULLIF(?, ''), secret), updated_at = ?
+		WHERE id = ?
+		RETURNING `+sqliteWebhookColumns, hook.URL, strings.Join(hook.Events, ","), hook.Description, hook.Active, hook.Secret, time.Now().UTC(), n))
+	if errors.Is(err, sql.ErrNoRows) {
+		return updated, customErrors.ErrWebhookNotFound
+	}
+	return updated, err
+}
+
+func (s *sqliteService) DeleteWebhook(ctx context.Context, id string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "DeleteWebhook")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrWebhookNotFound
+	}
+	err := affected(s.q.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", n))
+	if errors.Is(err, customErrors.ErrNotFound) {
+		return customErrors.ErrWebhookNotFound
+	}
+	return err
+}
+
+// EnqueueWebhookEvent matches the event type against the comma separated
+// subscriptions.
+func (s *sqliteService) EnqueueWebhookEvent(ctx context.Context, event models.Event) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "EnqueueWebhookEvent")
+	defer done()
+
+	payload, err := eventPayload(event)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	res, err := s.q.ExecContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at, created_at, updated_at)
+		SELECT id, ?, ?, ?, ?, ?, ? FROM webhooks WHERE active AND ',' || events || ',' LIKE '%,' || ? || ',%'
+		ORDER BY id`, event.Id, event.Type, payload, now, now, now, event.Type)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqliteService) ClaimDelivery(ctx context.Context, lease time.Duration) (models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ClaimDelivery")
+	defer done()
+
+	now := time.Now().UTC()
+	delivery, err := scanDelivery(s.q.QueryRowContext(ctx, `UPDATE webhook_deliveries
+		SET status = 'delivering', attempts = attempts + 1, locked_until = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE (status = 'pending' AND next_attempt_at <= ?) OR (status = 'delivering' AND locked_until < ?)
+			ORDER BY next_attempt_at, id
+			LIMIT 1
+		)
+		RETURNING `+deliveryColumns, now.Add(lease), now, now, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return delivery, customErrors.ErrNoDeliveries
+	}
+	return delivery, err
+}
+
+func (s *sqliteService) CompleteDelivery(ctx context.Context, id int, responseStatus int) error {
+	ctx, done := withTimeout(ctx, s.timeout, "CompleteDelivery")
+	defer done()
+
+	now := time.Now().UTC()
+	_, err := s.q.ExecContext(ctx, `UPDATE webhook_deliveries
+		SET status = 'succeeded', response_status = ?, last_error = '', locked_until = NULL, delivered_at = ?, updated_at = ?
+		WHERE id = ?`, responseStatus, now, now, id)
+	return err
+}
+
+func (s *sqliteService) FailDelivery(ctx context.Context, id int, responseStatus int, reason string, retryAt time.Time) (models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "FailDelivery")
+	defer done()
+
+	var nextAttempt any
+	if !retryAt.IsZero() {
+		nextAttempt = retryAt.UTC()
+	}
+
+	delivery, err := scanDelivery(s.q.QueryRowContext(ctx, `UPDATE webhook_deliveries
+		SET status = CASE WHEN ? THEN 'pending' ELSE 'failed' END,
+			response_status = ?, last_error = ?, next_attempt_at = COALESCE(?, next_attempt_at), locked_until = NULL, updated_at = ?
+		WHERE id = ?
+		RETURNING `+deliveryColumns, !retryAt.IsZero(), responseStatus, reason, nextAttempt, time.Now().UTC(), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return delivery, customErrors.ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func (s *sqliteService) ListDeliveries(ctx context.Context, webhookId string, limit, offset int) ([]models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListDeliveries")
+	defer done()
+
+	hook, err := s.GetWebhook(ctx, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.q.QueryContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ? OFFSET ?", hook.Id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqliteService) Redeliver(ctx context.Context, webhookId, deliveryId string) (models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "Redeliver")
+	defer done()
+
+	hookId, hookOk := parseId(webhookId)
+	n, ok := parseId(deliveryId)
+	if !hookOk || !ok {
+		return models.WebhookDelivery{}, customErrors.ErrDeliveryNotFound
+	}
+	now := time.Now().UTC()
+	delivery, err := scanDelivery(s.q.QueryRowContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at, created_at, updated_at)
+		SELECT webhook_id, event_id, event_type, payload, ?, ?, ? FROM webhook_deliveries WHERE id = ? AND webhook_id = ?
+		RETURNING `+deliveryColumns, now, now, now, n, hookId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return delivery, customErrors.ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func (s *sqliteService) AppendOutbox(ctx context.Context, event models.Event) error {
+	ctx, done := withTimeout(ctx, s.timeout, "AppendOutbox")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, "INSERT INTO outbox (event_id, type, data, created_at) VALUES (?, ?, ?, ?)",
+		event.Id, event.Type, string(event.Data), event.CreatedAt.UTC())
+	return err
+}
+
+func (s *sqliteService) SequenceOutbox(ctx context.Context) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "SequenceOutbox")
+	defer done()
+
+	res, err := s.q.ExecContext(ctx, sequenceOutbox)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ClaimOutbox needs no row lock: the first write of a transaction takes
+// the database's.
+func (s *sqliteService) ClaimOutbox(ctx context.Context, sink string, limit int) ([]models.Event, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ClaimOutbox")
+	defer done()
+
+	if _, err := s.q.ExecContext(ctx, "INSERT OR IGNORE INTO outbox_cursors (sink, updated_at) VALUES (?, ?)", sink, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	var after int64
+	if err := s.q.QueryRowContext(ctx, "SELECT seq FROM outbox_cursors WHERE sink = ?", sink).Scan(&after); err != nil {
+		return nil, err
+	}
+	return s.listOutbox(ctx, after, limit)
+}
+
+func (s *sqliteService) ListOutbox(ctx context.Context, after int64, limit int) ([]models.Event, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListOutbox")
+	defer done()
+
+	return s.listOutbox(ctx, after, limit)
+}
+
+func (s *sqliteService) LatestOutbox(ctx context.Context, limit int) ([]models.Event, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "LatestOutbox")
+	defer done()
+
+	var after int64
+	if err := s.q.QueryRowContext(ctx, "SELECT COALESCE(MIN(seq), 1) - 1 FROM (SELECT seq FROM outbox WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT ?) AS latest", limit).Scan(&after); err != nil {
+		return nil, err
+	}
+	return s.listOutbox(ctx, after, limit)
+}
+
+func (s *sqliteService) listOutbox(ctx context.Context, after int64, limit int) ([]models.Event, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT "+outboxColumns+" FROM outbox WHERE seq > ? ORDER BY seq LIMIT ?", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		event, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *sqliteService) AckOutbox(ctx context.Context, sink string, seq int64) error {
+	ctx, done := withTimeout(ctx, s.timeout, "AckOutbox")
+	defer done()
+
+	_, err := s.q.ExecContext(ctx, "UPDATE outbox_cursors SET seq = ?, updated_at = ? WHERE sink = ?", seq, time.Now().UTC(), sink)
+	return err
+}
+
+func (s *sqliteService) PruneOutbox(ctx context.Context, cutoff time.Time, sinks []string) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "PruneOutbox")
+	defer done()
+
+	names := make([]any, len(sinks))
+	for i, sink := range sinks {
+		names[i] = sink
+	}
+	// SQLite takes an empty IN list
+	in := "sink IN (" + strings.TrimPrefix(strings.Repeat(", ?", len(sinks)), ", ") + ")"
+	args := append(append(append([]any{cutoff.UTC()}, names...), names...), len(sinks))
+	res, err := s.q.ExecContext(ctx, `DELETE FROM outbox WHERE created_at < ? AND seq < (SELECT MAX(seq) FROM outbox)
+		AND seq <= COALESCE((SELECT MIN(seq) FROM outbox_cursors WHERE `+in+`), seq)
+		AND (SELECT COUNT(*) FROM outbox_cursors WHERE `+in+`) = ?`, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqliteService) ListArtists(ctx context.Context, paginator query.Paginator) ([]models.Artist, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListArtists")
+	defer done()
+
+	return s.queryArtists(ctx, "SELECT id, artist FROM artists ORDER BY id"+getPaginatorString(paginator))
+}
+
+func (s *sqliteService) GetArtistsByName(ctx context.Context, names []string) ([]models.Artist, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetArtistsByName")
+	defer done()
+
+	if len(names) == 0 {
+		return []models.Artist{}, nil
+	}
+	args := make([]any, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	placeholders := strings.Repeat(", ?", len(names))[2:]
+	return s.queryArtists(ctx, "SELECT id, artist FROM artists WHERE artist IN ("+placeholders+")", args...)
+}
+
+func (s *sqliteService) queryArtists(ctx context.Context, stmt string, args ...any) ([]models.Artist, error) {
+	rows, err := s.q.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []models.Artist{}
+	for rows.Next() {
+		var artist models.Artist
+		if err := rows.Scan(&artist.Id, &artist.Artist); err != nil {
+			return nil, err
+		}
+		artists = append(artists, artist)
+	}
+	return artists, rows.Err()
+}
+
+// WithTx runs fn in a transaction. With a single connection SQLite
+// transactions are serialized anyway, so only a busy database is retried.
+func (s *sqliteService) WithTx(ctx context.Context, fn func(tx Service) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	return retryTx(ctx, sqliteRetryable, func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(&sqliteService{db: s.db, q: tx, tx: tx, path: s.path, timeout: s.timeout}); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+func (s *sqliteService) inTx(ctx context.Context, fn func(tx *sqliteService) error) error {
+	return s.WithTx(ctx, func(tx Service) error {
+		return fn(tx.(*sqliteService))
+	})
+}
+
+func sqliteRetryable(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+func scanSQLiteSong(row scanner) (models.Song, error) {
+	var song models.Song
+	var group, releaseDate sql.NullString
+	var enrichedAt sql.NullTime
+	var editedFields string
+
+	err := row.Scan(&song.Id, &group, &song.Song, &releaseDate, &song.Text, &song.Link, &song.EnrichmentStatus, &enrichedAt, &editedFields, &song.CreatedBy, &song.UpdatedBy)
+	song.Group = group.String
+	song.ReleaseDate = parseSQLiteDate(releaseDate)
+	if enrichedAt.Valid {
+		song.EnrichedAt = &enrichedAt.Time
+	}
+	if editedFields != "" {
+		song.EditedFields = strings.Split(editedFields, ",")
+	}
+	return song, err
+}
+
+func sqliteDate(d models.Date) any {
+	if time.Time(d).IsZero() {
+		return nil
+	}
+	return d.String()
+}
+
+func parseSQLiteDate(s sql.NullString) models.Date {
+	if !s.Valid {
+		return models.Date{}
+	}
+	d, err := models.ParseDate(s.String)
+	if err != nil {
+		return models.Date{}
+	}
+	return d
+}
+
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return customErrors.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/database/dbtest"
+)
+
+func TestSQLite(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.Service {
+		cfg := config.Default().Database
+		cfg.AutoMigrate = true
+		cfg.SQLitePath = filepath.Join(t.TempDir(), "test.db")
+		s, err := database.NewSQLite(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"music-library/internal/logging"
+)
+
+var errQueryTimeout = errors.New("query timeout")
+
+// withTimeout bounds a Service call by timeout, 0 leaves it bounded only by
+// its context. The returned done func logs
+// calls cut short, either by the timeout or by the caller going away.
+func withTimeout(ctx context.Context, timeout time.Duration, op string) (context.Context, func()) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errQueryTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	return ctx, func() {
+		if ctx.Err() != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Database call cancelled", "op", op, "cause", context.Cause(ctx))
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"music-library/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	txAttempts = 5
+	txBackoff  = 10 * time.Millisecond
+)
+
+// querier runs statements either on the pool or inside a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// retryTx runs attempt until it succeeds, fails with an error retryable
+// doesn't accept, or runs out of attempts.
+func retryTx(ctx context.Context, retryable func(error) bool, attempt func() error) error {
+	backoff := txBackoff
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i == txAttempts || !retryable(err) {
+			return err
+		}
+		logging.FromContext(ctx).DebugContext(ctx, "Retrying transaction", "attempt", i, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff + rand.N(backoff)):
+		}
+		backoff *= 2
+	}
+}
+
+// WithTx runs fn in a serializable transaction. Calls on the Service fn gets
+// join it, including nested WithTx calls.
+func (s *service) WithTx(ctx context.Context, fn func(tx Service) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	return retryTx(ctx, pgRetryable, func() error {
+		tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		if err := fn(&service{pool: s.pool, q: tx, tx: tx, database: s.database, timeout: s.timeout}); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// inTx runs fn on a Service bound to a transaction, s itself if it already
+// is one.
+func (s *service) inTx(ctx context.Context, fn func(tx *service) error) error {
+	return s.WithTx(ctx, func(tx Service) error {
+		return fn(tx.(*service))
+	})
+}
+
+// pgRetryable accepts serialization failures and deadlocks, the errors
+// Postgres expects clients to retry.
+func pgRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
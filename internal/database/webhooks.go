@@ -0,0 +1,261 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error)
+	// GetWebhook returns the webhook with its secret.
+	GetWebhook(ctx context.Context, id string) (models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	// UpdateWebhook replaces the webhook's settings, and its secret if
+	// hook has one.
+	UpdateWebhook(ctx context.Context, id string, hook models.Webhook) (models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	// EnqueueWebhookEvent queues a delivery of event to every active
+	// webhook subscribed to its type and reports how many were queued.
+	EnqueueWebhookEvent(ctx context.Context, event models.Event) (int, error)
+	// ClaimDelivery hands out the oldest due delivery, or one whose lease
+	// expired, and counts the attempt.
+	ClaimDelivery(ctx context.Context, lease time.Duration) (models.WebhookDelivery, error)
+	CompleteDelivery(ctx context.Context, id int, responseStatus int) error
+	// FailDelivery puts the delivery back until retryAt, or marks it failed
+	// if retryAt is zero.
+	FailDelivery(ctx context.Context, id int, responseStatus int, reason string, retryAt time.Time) (models.WebhookDelivery, error)
+	// ListDeliveries returns the webhook's deliveries, newest first.
+	ListDeliveries(ctx context.Context, webhookId string, limit, offset int) ([]models.WebhookDelivery, error)
+	// Redeliver queues a new delivery of the payload of an earlier one.
+	Redeliver(ctx context.Context, webhookId, deliveryId string) (models.WebhookDelivery, error)
+}
+
+const webhookColumns = "id, url, array_to_string(events, ','), description, active, secret, created_at, updated_at"
+
+const deliveryColumns = "id, webhook_id, event_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, delivered_at, created_at, updated_at"
+
+func (s *service) CreateWebhook(ctx context.Context, hook models.Webhook) (models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "CreateWebhook")
+	defer done()
+
+	return scanWebhook(s.q.QueryRow(ctx, "INSERT INTO webhooks (url, events, description, active, secret) VALUES ($1, $2, $3, $4, $5) RETURNING "+webhookColumns,
+		hook.URL, hook.Events, hook.Description, hook.Active, hook.Secret))
+}
+
+func (s *service) GetWebhook(ctx context.Context, id string) (models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "GetWebhook")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Webhook{}, customErrors.ErrWebhookNotFound
+	}
+	hook, err := scanWebhook(s.q.QueryRow(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", n))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hook, customErrors.ErrWebhookNotFound
+	}
+	return hook, err
+}
+
+func (s *service) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListWebhooks")
+	defer done()
+
+	rows, err := s.q.Query(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *service) UpdateWebhook(ctx context.Context, id string, hook models.Webhook) (models.Webhook, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "UpdateWebhook")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return models.Webhook{}, customErrors.ErrWebhookNotFound
+	}
+	updated, err := scanWebhook(s.q.QueryRow(ctx, `UPDATE webhooks
+		SET url = $1, events = $2, description = $3, active = $4, secret = COALESCE(NULLIF($5, ''), secret), updated_at = now()
+		WHERE id = $6
+		RETURNING `+webhookColumns, hook.URL, hook.Events, hook.Description, hook.Active, hook.Secret, n))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return updated, customErrors.ErrWebhookNotFound
+	}
+	return updated, err
+}
+
+func (s *service) DeleteWebhook(ctx context.Context, id string) error {
+	ctx, done := withTimeout(ctx, s.timeout, "DeleteWebhook")
+	defer done()
+
+	n, ok := parseId(id)
+	if !ok {
+		return customErrors.ErrWebhookNotFound
+	}
+	res, err := s.q.Exec(ctx, "DELETE FROM webhooks WHERE id = $1", n)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return customErrors.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *service) EnqueueWebhookEvent(ctx context.Context, event models.Event) (int, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "EnqueueWebhookEvent")
+	defer done()
+
+	payload, err := eventPayload(event)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.q.Exec(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhooks WHERE active AND $2 = ANY(events)
+		ORDER BY id`, event.Id, event.Type, payload)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+// ClaimDelivery works like ClaimJob, SKIP LOCKED lets every instance's
+// dispatcher poll at once.
+func (s *service) ClaimDelivery(ctx context.Context, lease time.Duration) (models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ClaimDelivery")
+	defer done()
+
+	delivery, err := scanDelivery(s.q.QueryRow(ctx, `UPDATE webhook_deliveries
+		SET status = 'delivering', attempts = attempts + 1, locked_until = now() + make_interval(secs => $1), updated_at = now()
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE (status = 'pending' AND next_attempt_at <= now()) OR (status = 'delivering' AND locked_until < now())
+			ORDER BY next_attempt_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns, lease.Seconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return delivery, customErrors.ErrNoDeliveries
+	}
+	return delivery, err
+}
+
+func (s *service) CompleteDelivery(ctx context.Context, id int, responseStatus int) error {
+	ctx, done := withTimeout(ctx, s.timeout, "CompleteDelivery")
+	defer done()
+
+	_, err := s.q.Exec(ctx, `UPDATE webhook_deliveries
+		SET status = 'succeeded', response_status = $1, last_error = '', locked_until = NULL, delivered_at = now(), updated_at = now()
+		WHERE id = $2`, responseStatus, id)
+	return err
+}
+
+func (s *service) FailDelivery(ctx context.Context, id int, responseStatus int, reason string, retryAt time.Time) (models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "FailDelivery")
+	defer done()
+
+	delivery, err := scanDelivery(s.q.QueryRow(ctx, `UPDATE webhook_deliveries
+		SET status = CASE WHEN $4 THEN 'pending' ELSE 'failed' END,
+			response_status = $2, last_error = $3, next_attempt_at = COALESCE($5, next_attempt_at), locked_until = NULL, updated_at = now()
+		WHERE id = $1
+		RETURNING `+deliveryColumns, id, responseStatus, reason, !retryAt.IsZero(), sql.NullTime{Time: retryAt, Valid: !retryAt.IsZero()}))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return delivery, customErrors.ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func (s *service) ListDeliveries(ctx context.Context, webhookId string, limit, offset int) ([]models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "ListDeliveries")
+	defer done()
+
+	hook, err := s.GetWebhook(ctx, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.q.Query(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3", hook.Id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *service) Redeliver(ctx context.Context, webhookId, deliveryId string) (models.WebhookDelivery, error) {
+	ctx, done := withTimeout(ctx, s.timeout, "Redeliver")
+	defer done()
+
+	hookId, hookOk := parseId(webhookId)
+	n, ok := parseId(deliveryId)
+	if !hookOk || !ok {
+		return models.WebhookDelivery{}, customErrors.ErrDeliveryNotFound
+	}
+	delivery, err := scanDelivery(s.q.QueryRow(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT webhook_id, event_id, event_type, payload FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2
+		RETURNING `+deliveryColumns, n, hookId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return delivery, customErrors.ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var hook models.Webhook
+	var events string
+	err := row.Scan(&hook.Id, &hook.URL, &events, &hook.Description, &hook.Active, &hook.Secret, &hook.CreatedAt, &hook.UpdatedAt)
+	hook.Events = []string{}
+	if events != "" {
+		hook.Events = strings.Split(events, ",")
+	}
+	return hook, err
+}
+
+func scanDelivery(row scanner) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var payload string
+	var deliveredAt sql.NullTime
+	err := row.Scan(&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts,
+		&delivery.ResponseStatus, &delivery.LastError, &delivery.NextAttemptAt, &deliveredAt, &delivery.CreatedAt, &delivery.UpdatedAt)
+	delivery.Payload = json.RawMessage(payload)
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return delivery, err
+}
+
+// eventPayload is the body deliveries of event send.
+func eventPayload(event models.Event) (string, error) {
+	payload, err := json.Marshal(event)
+	return string(payload), err
+}
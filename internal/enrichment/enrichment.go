@@ -0,0 +1,315 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/logging"
+	"music-library/internal/models"
+	"music-library/internal/musicapi"
+	"music-library/internal/outbox"
+	"music-library/internal/retry"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	KindEnrich  = "enrich"
+	KindRefresh = "refresh"
+)
+
+var errUnknownKind = errors.New("unknown job kind")
+
+// auditActor is who song changes made by jobs are attributed to.
+const auditActor = "system:enrichment"
+
+const (
+	pollInterval = 2 * time.Second
+	jobLease     = time.Minute
+	baseBackoff  = 10 * time.Second
+	maxBackoff   = 10 * time.Minute
+)
+
+// Pool runs enrichment jobs from the persisted queue on a fixed number of
+// workers. Jobs survive restarts; one left running by a dead worker is picked
+// up again once its lease expires.
+type Pool struct {
+	db      database.Service
+	info    musicapi.Fetcher
+	workers int
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPool(db database.Service, info musicapi.Fetcher, workers int) *Pool {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Pool{
+		db:      db,
+		info:    info,
+		workers: workers,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (p *Pool) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+	slog.Info("Enrichment workers started", "workers", p.workers)
+}
+
+func (p *Pool) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+	slog.Info("Enrichment workers stopped")
+}
+
+// Notify wakes an idle worker so a freshly enqueued job doesn't wait for the
+// next poll.
+func (p *Pool) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// EnqueueRefresh queues a refresh job for every song matching filter and
+// reports how many were queued.
+func (p *Pool) EnqueueRefresh(ctx context.Context, filter models.RefreshFilter) (int, error) {
+	n, err := p.db.EnqueueRefreshJobs(ctx, KindRefresh, filter)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		p.Notify()
+	}
+	return n, nil
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for ctx.Err() == nil {
+		job, err := p.db.ClaimJob(ctx, jobLease)
+		if err == nil {
+			p.process(ctx, job)
+			continue
+		}
+		if !errors.Is(err, customErrors.ErrNoJobs) && ctx.Err() == nil {
+			slog.Error("Can't claim job", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-p.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+var tracer = otel.Tracer("music-library/internal/enrichment")
+
+func (p *Pool) process(ctx context.Context, job models.Job) {
+	ctx, span := tracer.Start(ctx, "enrichment."+job.Kind, trace.WithAttributes(
+		attribute.Int("job.id", job.Id),
+		attribute.Int("song.id", job.SongId),
+		attribute.Int("job.attempt", job.Attempts)))
+	defer span.End()
+
+	logger := logging.FromContext(ctx).With("job", job.Id)
+	ctx = logging.WithLogger(audit.WithActor(ctx, auditActor), logger)
+
+	jobCtx, cancel := context.WithTimeout(ctx, jobLease)
+	defer cancel()
+
+	err := p.handle(jobCtx, job)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	// the outcome is recorded even if shutdown started meanwhile, otherwise
+	// finished work would run again once the lease expires
+	done := context.WithoutCancel(ctx)
+	if err == nil {
+		if err := p.db.CompleteJob(done, job.Id); err != nil {
+			logger.ErrorContext(ctx, "Can't complete job", "error", err)
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		logger.InfoContext(ctx, "Job interrupted by shutdown")
+		return
+	}
+
+	var retryAt time.Time
+	if !errors.Is(err, musicapi.ErrNotFound) && !errors.Is(err, errUnknownKind) {
+		retryAt = time.Now().Add(backoff(job.Attempts))
+	}
+
+	var failed models.Job
+	reason := err.Error()
+	ferr := p.db.WithTx(done, func(tx database.Service) error {
+		var err error
+		if failed, err = tx.FailJob(done, job.Id, reason, retryAt); err != nil {
+			return err
+		}
+		if failed.Status != models.JobFailed || job.Kind != KindEnrich {
+			return nil
+		}
+		err = tx.SetEnrichmentStatus(done, job.SongId, models.EnrichmentFailed)
+		if errors.Is(err, customErrors.ErrNotFound) {
+			return nil
+		}
+		return err
+	})
+	if ferr != nil {
+		logger.ErrorContext(ctx, "Can't record job failure", "error", ferr)
+		return
+	}
+	logger.WarnContext(ctx, "Job failed", "kind", job.Kind, "attempt", job.Attempts, "status", failed.Status, "error", err)
+}
+
+func (p *Pool) handle(ctx context.Context, job models.Job) error {
+	switch job.Kind {
+	case KindEnrich:
+		return p.enrich(ctx, job.SongId)
+	case KindRefresh:
+		return p.refresh(ctx, job.SongId)
+	default:
+		return fmt.Errorf("%w: %s", errUnknownKind, job.Kind)
+	}
+}
+
+func (p *Pool) enrich(ctx context.Context, songId int) error {
+	song, err := p.db.GetSongById(ctx, strconv.Itoa(songId))
+	if err != nil {
+		if errors.Is(err, customErrors.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	info, err := p.info.GetMusicInfo(ctx, song.Group, song.Song)
+	if err != nil {
+		return err
+	}
+
+	return p.updateSong(ctx, songId, func(tx database.Service, song models.Song) (bool, error) {
+		return true, tx.UpdateSongEnrichment(ctx, songId, info, models.EnrichmentDone)
+	})
+}
+
+// refresh fetches current metadata for an already enriched song, bypassing
+// the cache, and applies every field that wasn't edited by hand.
+func (p *Pool) refresh(ctx context.Context, songId int) error {
+	song, err := p.db.GetSongById(ctx, strconv.Itoa(songId))
+	if err != nil {
+		if errors.Is(err, customErrors.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	info, err := p.info.GetMusicInfo(musicapi.NoCache(ctx), song.Group, song.Song)
+	if err != nil {
+		return err
+	}
+
+	// merged with the song as it is when written, so edits made during the
+	// lookup are kept
+	var changes []models.FieldChange
+	err = p.updateSong(ctx, songId, func(tx database.Service, song models.Song) (bool, error) {
+		var updated models.Song
+		updated, changes = mergeMetadata(song, info)
+		return len(changes) > 0, tx.ApplySongRefresh(ctx, songId, updated, changes)
+	})
+	if err == nil && len(changes) > 0 {
+		logging.FromContext(ctx).InfoContext(ctx, "Song metadata changed", "song", songId, "changes", len(changes))
+	}
+	return err
+}
+
+// updateSong runs write on the song in a transaction, together with the
+// audit entry and outbox event of the change if write reports one. The song
+// is read in the same transaction, which fails and runs again if the song
+// changes before it commits. Songs deleted meanwhile are skipped.
+func (p *Pool) updateSong(ctx context.Context, songId int, write func(tx database.Service, song models.Song) (changed bool, err error)) error {
+	id := strconv.Itoa(songId)
+	err := p.db.WithTx(ctx, func(tx database.Service) error {
+		before, err := tx.GetSongById(ctx, id)
+		if err != nil {
+			return err
+		}
+		changed, err := write(tx, before)
+		if err != nil || !changed {
+			return err
+		}
+		after, err := tx.GetSongById(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.Update, audit.Song, songId, before, after); err != nil {
+			return err
+		}
+		return outbox.SongChanged(ctx, tx, audit.Update, &before, &after)
+	})
+	if errors.Is(err, customErrors.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func mergeMetadata(song, info models.Song) (models.Song, []models.FieldChange) {
+	var changes []models.FieldChange
+
+	for _, field := range models.MetadataFields {
+		if slices.Contains(song.EditedFields, field) {
+			continue
+		}
+		old, fresh := song.Value(field), info.Value(field)
+		if fresh == "" || fresh == old {
+			continue
+		}
+
+		switch field {
+		case models.FieldReleaseDate:
+			song.ReleaseDate = info.ReleaseDate
+		case models.FieldText:
+			song.Text = info.Text
+		case models.FieldLink:
+			song.Link = info.Link
+		}
+		changes = append(changes, models.FieldChange{Field: field, Old: old, New: fresh})
+	}
+
+	return song, changes
+}
+
+func backoff(attempt int) time.Duration {
+	return retry.Backoff(attempt, baseBackoff, maxBackoff)
+}
@@ -0,0 +1,122 @@
+package enrichment_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/enrichment"
+	"music-library/internal/fakeinfo"
+	"music-library/internal/library"
+	"music-library/internal/models"
+	"music-library/internal/musicapi"
+	"music-library/internal/outbox"
+)
+
+// TestEnrichment adds songs through the library and has the workers enrich
+// them from the fake music info service running in-process.
+func TestEnrichment(t *testing.T) {
+	fake, info := fakeinfo.NewTestServer(fakeinfo.DefaultFixtures(), fakeinfo.Options{})
+	defer fake.Close()
+
+	cfg := config.Default()
+	cfg.MusicAPI.URL = fake.URL + "/info"
+	cfg.MusicAPI.MaxRetries = 0
+	cfg.MusicAPI.BreakerThreshold = 100
+
+	ctx := context.Background()
+	db := database.NewMemory()
+	pool := enrichment.NewPool(db, musicapi.New(cfg.MusicAPI), 2)
+	lib := library.New(db, pool, outbox.NewRelay(db, cfg.Outbox))
+	pool.Start()
+	defer pool.Stop()
+
+	add := func(group, song string) models.AcceptedSong {
+		t.Helper()
+		accepted, err := lib.Create(ctx, models.NewSong{Group: group, Song: song})
+		if err != nil {
+			t.Fatalf("Create(%q, %q): %v", group, song, err)
+		}
+		return accepted
+	}
+
+	t.Run("found", func(t *testing.T) {
+		accepted := add("Muse", "Starlight")
+		waitForJob(t, db, accepted.JobId, models.JobSucceeded)
+
+		song, err := lib.Song(ctx, strconv.Itoa(accepted.Id))
+		if err != nil {
+			t.Fatalf("Song: %v", err)
+		}
+		if song.EnrichmentStatus != models.EnrichmentDone || song.ReleaseDate.String() != "2006-09-04" || song.Link != "https://www.youtube.com/watch?v=Pgum6OT_VH8" {
+			t.Errorf("enriched song is %+v", song)
+		}
+		if verses := library.Verses(song); len(verses) != 2 {
+			t.Errorf("enriched song has %d verses, want 2", len(verses))
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		accepted := add("Nobody", "Nothing")
+		job := waitForJob(t, db, accepted.JobId, models.JobFailed)
+		if job.Attempts != 1 {
+			t.Errorf("job made %d attempts, want 1: 404s aren't retried", job.Attempts)
+		}
+
+		song, err := lib.Song(ctx, strconv.Itoa(accepted.Id))
+		if err != nil {
+			t.Fatalf("Song: %v", err)
+		}
+		if song.EnrichmentStatus != models.EnrichmentFailed || song.Text != "" {
+			t.Errorf("song unknown upstream is %+v, want failed and empty", song)
+		}
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		info.SetOptions(fakeinfo.Options{ErrorRate: 1})
+		defer info.SetOptions(fakeinfo.Options{})
+
+		accepted := add("Muse", "Supermassive Black Hole")
+		job := waitFor(t, db, accepted.JobId, func(job models.Job) bool { return job.Attempts == 1 && job.Status == models.JobQueued })
+		if job.LastError == "" || !job.RunAt.After(time.Now()) {
+			t.Errorf("failed job is %+v, want it queued for a later retry with its error", job)
+		}
+
+		song, err := lib.Song(ctx, strconv.Itoa(accepted.Id))
+		if err != nil {
+			t.Fatalf("Song: %v", err)
+		}
+		if song.EnrichmentStatus != models.EnrichmentPending {
+			t.Errorf("song status after a retryable failure is %q, want %q", song.EnrichmentStatus, models.EnrichmentPending)
+		}
+	})
+}
+
+func waitForJob(t *testing.T, db database.Service, id int, status string) models.Job {
+	t.Helper()
+	return waitFor(t, db, id, func(job models.Job) bool { return job.Status == status })
+}
+
+// waitFor polls the job until done accepts it, failing the test after a few
+// seconds.
+func waitFor(t *testing.T, db database.Service, id int, done func(models.Job) bool) models.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := db.GetJobById(context.Background(), strconv.Itoa(id))
+		if err != nil {
+			t.Fatalf("GetJobById(%d): %v", id, err)
+		}
+		if done(job) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d is still %+v", id, job)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
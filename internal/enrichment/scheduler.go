@@ -0,0 +1,91 @@
+package enrichment
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"music-library/internal/models"
+)
+
+const refreshBatch = 100
+
+// Scheduler periodically queues refresh jobs for songs whose metadata is
+// older than maxAge. Each tick queues at most refreshBatch songs, oldest
+// first, so a large library is worked through gradually. Songs queued within
+// maxAge are skipped, so those whose refreshes keep failing are tried once
+// per maxAge rather than on every tick.
+type Scheduler struct {
+	pool     *Pool
+	interval time.Duration
+	maxAge   time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(pool *Pool, interval, maxAge time.Duration) *Scheduler {
+	return &Scheduler{
+		pool:     pool,
+		interval: interval,
+		maxAge:   maxAge,
+	}
+}
+
+func (s *Scheduler) Start() {
+	if s.interval <= 0 {
+		slog.Info("Metadata refresh scheduler disabled")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go s.run(ctx)
+	slog.Info("Metadata refresh scheduler started", "interval", s.interval, "maxAge", s.maxAge)
+}
+
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.tick(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	cutoff := time.Now().Add(-s.maxAge)
+	n, err := s.pool.EnqueueRefresh(ctx, models.RefreshFilter{
+		EnrichedBefore: cutoff,
+		QueuedBefore:   cutoff,
+		Limit:          refreshBatch,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("Can't queue stale songs for refresh", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("Queued stale songs for refresh", "count", n)
+	}
+}
@@ -0,0 +1,257 @@
+// Package events feeds live clients the change events of the outbox. The
+// Broker follows the outbox rather than being one of its sinks, so every
+// instance sees every event, and keeps the recent ones for clients that
+// reconnect.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// batchSize is how many events are read from the outbox at once.
+	batchSize = 500
+	// clientBuffer is how many events a client may fall behind before it is
+	// dropped; it resumes from the history when it reconnects.
+	clientBuffer = 64
+)
+
+var (
+	clients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "events_clients",
+		Help: "Clients connected to the change feed.",
+	})
+
+	dropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "events_clients_dropped_total",
+		Help: "Change feed clients dropped for falling behind.",
+	})
+)
+
+// Filter picks the events a client gets. Zero values match everything.
+type Filter struct {
+	Types []string
+	// Artist matches the song's group, ignoring case.
+	Artist string
+}
+
+func (f Filter) match(e entry) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.event.Type) {
+		return false
+	}
+	return f.Artist == "" || strings.EqualFold(f.Artist, e.artist)
+}
+
+type entry struct {
+	event  models.Event
+	artist string
+}
+
+// Subscription delivers the events matching its filter until it is
+// cancelled, the client falls behind or the broker stops.
+type Subscription struct {
+	filter Filter
+	// after skips events the client already had from the history.
+	after  int64
+	events chan models.Event
+	once   sync.Once
+}
+
+// Events is closed when the subscription ends.
+func (s *Subscription) Events() <-chan models.Event {
+	return s.events
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.events) })
+}
+
+type Broker struct {
+	db       database.Service
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	history []entry
+	// last is the newest sequence number read.
+	last int64
+	subs map[*Subscription]struct{}
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewBroker follows the outbox of db, looking for new events every interval
+// unless notified sooner.
+func NewBroker(db database.Service, cfg config.Events, interval time.Duration) *Broker {
+	return &Broker{
+		db:       db,
+		size:     cfg.History,
+		interval: interval,
+		subs:     make(map[*Subscription]struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (b *Broker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	b.wg.Add(1)
+	go b.run(ctx)
+}
+
+// Stop ends every subscription.
+func (b *Broker) Stop() {
+	if b.cancel == nil {
+		return
+	}
+	b.cancel()
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+// Notify wakes the broker to read events numbered just now.
+func (b *Broker) Notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Subscribe starts a subscription after the event with sequence number
+// lastId, 0 for only new events. replay holds the kept events the client
+// missed; complete is false if some of them are no longer kept.
+func (b *Broker) Subscribe(lastId int64, filter Filter) (sub *Subscription, replay []models.Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = true
+	if lastId > 0 {
+		if len(b.history) > 0 {
+			complete = lastId >= b.history[0].event.Seq-1
+		} else {
+			// nothing is kept to replay, so only a client that had the
+			// newest event missed none
+			complete = lastId >= b.last
+		}
+		for _, e := range b.history {
+			if e.event.Seq > lastId && filter.match(e) {
+				replay = append(replay, e.event)
+			}
+		}
+	}
+
+	sub = &Subscription{filter: filter, after: max(lastId, b.last), events: make(chan models.Event, clientBuffer)}
+	b.subs[sub] = struct{}{}
+	clients.Inc()
+	return sub, replay, complete
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub)
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	sub.close()
+	clients.Dec()
+}
+
+func (b *Broker) run(ctx context.Context) {
+	defer b.wg.Done()
+
+	for ctx.Err() == nil {
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Can't read change events", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-b.wake:
+		case <-time.After(b.interval):
+		}
+	}
+}
+
+// poll reads the events numbered since the last one. Until it has seen one
+// it starts from the newest events kept, not the whole outbox.
+func (b *Broker) poll(ctx context.Context) error {
+	for {
+		var events []models.Event
+		var err error
+		if b.last == 0 {
+			events, err = b.db.LatestOutbox(ctx, b.size)
+		} else {
+			events, err = b.db.ListOutbox(ctx, b.last, batchSize)
+		}
+		if err != nil {
+			return err
+		}
+		b.publish(events)
+		if b.last == 0 || len(events) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (b *Broker) publish(events []models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		e := entry{event: event, artist: artistOf(event)}
+		b.history = append(b.history, e)
+		b.last = event.Seq
+
+		for sub := range b.subs {
+			if event.Seq <= sub.after || !sub.filter.match(e) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				dropped.Inc()
+				b.remove(sub)
+			}
+		}
+	}
+	if extra := len(b.history) - b.size; extra > 0 {
+		b.history = slices.Delete(b.history, 0, extra)
+	}
+}
+
+// artistOf is the group of the song an event is about.
+func artistOf(event models.Event) string {
+	var data struct {
+		Song struct {
+			Group string `json:"group"`
+		} `json:"song"`
+	}
+	json.Unmarshal(event.Data, &data)
+	return data.Song.Group
+}
@@ -0,0 +1,217 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/models"
+)
+
+func event(seq int64, typ, group string) models.Event {
+	return models.Event{
+		Seq:       seq,
+		Id:        fmt.Sprintf("evt-%d", seq),
+		Type:      typ,
+		CreatedAt: time.Now(),
+		Data:      []byte(fmt.Sprintf(`{"song":{"group":%q,"song":"Song %d"}}`, group, seq)),
+	}
+}
+
+// newBroker keeps history events and has seen seqs 1 to n, alternating
+// between Muse's creates and Queen's updates.
+func newBroker(history, n int) *Broker {
+	b := NewBroker(database.NewMemory(), config.Events{History: history}, time.Hour)
+	var events []models.Event
+	for seq := range int64(n) {
+		if seq%2 == 0 {
+			events = append(events, event(seq+1, "song.created", "Muse"))
+		} else {
+			events = append(events, event(seq+1, "song.updated", "Queen"))
+		}
+	}
+	b.publish(events)
+	return b
+}
+
+func seqs(events []models.Event) []int64 {
+	var seqs []int64
+	for _, event := range events {
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  int
+		lastId   int64
+		filter   Filter
+		replay   []int64
+		complete bool
+	}{
+		{name: "new events only", history: 3, lastId: 0, complete: true},
+		{name: "missed some kept", history: 3, lastId: 3, replay: []int64{4, 5}, complete: true},
+		{name: "missed all kept", history: 3, lastId: 2, replay: []int64{3, 4, 5}, complete: true},
+		{name: "missed more than kept", history: 3, lastId: 1, replay: []int64{3, 4, 5}, complete: false},
+		{name: "up to date", history: 3, lastId: 5, complete: true},
+		{name: "filtered", history: 3, lastId: 2, filter: Filter{Types: []string{"song.updated"}}, replay: []int64{4}, complete: true},
+		{name: "nothing kept, up to date", history: 0, lastId: 5, complete: true},
+		{name: "nothing kept, missed some", history: 0, lastId: 4, complete: false},
+	}
+
+	for _, tt := range tests {
+		b := newBroker(tt.history, 5)
+		sub, replay, complete := b.Subscribe(tt.lastId, tt.filter)
+		if !slices.Equal(seqs(replay), tt.replay) || complete != tt.complete {
+			t.Errorf("%s: Subscribe(%d) replays %v, complete %v; want %v, %v", tt.name, tt.lastId, seqs(replay), complete, tt.replay, tt.complete)
+		}
+		b.Unsubscribe(sub)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	created := entry{event: event(1, "song.created", "Muse"), artist: "Muse"}
+	tests := []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{}, true},
+		{Filter{Types: []string{"song.created", "song.deleted"}}, true},
+		{Filter{Types: []string{"song.updated"}}, false},
+		{Filter{Artist: "muse"}, true},
+		{Filter{Artist: "Queen"}, false},
+		{Filter{Types: []string{"song.created"}, Artist: "MUSE"}, true},
+		{Filter{Types: []string{"song.updated"}, Artist: "Muse"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.match(created); got != tt.match {
+			t.Errorf("%+v matches a Muse song.created: %v, want %v", tt.filter, got, tt.match)
+		}
+	}
+	if got := artistOf(created.event); got != "Muse" {
+		t.Errorf("artistOf = %q, want Muse", got)
+	}
+}
+
+func TestLiveEvents(t *testing.T) {
+	b := newBroker(10, 2)
+	all, _, _ := b.Subscribe(0, Filter{})
+	queen, _, _ := b.Subscribe(0, Filter{Artist: "queen"})
+	// a client that already has seq 3 from elsewhere
+	ahead, _, _ := b.Subscribe(3, Filter{})
+
+	b.publish([]models.Event{event(3, "song.created", "Muse"), event(4, "song.deleted", "Queen")})
+	for _, tt := range []struct {
+		name string
+		sub  *Subscription
+		want []int64
+	}{
+		{"all", all, []int64{3, 4}},
+		{"queen", queen, []int64{4}},
+		{"ahead", ahead, []int64{4}},
+	} {
+		var got []int64
+		for len(got) < len(tt.want) {
+			got = append(got, (<-tt.sub.Events()).Seq)
+		}
+		if !slices.Equal(got, tt.want) || len(tt.sub.Events()) != 0 {
+			t.Errorf("%s got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	b.Unsubscribe(queen)
+	if _, ok := <-queen.Events(); ok {
+		t.Errorf("unsubscribed client still gets events")
+	}
+	b.Unsubscribe(queen)
+}
+
+func TestSlowClientDropped(t *testing.T) {
+	b := newBroker(10, 0)
+	slow, _, _ := b.Subscribe(0, Filter{})
+	fast, _, _ := b.Subscribe(0, Filter{})
+
+	var events []models.Event
+	for seq := range int64(clientBuffer + 1) {
+		events = append(events, event(seq+1, "song.created", "Muse"))
+	}
+	b.publish(events[:clientBuffer])
+	for range clientBuffer {
+		<-fast.Events()
+	}
+	b.publish(events[clientBuffer:])
+
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	if n != clientBuffer {
+		t.Errorf("slow client got %d events before it was dropped, want the %d buffered", n, clientBuffer)
+	}
+	if e := <-fast.Events(); e.Seq != clientBuffer+1 {
+		t.Errorf("client keeping up got seq %d, want %d", e.Seq, clientBuffer+1)
+	}
+	if _, ok := b.subs[slow]; ok || len(b.subs) != 1 {
+		t.Errorf("broker has %d subscriptions, want only the one keeping up", len(b.subs))
+	}
+}
+
+// TestFollowOutbox runs the broker on the outbox: it starts from the newest
+// events kept and passes on the ones numbered later.
+func TestFollowOutbox(t *testing.T) {
+	db := database.NewMemory()
+	ctx := context.Background()
+	appendEvents := func(ids ...string) {
+		t.Helper()
+		for _, id := range ids {
+			if err := db.AppendOutbox(ctx, models.Event{Id: id, Type: "song.created", CreatedAt: time.Now(), Data: []byte("{}")}); err != nil {
+				t.Fatalf("AppendOutbox: %v", err)
+			}
+		}
+		if _, err := db.SequenceOutbox(ctx); err != nil {
+			t.Fatalf("SequenceOutbox: %v", err)
+		}
+	}
+	appendEvents("e1", "e2", "e3")
+
+	b := NewBroker(db, config.Events{History: 2}, time.Hour)
+	b.Start()
+	defer b.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var replay []models.Event
+	var sub *Subscription
+	for {
+		sub, replay, _ = b.Subscribe(1, Filter{})
+		if len(replay) > 0 || time.Now().After(deadline) {
+			break
+		}
+		b.Unsubscribe(sub)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !slices.Equal(seqs(replay), []int64{2, 3}) {
+		t.Fatalf("replay = %v, want the newest 2 kept", seqs(replay))
+	}
+
+	appendEvents("e4")
+	b.Notify()
+	select {
+	case e := <-sub.Events():
+		if e.Seq != 4 || e.Id != "e4" {
+			t.Errorf("live event = %+v, want e4", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker didn't pass on the new event")
+	}
+
+	b.Stop()
+	if _, ok := <-sub.Events(); ok {
+		t.Errorf("subscription still open after Stop")
+	}
+}
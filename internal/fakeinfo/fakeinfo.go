@@ -0,0 +1,167 @@
+package fakeinfo
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed fixtures.yaml
+var defaultFixtures []byte
+
+// Song is one fixture entry. Dates use the DD.MM.YYYY format of the real
+// service.
+type Song struct {
+	Group       string `json:"group" yaml:"group"`
+	Song        string `json:"song" yaml:"song"`
+	ReleaseDate string `json:"releaseDate" yaml:"releaseDate"`
+	Text        string `json:"text" yaml:"text"`
+	Link        string `json:"link" yaml:"link"`
+}
+
+type songDetail struct {
+	ReleaseDate string `json:"releaseDate"`
+	Text        string `json:"text"`
+	Link        string `json:"link"`
+}
+
+// Options inject failures. Rates are probabilities between 0 and 1 applied
+// to every request independently.
+type Options struct {
+	Latency      time.Duration
+	ErrorRate    float64
+	NotFoundRate float64
+}
+
+// Server implements GET /info?group=&song= from docs/external-api.yml.
+type Server struct {
+	mu    sync.RWMutex
+	songs map[string]Song
+	opts  Options
+}
+
+func New(songs []Song, opts Options) *Server {
+	s := &Server{
+		songs: make(map[string]Song, len(songs)),
+		opts:  opts,
+	}
+	for _, song := range songs {
+		s.songs[key(song.Group, song.Song)] = song
+	}
+	return s
+}
+
+// NewTestServer starts the fake in-process; point EXTERNAL_API_URL (or
+// config.MusicAPI.URL) at the returned server's URL + "/info".
+func NewTestServer(songs []Song, opts Options) (*httptest.Server, *Server) {
+	s := New(songs, opts)
+	return httptest.NewServer(s), s
+}
+
+func DefaultFixtures() []Song {
+	songs, err := parseFixtures(defaultFixtures, ".yaml")
+	if err != nil {
+		panic(fmt.Sprintf("fakeinfo: bad embedded fixtures: %s", err))
+	}
+	return songs
+}
+
+// LoadFixtures reads a JSON or YAML list of songs, picking the format by
+// file extension.
+func LoadFixtures(path string) ([]Song, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFixtures(data, filepath.Ext(path))
+}
+
+func parseFixtures(data []byte, ext string) ([]Song, error) {
+	var songs []Song
+	var err error
+
+	switch strings.ToLower(ext) {
+	case ".json":
+		err = json.Unmarshal(data, &songs)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &songs)
+	default:
+		return nil, fmt.Errorf("unsupported fixture format %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+func (s *Server) SetOptions(opts Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts = opts
+}
+
+func (s *Server) Add(song Song) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.songs[key(song.Group, song.Song)] = song
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/info" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	group, name := r.URL.Query().Get("group"), r.URL.Query().Get("song")
+
+	s.mu.RLock()
+	opts := s.opts
+	song, found := s.songs[key(group, name)]
+	s.mu.RUnlock()
+
+	if opts.Latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(opts.Latency):
+		}
+	}
+
+	switch {
+	case group == "" || name == "":
+		http.Error(w, "group and song are required", http.StatusBadRequest)
+	case rand.Float64() < opts.ErrorRate:
+		slog.Debug("Injected error", "group", group, "song", name)
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+	case !found || rand.Float64() < opts.NotFoundRate:
+		http.NotFound(w, r)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(songDetail{
+			ReleaseDate: song.ReleaseDate,
+			Text:        song.Text,
+			Link:        song.Link,
+		})
+	}
+}
+
+func key(group, song string) string {
+	return strings.ToLower(strings.TrimSpace(group)) + "\x1f" + strings.ToLower(strings.TrimSpace(song))
+}
@@ -0,0 +1,144 @@
+// Package gql serves the song library over GraphQL. Operations are parsed,
+// validated and checked against the depth and complexity limits before
+// anything runs, and the artists of the songs in a response are looked up
+// in one query per level rather than one per song.
+package gql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+	"music-library/internal/library"
+	"music-library/internal/logging"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type Schema struct {
+	schema        graphql.Schema
+	lib           *library.Library
+	maxDepth      int
+	maxComplexity int
+}
+
+func New(lib *library.Library, cfg config.GraphQL) (*Schema, error) {
+	schema, err := newSchema(&resolver{lib: lib})
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{
+		schema:        schema,
+		lib:           lib,
+		maxDepth:      cfg.MaxDepth,
+		maxComplexity: cfg.MaxComplexity,
+	}, nil
+}
+
+// Request is a GraphQL request as sent in a POST body.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is what an operation returned. Data is null for failed
+// mutations and holds null fields for failed queries.
+type Response struct {
+	Data   any                        `json:"data" swaggertype:"object"`
+	Errors []gqlerrors.FormattedError `json:"errors,omitempty" swaggertype:"array,object"`
+}
+
+// Rejection lists why an operation was rejected before it ran.
+type Rejection struct {
+	Errors []gqlerrors.FormattedError `json:"errors" swaggertype:"array,object"`
+}
+
+// Operation is a request that is valid and within the limits.
+type Operation struct {
+	doc *ast.Document
+	req Request
+	// Mutations names the top-level fields of a mutation, nil for queries.
+	Mutations []string
+}
+
+// Prepare parses req and checks it against the schema and the limits.
+func (s *Schema) Prepare(req Request) (*Operation, []gqlerrors.FormattedError) {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return nil, gqlerrors.FormatErrors(err)
+	}
+	if res := graphql.ValidateDocument(&s.schema, doc, nil); !res.IsValid {
+		return nil, res.Errors
+	}
+
+	def := operation(doc, req.OperationName)
+	if def == nil {
+		if req.OperationName == "" {
+			return nil, gqlerrors.FormatErrors(errors.New("operationName is required with several operations"))
+		}
+		return nil, gqlerrors.FormatErrors(fmt.Errorf("unknown operation %q", req.OperationName))
+	}
+
+	m := newMeasure(doc, req.Variables, s.maxComplexity)
+	depth, complexity := m.selectionSet(def.SelectionSet)
+	if depth > s.maxDepth {
+		return nil, gqlerrors.FormatErrors(fmt.Errorf("operation nests %d fields deep, more than the limit of %d", depth, s.maxDepth))
+	}
+	if complexity > s.maxComplexity {
+		return nil, gqlerrors.FormatErrors(fmt.Errorf("operation complexity is over the limit of %d", s.maxComplexity))
+	}
+
+	op := &Operation{doc: doc, req: req}
+	if def.Operation == ast.OperationTypeMutation {
+		op.Mutations = m.fieldNames(def.SelectionSet)
+	}
+	return op, nil
+}
+
+// Execute runs op. Errors of single fields come back next to the data.
+func (s *Schema) Execute(ctx context.Context, op *Operation) Response {
+	ctx = context.WithValue(ctx, loaderKey{}, newArtistLoader(s.lib))
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.schema,
+		AST:           op.doc,
+		OperationName: op.req.OperationName,
+		Args:          op.req.Variables,
+		Context:       ctx,
+	})
+	return Response{Data: res.Data, Errors: res.Errors}
+}
+
+// operation picks the operation of doc named name, or its only one.
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
+
+// publicError hides the details of unexpected errors from clients.
+func publicError(ctx context.Context, err error) error {
+	if errors.Is(err, customErrors.ErrNotFound) || errors.Is(err, customErrors.ErrNoVerse) || errors.Is(err, customErrors.ErrInvalidData) {
+		return err
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "GraphQL resolver", "error", err.Error())
+	return customErrors.ErrISE
+}
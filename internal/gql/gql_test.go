@@ -0,0 +1,149 @@
+package gql_test
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/gql"
+	"music-library/internal/library"
+	"music-library/internal/models"
+)
+
+// countingDB records the artist lookups made through it.
+type countingDB struct {
+	database.Service
+
+	mu      sync.Mutex
+	lookups [][]string
+}
+
+func (db *countingDB) GetArtistsByName(ctx context.Context, names []string) ([]models.Artist, error) {
+	db.mu.Lock()
+	db.lookups = append(db.lookups, slices.Sorted(slices.Values(names)))
+	db.mu.Unlock()
+	return db.Service.GetArtistsByName(ctx, names)
+}
+
+func newSchema(t *testing.T, cfg config.GraphQL) (*gql.Schema, *countingDB) {
+	t.Helper()
+	db := &countingDB{Service: database.NewMemory()}
+	for _, song := range []models.Song{
+		{Group: "Muse", Song: "Starlight"},
+		{Group: "Queen", Song: "Bohemian Rhapsody"},
+		{Group: "Muse", Song: "Uprising"},
+	} {
+		if _, err := db.AddNewSong(context.Background(), song); err != nil {
+			t.Fatalf("AddNewSong: %v", err)
+		}
+	}
+	schema, err := gql.New(library.New(db, nil, nil), cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return schema, db
+}
+
+func TestLimits(t *testing.T) {
+	schema, _ := newSchema(t, config.GraphQL{MaxDepth: 3, MaxComplexity: 100})
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		// reason is part of the error of a rejected operation
+		reason string
+	}{
+		{name: "within depth", query: `{ songs { artist { name } } }`},
+		{name: "too deep", query: `{ songs { artist { songs { id } } } }`, reason: "nests 4 fields deep, more than the limit of 3"},
+		{name: "too deep through a fragment", query: `{ songs { ...S } } fragment S on Song { artist { songs { id } } }`, reason: "nests 4 fields deep"},
+		{name: "within complexity", query: `{ songs(limit: 49) { id group } }`},
+		{name: "page too large", query: `{ songs(limit: 50) { id group } }`, reason: "complexity is over the limit of 100"},
+		{name: "default page", query: `{ a: songs { id } b: songs { id } c: songs { id } }`},
+		{name: "nested pages", query: `{ artists(limit: 10) { songs(limit: 10) { id } } }`, reason: "complexity is over the limit"},
+		{name: "page from a variable", query: `query($n: Int) { songs(limit: $n) { id group } }`, variables: map[string]any{"n": 50.0}, reason: "complexity is over the limit"},
+		{name: "page from a default", query: `query($n: Int = 50) { songs(limit: $n) { id group } }`, reason: "complexity is over the limit"},
+		{name: "small page from a variable", query: `query($n: Int = 50) { songs(limit: $n) { id group } }`, variables: map[string]any{"n": 5.0}},
+		{name: "page past any limit", query: `{ artists(limit: 2147483647) { songs(limit: 2147483647) { id } } }`, reason: "complexity is over the limit"},
+		{name: "spread fragments", query: `{ ...A ...A } fragment A on Query { songs(limit: 30) { id } }`},
+		{name: "fragments spread too often", query: `{ ...A ...A ...A ...A } fragment A on Query { songs(limit: 30) { id } }`, reason: "complexity is over the limit"},
+		{name: "introspection", query: `{ __schema { types { name fields { name type { name ofType { name } } } } } }`},
+		{name: "invalid", query: `{ songs { nope } }`, reason: `Cannot query field "nope"`},
+	}
+
+	for _, tt := range tests {
+		_, errs := schema.Prepare(gql.Request{Query: tt.query, Variables: tt.variables})
+		if tt.reason == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: Prepare rejected it: %v", tt.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Message, tt.reason) {
+			t.Errorf("%s: Prepare errors = %v, want one because %s", tt.name, errs, tt.reason)
+		}
+	}
+}
+
+func execute(t *testing.T, schema *gql.Schema, query string) string {
+	t.Helper()
+	op, errs := schema.Prepare(gql.Request{Query: query})
+	if len(errs) > 0 {
+		t.Fatalf("Prepare: %v", errs)
+	}
+	res := schema.Execute(context.Background(), op)
+	if len(res.Errors) > 0 {
+		t.Fatalf("Execute: %v", res.Errors)
+	}
+	data, err := json.Marshal(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestArtistLoader(t *testing.T) {
+	schema, db := newSchema(t, config.Default().GraphQL)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		lookups [][]string
+	}{
+		{
+			name:    "artists of a page of songs",
+			query:   `{ songs { song artist { name } } }`,
+			want:    `{"songs":[{"artist":{"name":"Muse"},"song":"Starlight"},{"artist":{"name":"Queen"},"song":"Bohemian Rhapsody"},{"artist":{"name":"Muse"},"song":"Uprising"}]}`,
+			lookups: [][]string{{"Muse", "Queen"}},
+		},
+		{
+			name:    "artists by name",
+			query:   `{ a: artist(name: "Muse") { name } b: artist(name: "Nobody") { name } c: artist(name: "Queen") { name } }`,
+			want:    `{"a":{"name":"Muse"},"b":null,"c":{"name":"Queen"}}`,
+			lookups: [][]string{{"Muse", "Nobody", "Queen"}},
+		},
+		{
+			// the second level finds the artists loaded for the first
+			name:    "artists loaded once per request",
+			query:   `{ artists { songs { artist { songs(limit: 1) { artist { name } } } } } }`,
+			want:    `{"artists":[{"songs":[{"artist":{"songs":[{"artist":{"name":"Muse"}}]}},{"artist":{"songs":[{"artist":{"name":"Muse"}}]}}]},{"songs":[{"artist":{"songs":[{"artist":{"name":"Queen"}}]}}]}]}`,
+			lookups: [][]string{{"Muse", "Queen"}},
+		},
+	}
+
+	for _, tt := range tests {
+		db.lookups = nil
+		if got := execute(t, schema, tt.query); got != tt.want {
+			t.Errorf("%s: data = %s, want %s", tt.name, got, tt.want)
+		}
+		if !slices.EqualFunc(db.lookups, tt.lookups, slices.Equal) {
+			t.Errorf("%s: artist lookups = %v, want %v", tt.name, db.lookups, tt.lookups)
+		}
+	}
+}
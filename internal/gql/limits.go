@@ -0,0 +1,143 @@
+package gql
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// paginated are the fields returning a page of items, whose selections
+// are counted once per item.
+var paginated = []string{"songs", "artists"}
+
+// measure finds how deep the fields of an operation nest and how many it
+// may resolve: each field counts 1, and the fields below a paginated one
+// as many times as its limit. Introspection doesn't touch the database and
+// isn't counted. Counts saturate just above the limit, so no query can
+// overflow them.
+type measure struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+	// defaults are the default values of the operation's variables.
+	defaults map[string]ast.Value
+	ceiling  int
+	// measured caches fragments, which may be spread many times.
+	measured map[string][2]int
+}
+
+func newMeasure(doc *ast.Document, variables map[string]any, maxComplexity int) *measure {
+	m := &measure{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		defaults:  make(map[string]ast.Value),
+		ceiling:   maxComplexity + 1,
+		measured:  make(map[string][2]int),
+	}
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			m.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			for _, v := range def.VariableDefinitions {
+				if v.DefaultValue != nil {
+					m.defaults[v.Variable.Name.Value] = v.DefaultValue
+				}
+			}
+		}
+	}
+	return m
+}
+
+// selectionSet measures set, which validation made sure has no fragment
+// cycles.
+func (m *measure) selectionSet(set *ast.SelectionSet) (depth, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, selection := range set.Selections {
+		var d, c int
+		switch sel := selection.(type) {
+		case *ast.Field:
+			if sel.Name.Value == "__schema" || sel.Name.Value == "__type" {
+				continue
+			}
+			d, c = m.selectionSet(sel.SelectionSet)
+			d++
+			c = min(1+c*m.items(sel), m.ceiling)
+		case *ast.InlineFragment:
+			d, c = m.selectionSet(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			d, c = m.fragment(sel.Name.Value)
+		}
+		depth = max(depth, d)
+		complexity = min(complexity+c, m.ceiling)
+	}
+	return depth, complexity
+}
+
+func (m *measure) fragment(name string) (depth, complexity int) {
+	if r, ok := m.measured[name]; ok {
+		return r[0], r[1]
+	}
+	if def, ok := m.fragments[name]; ok {
+		depth, complexity = m.selectionSet(def.SelectionSet)
+	}
+	m.measured[name] = [2]int{depth, complexity}
+	return depth, complexity
+}
+
+// items is how many items field may return, the page size for paginated
+// fields and 1 for the rest.
+func (m *measure) items(field *ast.Field) int {
+	if !slices.Contains(paginated, field.Name.Value) {
+		return 1
+	}
+	limit := 10
+	for _, arg := range field.Arguments {
+		if arg.Name.Value == "limit" {
+			if n, ok := m.int(arg.Value); ok && n > 0 {
+				limit = n
+			}
+		}
+	}
+	return min(limit, m.ceiling)
+}
+
+func (m *measure) int(value ast.Value) (int, bool) {
+	switch v := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(v.Value)
+		return n, err == nil
+	case *ast.Variable:
+		switch n := m.variables[v.Name.Value].(type) {
+		case float64:
+			return int(min(n, float64(m.ceiling))), true
+		case int:
+			return n, true
+		case nil:
+			if def, ok := m.defaults[v.Name.Value]; ok {
+				return m.int(def)
+			}
+		}
+	}
+	return 0, false
+}
+
+// fieldNames lists the fields set selects itself, not those below them.
+func (m *measure) fieldNames(set *ast.SelectionSet) []string {
+	var names []string
+	for _, selection := range set.Selections {
+		switch sel := selection.(type) {
+		case *ast.Field:
+			names = append(names, sel.Name.Value)
+		case *ast.InlineFragment:
+			names = append(names, m.fieldNames(sel.SelectionSet)...)
+		case *ast.FragmentSpread:
+			if def, ok := m.fragments[sel.Name.Value]; ok {
+				names = append(names, m.fieldNames(def.SelectionSet)...)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,75 @@
+package gql
+
+import (
+	"context"
+	"slices"
+
+	"music-library/internal/library"
+	"music-library/internal/models"
+)
+
+type loaderKey struct{}
+
+// artistLoader batches the artist lookups of one request. A resolver asking
+// for an artist gets a thunk, which the executor runs only after resolving
+// the rest of the level, so the first one run looks up every name asked
+// for by then in one query. Execution is sequential, so it needs no lock.
+type artistLoader struct {
+	lib     *library.Library
+	pending []string
+	// loaded holds the lookups done, nil for unknown artists.
+	loaded map[string]*models.Artist
+	// failed holds why the lookups of a batch failed.
+	failed map[string]error
+}
+
+func newArtistLoader(lib *library.Library) *artistLoader {
+	return &artistLoader{
+		lib:    lib,
+		loaded: make(map[string]*models.Artist),
+		failed: make(map[string]error),
+	}
+}
+
+func loaderFrom(ctx context.Context) *artistLoader {
+	return ctx.Value(loaderKey{}).(*artistLoader)
+}
+
+// load queues name for the next batch and returns the thunk resolving its
+// artist.
+func (l *artistLoader) load(ctx context.Context, name string) func() (any, error) {
+	_, done := l.loaded[name]
+	if !done && l.failed[name] == nil && !slices.Contains(l.pending, name) {
+		l.pending = append(l.pending, name)
+	}
+	return func() (any, error) {
+		l.flush(ctx)
+		if err := l.failed[name]; err != nil {
+			return nil, publicError(ctx, err)
+		}
+		if artist := l.loaded[name]; artist != nil {
+			return *artist, nil
+		}
+		return nil, nil
+	}
+}
+
+func (l *artistLoader) flush(ctx context.Context) {
+	if len(l.pending) == 0 {
+		return
+	}
+	names := l.pending
+	l.pending = nil
+
+	artists, err := l.lib.ArtistsByName(ctx, names)
+	for _, name := range names {
+		if err != nil {
+			l.failed[name] = err
+			continue
+		}
+		l.loaded[name] = nil
+	}
+	for _, artist := range artists {
+		l.loaded[artist.Artist] = &artist
+	}
+}
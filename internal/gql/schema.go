@@ -0,0 +1,341 @@
+package gql
+
+import (
+	"strconv"
+	"strings"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/library"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+
+	"github.com/graphql-go/graphql"
+)
+
+type resolver struct {
+	lib *library.Library
+}
+
+func newSchema(r *resolver) (graphql.Schema, error) {
+	var songType *graphql.Object
+
+	artistType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Artist",
+		Description: "A group songs are by.",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id": {Type: graphql.NewNonNull(graphql.ID)},
+				"name": {
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return p.Source.(models.Artist).Artist, nil
+					},
+				},
+				"songs": {
+					Type:        songList(songType),
+					Description: "The artist's songs, filtered and paginated like Query.songs.",
+					Args:        songArgs(false),
+					Resolve:     r.artistSongs,
+				},
+			}
+		}),
+	})
+
+	songType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Song",
+		Fields: graphql.Fields{
+			"id":    {Type: graphql.NewNonNull(graphql.ID)},
+			"group": {Type: graphql.NewNonNull(graphql.String)},
+			"song":  {Type: graphql.NewNonNull(graphql.String)},
+			"releaseDate": {
+				Type:        graphql.String,
+				Description: "YYYY-MM-DD, null until known.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return optional(p.Source.(models.Song).Value(models.FieldReleaseDate)), nil
+				},
+			},
+			"text":             {Type: graphql.NewNonNull(graphql.String)},
+			"link":             {Type: graphql.NewNonNull(graphql.String)},
+			"enrichmentStatus": {Type: graphql.NewNonNull(graphql.String)},
+			"enrichedAt":       {Type: graphql.DateTime},
+			"editedFields": {
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Description: "Metadata fields edited by hand, which refreshes keep.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return append([]string{}, p.Source.(models.Song).EditedFields...), nil
+				},
+			},
+			"createdBy": {
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return optional(p.Source.(models.Song).CreatedBy), nil
+				},
+			},
+			"updatedBy": {
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return optional(p.Source.(models.Song).UpdatedBy), nil
+				},
+			},
+			"artist": {
+				Type:        artistType,
+				Description: "The artist of the song's group. Artists are looked up together for all songs of a response.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loaderFrom(p.Context).load(p.Context, p.Source.(models.Song).Group), nil
+				},
+			},
+			"verses": {
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Description: "The lyrics split at blank lines.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return library.Verses(p.Source.(models.Song)), nil
+				},
+			},
+			"verse": {
+				Type:        graphql.String,
+				Description: "One verse of the lyrics, from 1. Past either end it is null with a \"verse not found\" error.",
+				Args: graphql.FieldConfigArgument{
+					"number": {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					text, _, err := library.Verse(p.Source.(models.Song), p.Args["number"].(int))
+					if err != nil {
+						return nil, publicError(p.Context, err)
+					}
+					return text, nil
+				},
+			},
+		},
+	})
+
+	acceptedType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "AcceptedSong",
+		Description: "A song stored and queued for enrichment from the music info service.",
+		Fields: graphql.Fields{
+			"id":               {Type: graphql.NewNonNull(graphql.ID)},
+			"jobId":            {Type: graphql.NewNonNull(graphql.ID), Description: "The enrichment job, see GET /jobs/{id}."},
+			"enrichmentStatus": {Type: graphql.NewNonNull(graphql.String)},
+			"song": {
+				Type: songType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return r.song(p, p.Source.(models.AcceptedSong).Id)
+				},
+			},
+		},
+	})
+
+	songInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "SongInput",
+		Description: "Fields to change; those left out keep their values.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"song":        {Type: graphql.String},
+			"releaseDate": {Type: graphql.String, Description: "YYYY-MM-DD or DD.MM.YYYY, empty to clear."},
+			"text":        {Type: graphql.String},
+			"link":        {Type: graphql.String},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"songs": {
+				Type:    songList(songType),
+				Args:    songArgs(true),
+				Resolve: r.songs,
+			},
+			"song": {
+				Type: songType,
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return r.song(p, p.Args["id"])
+				},
+			},
+			"artists": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(artistType))),
+				Args: pageArgs(),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					artists, err := r.lib.Artists(p.Context, paginator(p.Args))
+					if err != nil {
+						return nil, publicError(p.Context, err)
+					}
+					return artists, nil
+				},
+			},
+			"artist": {
+				Type: artistType,
+				Args: graphql.FieldConfigArgument{
+					"name": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loaderFrom(p.Context).load(p.Context, p.Args["name"].(string)), nil
+				},
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createSong": {
+				Type:        graphql.NewNonNull(acceptedType),
+				Description: "Add a song; it is enriched in the background.",
+				Args: graphql.FieldConfigArgument{
+					"group": {Type: graphql.NewNonNull(graphql.String)},
+					"song":  {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: r.createSong,
+			},
+			"updateSong": {
+				Type: graphql.NewNonNull(songType),
+				Args: graphql.FieldConfigArgument{
+					"id":    {Type: graphql.NewNonNull(graphql.ID)},
+					"input": {Type: graphql.NewNonNull(songInput)},
+				},
+				Resolve: r.updateSong,
+			},
+			"deleteSong": {
+				Type:        graphql.NewNonNull(songType),
+				Description: "Delete a song, returning it as it was.",
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					song, err := r.lib.Delete(p.Context, p.Args["id"].(string))
+					if err != nil {
+						return nil, publicError(p.Context, err)
+					}
+					return song, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+}
+
+func songList(songType *graphql.Object) graphql.Output {
+	return graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(songType)))
+}
+
+func pageArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"page":  {Type: graphql.Int, DefaultValue: 1, Description: "Page number, from 1."},
+		"limit": {Type: graphql.Int, DefaultValue: 10, Description: "Page size."},
+	}
+}
+
+// songArgs are the filters of GET /songs, without group for the songs of
+// an artist.
+func songArgs(group bool) graphql.FieldConfigArgument {
+	args := pageArgs()
+	args["q"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Search song titles and lyrics, every word must match."}
+	for _, name := range query.FilterNames {
+		if name != "group" || group {
+			args[name] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Exact match."}
+		}
+	}
+	return args
+}
+
+func paginator(args map[string]any) query.Paginator {
+	page, _ := args["page"].(int)
+	limit, _ := args["limit"].(int)
+	return query.NewPaginator(page, limit)
+}
+
+func songOptions(args map[string]any) query.Options {
+	opts := query.Options{Paginator: paginator(args)}
+	for _, name := range query.FilterNames {
+		if value, _ := args[name].(string); value != "" {
+			opts.Filters = append(opts.Filters, query.Filter{Field: name, Value: value})
+		}
+	}
+	q, _ := args["q"].(string)
+	opts.Search = strings.TrimSpace(q)
+	return opts
+}
+
+func (r *resolver) songs(p graphql.ResolveParams) (any, error) {
+	songs, err := r.lib.Songs(p.Context, songOptions(p.Args))
+	if err != nil {
+		return nil, publicError(p.Context, err)
+	}
+	return songs, nil
+}
+
+func (r *resolver) artistSongs(p graphql.ResolveParams) (any, error) {
+	opts := songOptions(p.Args)
+	opts.Filters = append(opts.Filters, query.Filter{Field: "group", Value: p.Source.(models.Artist).Artist})
+	songs, err := r.lib.Songs(p.Context, opts)
+	if err != nil {
+		return nil, publicError(p.Context, err)
+	}
+	return songs, nil
+}
+
+// song resolves the song with id, or null if there is none.
+func (r *resolver) song(p graphql.ResolveParams, id any) (any, error) {
+	song, err := r.lib.Song(p.Context, stringId(id))
+	if err == customErrors.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, publicError(p.Context, err)
+	}
+	return song, nil
+}
+
+func (r *resolver) createSong(p graphql.ResolveParams) (any, error) {
+	accepted, err := r.lib.Create(p.Context, models.NewSong{
+		Group: p.Args["group"].(string),
+		Song:  p.Args["song"].(string),
+	})
+	if err != nil {
+		return nil, publicError(p.Context, err)
+	}
+	return accepted, nil
+}
+
+func (r *resolver) updateSong(p graphql.ResolveParams) (any, error) {
+	input := p.Args["input"].(map[string]any)
+	field := func(name string) *string {
+		if v, ok := input[name].(string); ok {
+			return &v
+		}
+		return nil
+	}
+	changes := library.Changes{
+		Song:        field("song"),
+		ReleaseDate: field("releaseDate"),
+		Text:        field("text"),
+		Link:        field("link"),
+	}
+	song, err := r.lib.Update(p.Context, p.Args["id"].(string), changes.Apply)
+	if err != nil {
+		return nil, publicError(p.Context, err)
+	}
+	return song, nil
+}
+
+// stringId turns an id of the AcceptedSong or an ID argument into the
+// string the library takes.
+func stringId(id any) string {
+	if n, ok := id.(int); ok {
+		return strconv.Itoa(n)
+	}
+	s, _ := id.(string)
+	return s
+}
+
+// optional is null for empty strings.
+func optional(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
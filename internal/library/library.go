@@ -0,0 +1,236 @@
+// Package library holds the song operations the REST, GraphQL and gRPC
+// APIs share. Changes are audited and published through the outbox in the
+// transaction that makes them, and new songs are queued for enrichment.
+package library
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"music-library/internal/audit"
+	"music-library/internal/auth"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/enrichment"
+	"music-library/internal/models"
+	"music-library/internal/outbox"
+	"music-library/internal/server/query"
+)
+
+const verseDelimiter = "\n\n"
+
+type Library struct {
+	db         database.Service
+	enrichment *enrichment.Pool
+	relay      *outbox.Relay
+}
+
+// New works on db and wakes enrichment and relay after the changes that
+// give them work.
+func New(db database.Service, enrichment *enrichment.Pool, relay *outbox.Relay) *Library {
+	return &Library{db: db, enrichment: enrichment, relay: relay}
+}
+
+func (l *Library) Songs(ctx context.Context, opts query.Options) ([]models.Song, error) {
+	return l.db.GetSongs(ctx, opts)
+}
+
+func (l *Library) Song(ctx context.Context, id string) (models.Song, error) {
+	return l.db.GetSongById(ctx, id)
+}
+
+func (l *Library) Artists(ctx context.Context, paginator query.Paginator) ([]models.Artist, error) {
+	return l.db.ListArtists(ctx, paginator)
+}
+
+// ArtistsByName looks up many artists in one query; unknown names are left
+// out.
+func (l *Library) ArtistsByName(ctx context.Context, names []string) ([]models.Artist, error) {
+	return l.db.GetArtistsByName(ctx, names)
+}
+
+// Verses splits the lyrics of a song at blank lines.
+func Verses(song models.Song) []string {
+	return strings.Split(song.Text, verseDelimiter)
+}
+
+// Verse is verse number, from 1, of the song's lyrics, with how many there
+// are. A number past either end is ErrNoVerse.
+func Verse(song models.Song, number int) (text string, count int, err error) {
+	verses := Verses(song)
+	if number < 1 || number > len(verses) {
+		return "", len(verses), fmt.Errorf("%w: %d of %d", customErrors.ErrNoVerse, number, len(verses))
+	}
+	return verses[number-1], len(verses), nil
+}
+
+// ParseVerse reads a verse number given as text, e.g. in a URL.
+func ParseVerse(number string) (int, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, fmt.Errorf("%w: verse %q", customErrors.ErrInvalidData, number)
+	}
+	return n, nil
+}
+
+// Create stores a song by the caller and queues its enrichment.
+func (l *Library) Create(ctx context.Context, newSong models.NewSong) (models.AcceptedSong, error) {
+	if strings.TrimSpace(newSong.Group) == "" || strings.TrimSpace(newSong.Song) == "" {
+		return models.AcceptedSong{}, customErrors.ErrInvalidData
+	}
+
+	var id, jobId int
+	err := l.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		if id, err = tx.AddNewSong(ctx, models.Song{Group: newSong.Group, Song: newSong.Song, CreatedBy: caller(ctx)}); err != nil {
+			return err
+		}
+		if jobId, err = tx.EnqueueJob(ctx, models.Job{Kind: enrichment.KindEnrich, SongId: id}); err != nil {
+			return err
+		}
+		song, err := tx.GetSongById(ctx, strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.Create, audit.Song, id, nil, song); err != nil {
+			return err
+		}
+		return outbox.SongChanged(ctx, tx, audit.Create, nil, &song)
+	})
+	if err != nil {
+		return models.AcceptedSong{}, err
+	}
+	l.enrichment.Notify()
+	l.relay.Notify()
+
+	return models.AcceptedSong{
+		Id:               id,
+		JobId:            jobId,
+		EnrichmentStatus: models.EnrichmentPending,
+	}, nil
+}
+
+// Update has edit change the song, as read in the same transaction, and
+// stores the result as edited by the caller. edit runs again if the
+// transaction is retried. Errors of edit are returned as they are.
+func (l *Library) Update(ctx context.Context, id string, edit func(song *models.Song) error) (models.Song, error) {
+	var after models.Song
+	err := l.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		_, after, err = changeSong(ctx, tx, audit.Update, id, func(before models.Song) error {
+			song := before
+			if err := edit(&song); err != nil {
+				return err
+			}
+			song.EditedFields = editedFields(before, song)
+			song.CreatedBy = before.CreatedBy
+			song.UpdatedBy = caller(ctx)
+			return tx.UpdateSongById(ctx, id, song)
+		})
+		return err
+	})
+	if err != nil {
+		return models.Song{}, err
+	}
+	l.relay.Notify()
+	return after, nil
+}
+
+// Changes are the fields an update sets, nil for those left as they are.
+type Changes struct {
+	Song *string
+	// ReleaseDate is YYYY-MM-DD or DD.MM.YYYY, empty to clear it.
+	ReleaseDate *string
+	Text        *string
+	Link        *string
+}
+
+// Apply sets the changed fields of song.
+func (ch Changes) Apply(song *models.Song) error {
+	if ch.Song != nil {
+		if strings.TrimSpace(*ch.Song) == "" {
+			return customErrors.ErrInvalidData
+		}
+		song.Song = *ch.Song
+	}
+	if ch.ReleaseDate != nil {
+		song.ReleaseDate = models.Date{}
+		if *ch.ReleaseDate != "" {
+			date, err := models.ParseDate(*ch.ReleaseDate)
+			if err != nil {
+				return fmt.Errorf("%w: %v", customErrors.ErrInvalidData, err)
+			}
+			song.ReleaseDate = date
+		}
+	}
+	if ch.Text != nil {
+		song.Text = *ch.Text
+	}
+	if ch.Link != nil {
+		song.Link = *ch.Link
+	}
+	return nil
+}
+
+// Delete removes a song and returns it as it was.
+func (l *Library) Delete(ctx context.Context, id string) (models.Song, error) {
+	var before models.Song
+	err := l.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		before, _, err = changeSong(ctx, tx, audit.Delete, id, func(models.Song) error {
+			return tx.DeleteSongById(ctx, id)
+		})
+		return err
+	})
+	if err != nil {
+		return models.Song{}, err
+	}
+	l.relay.Notify()
+	return before, nil
+}
+
+// changeSong runs change on tx with the song as it is, records it as it was
+// before and, unless deleted, after, and appends the change event to the
+// outbox.
+func changeSong(ctx context.Context, tx database.Service, action, id string, change func(before models.Song) error) (before, after models.Song, err error) {
+	if before, err = tx.GetSongById(ctx, id); err != nil {
+		return before, after, err
+	}
+	if err = change(before); err != nil {
+		return before, after, err
+	}
+	if action == audit.Delete {
+		if err = audit.Record(ctx, tx, action, audit.Song, before.Id, before, nil); err != nil {
+			return before, after, err
+		}
+		return before, after, outbox.SongChanged(ctx, tx, action, &before, nil)
+	}
+	if after, err = tx.GetSongById(ctx, id); err != nil {
+		return before, after, err
+	}
+	if err = audit.Record(ctx, tx, action, audit.Song, before.Id, before, after); err != nil {
+		return before, after, err
+	}
+	return before, after, outbox.SongChanged(ctx, tx, action, &before, &after)
+}
+
+// editedFields adds the metadata fields changed by an update to the ones
+// already edited by hand, so refreshes from the music info service keep them.
+func editedFields(before, after models.Song) []string {
+	fields := slices.Clone(before.EditedFields)
+	for _, field := range models.MetadataFields {
+		if before.Value(field) != after.Value(field) && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// caller names who made the request, empty for anonymous requests.
+func caller(ctx context.Context) string {
+	p, _ := auth.FromContext(ctx)
+	return p.Name
+}
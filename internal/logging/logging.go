@@ -0,0 +1,50 @@
+// Package logging carries a request-scoped logger in the context and keeps
+// lyrics and credentials out of log output.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns ctx carrying l, for FromContext further down the call.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger attached to ctx, or the default one. Pass
+// ctx to its *Context methods too so trace ids end up in the line.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values never reach the output:
+// credentials, and lyrics, which are large and not ours to copy around.
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"authorization": true,
+	"cookie":        true,
+	"api_key":       true,
+	"apikey":        true,
+	"text":          true,
+	"lyrics":        true,
+}
+
+// Redact is a slog.HandlerOptions.ReplaceAttr func that blanks out the
+// values of sensitive keys at any depth.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// APIKey describes a key without the key itself, which only its holder has.
+type APIKey struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the key, enough to tell keys apart.
+	Prefix       string     `json:"prefix"`
+	Scopes       []string   `json:"scopes"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	RequestCount int64      `json:"requestCount"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	// RateLimits override the configured limits by route class.
+	RateLimits map[string]RateLimit `json:"rateLimits,omitempty"`
+}
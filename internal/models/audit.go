@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry is one change in the append-only audit log. Before is empty
+// for creates and After for deletes.
+type AuditEntry struct {
+	Id         int64           `json:"id"`
+	At         time.Time       `json:"at"`
+	Actor      string          `json:"actor"`
+	RequestId  string          `json:"requestId,omitempty"`
+	SourceIP   string          `json:"sourceIp,omitempty"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceId string          `json:"resourceId"`
+	Before     json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+}
+
+// AuditFilter selects audit entries; zero fields match everything. From is
+// inclusive and To exclusive.
+type AuditFilter struct {
+	Actor      string
+	Resource   string
+	ResourceId string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// CachedInfo is a music info lookup result as kept by the metadata cache.
+// NotFound entries remember that the upstream had nothing for the key.
+type CachedInfo struct {
+	Info      Song
+	NotFound  bool
+	ExpiresAt time.Time
+}
@@ -7,13 +7,30 @@ import (
 
 type Date time.Time
 
+// dateLayouts are tried in order; the second is what the music info
+// service returns.
+var dateLayouts = []string{"2006-01-02", "02.01.2006"}
+
 func (d Date) MarshalJSON() ([]byte, error) {
+	if time.Time(d).IsZero() {
+		return []byte("null"), nil
+	}
 	return []byte(`"` + time.Time(d).Format("2006-01-02") + `"`), nil
 }
 
 func (d *Date) UnmarshalJSON(b []byte) error {
-	fmt.Println(string(b))
-	*d = NewDateFromString(string(b[1 : len(b)-1]))
+	if string(b) == "null" {
+		*d = Date{}
+		return nil
+	}
+	if len(b) < 2 || b[0] != '"' || b[len(b)-1] != '"' {
+		return fmt.Errorf("invalid date %s", b)
+	}
+	date, err := ParseDate(string(b[1 : len(b)-1]))
+	if err != nil {
+		return err
+	}
+	*d = date
 	return nil
 }
 
@@ -29,10 +46,19 @@ func (d Date) Value() (string, error) {
 	return time.Time(d).Format("2006-01-02"), nil
 }
 
+func ParseDate(date string) (Date, error) {
+	for _, layout := range dateLayouts {
+		if res, err := time.Parse(layout, date); err == nil {
+			return Date(res), nil
+		}
+	}
+	return Date{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or DD.MM.YYYY", date)
+}
+
 func NewDateFromString(date string) Date {
-	res, err := time.Parse("date", date)
+	res, err := ParseDate(date)
 	if err != nil {
-		res = time.Now()
+		return Date(time.Now())
 	}
-	return Date(res)
+	return res
 }
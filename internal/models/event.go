@@ -0,0 +1,18 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a change published through the outbox. Data holds the song after
+// the change, or before it for deletes, and for updates the previous version.
+type Event struct {
+	// Seq numbers events in the order they are published, so consumers can
+	// resume after the last one they saw.
+	Seq       int64           `json:"seq,omitempty"`
+	Id        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data" swaggertype:"object"`
+}
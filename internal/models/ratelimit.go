@@ -0,0 +1,8 @@
+package models
+
+// RateLimit is a token bucket: PerMinute requests a minute on average, in
+// bursts of up to Burst.
+type RateLimit struct {
+	PerMinute int `json:"perMinute"`
+	Burst     int `json:"burst"`
+}
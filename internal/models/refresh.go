@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	FieldReleaseDate = "releaseDate"
+	FieldText        = "text"
+	FieldLink        = "link"
+)
+
+// MetadataFields are the song fields that come from the music info service.
+var MetadataFields = []string{FieldReleaseDate, FieldText, FieldLink}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type SongRefresh struct {
+	Id        int           `json:"id"`
+	SongId    int           `json:"songId"`
+	Changes   []FieldChange `json:"changes"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+type RefreshRequest struct {
+	SongId int    `json:"songId"`
+	Group  string `json:"group"`
+	All    bool   `json:"all"`
+}
+
+type RefreshFilter struct {
+	SongId         int
+	Group          string
+	EnrichedBefore time.Time
+	// QueuedBefore skips songs a refresh was queued for since, so songs whose
+	// refreshes fail don't take the place of the rest on every round.
+	QueuedBefore time.Time
+	Limit        int
+}
+
+type RefreshAccepted struct {
+	Enqueued int `json:"enqueued"`
+}
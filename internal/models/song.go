@@ -1,15 +1,72 @@
 package models
 
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	EnrichmentPending = "pending"
+	EnrichmentDone    = "done"
+	EnrichmentFailed  = "failed"
+)
+
 type Song struct {
-	Id          int    `json:"id"`
-	Group       string `json:"group"`
-	Song        string `json:"song"`
-	ReleaseDate Date   `json:"releaseDate"`
-	Text        string `json:"text"`
-	Link        string `json:"link"`
+	Id               int        `json:"id"`
+	Group            string     `json:"group"`
+	Song             string     `json:"song"`
+	ReleaseDate      Date       `json:"releaseDate"`
+	Text             string     `json:"text"`
+	Link             string     `json:"link"`
+	EnrichmentStatus string     `json:"enrichmentStatus"`
+	EnrichedAt       *time.Time `json:"enrichedAt,omitempty"`
+	EditedFields     []string   `json:"editedFields,omitempty"`
+	// CreatedBy and UpdatedBy name who added and last edited the song.
+	CreatedBy string `json:"createdBy,omitempty"`
+	UpdatedBy string `json:"updatedBy,omitempty"`
+}
+
+// Artist is a row of the artists table, which songs refer to by their group.
+type Artist struct {
+	Id     int    `json:"id"`
+	Artist string `json:"artist"`
+}
+
+// LogValue keeps the lyrics out of logs, only their length is shown.
+func (s Song) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", s.Id),
+		slog.String("group", s.Group),
+		slog.String("song", s.Song),
+		slog.Int("textLength", len(s.Text)),
+		slog.String("enrichmentStatus", s.EnrichmentStatus),
+	)
+}
+
+// Value returns the metadata field by its json name.
+func (s Song) Value(field string) string {
+	switch field {
+	case FieldReleaseDate:
+		if time.Time(s.ReleaseDate).IsZero() {
+			return ""
+		}
+		return s.ReleaseDate.String()
+	case FieldText:
+		return s.Text
+	case FieldLink:
+		return s.Link
+	default:
+		return ""
+	}
 }
 
 type NewSong struct {
 	Group string `json:"group"`
 	Song  string `json:"song"`
 }
+
+type AcceptedSong struct {
+	Id               int    `json:"id"`
+	JobId            int    `json:"jobId"`
+	EnrichmentStatus string `json:"enrichmentStatus"`
+}
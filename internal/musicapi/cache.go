@@ -0,0 +1,269 @@
+package musicapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/logging"
+	"music-library/internal/models"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheStore interface {
+	GetCachedInfo(ctx context.Context, key string) (models.CachedInfo, bool, error)
+	PutCachedInfo(ctx context.Context, key string, entry models.CachedInfo) error
+	PruneCachedInfo(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// pruneInterval is how often expired entries are dropped from memory and
+// the store.
+const pruneInterval = time.Hour
+
+type noCacheKey struct{}
+
+// NoCache marks a lookup that must go upstream. Its result still replaces
+// whatever the cache held.
+func NoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func skipCache(ctx context.Context) bool {
+	skip, _ := ctx.Value(noCacheKey{}).(bool)
+	return skip
+}
+
+// Cache sits in front of a Fetcher. Lookups go to memory first, then to the
+// persistent store, and only then upstream; concurrent misses for the same
+// key share a single upstream call. 404s are remembered for NegativeTTL.
+type Cache struct {
+	next  Fetcher
+	store CacheStore
+	cfg   config.Cache
+
+	mu      sync.Mutex
+	entries map[string]models.CachedInfo
+	group   singleflight.Group
+	now     func() time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewCache(next Fetcher, store CacheStore, cfg config.Cache) *Cache {
+	return &Cache{
+		next:    next,
+		store:   store,
+		cfg:     cfg,
+		entries: make(map[string]models.CachedInfo),
+		now:     time.Now,
+	}
+}
+
+// Start drops expired entries in the background until Stop.
+func (c *Cache) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go c.run(ctx)
+}
+
+func (c *Cache) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	c.wg.Wait()
+}
+
+func (c *Cache) run(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.prune(ctx)
+		}
+	}
+}
+
+func (c *Cache) prune(ctx context.Context) {
+	c.mu.Lock()
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.ExpiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.store == nil {
+		return
+	}
+	n, err := c.store.PruneCachedInfo(ctx, now)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Can't prune metadata cache", "error", err)
+		}
+		return
+	}
+	if n > 0 {
+		slog.Info("Pruned expired metadata cache entries", "count", n)
+	}
+}
+
+func (c *Cache) GetMusicInfo(ctx context.Context, groupName, songName string) (models.Song, error) {
+	key := cacheKey(groupName, songName)
+	fresh := skipCache(ctx)
+
+	if entry, ok := c.get(key); ok && !fresh {
+		if entry.NotFound {
+			cacheEvents.WithLabelValues("negative_hits").Inc()
+		} else {
+			cacheEvents.WithLabelValues("hits").Inc()
+		}
+		return entryResult(entry, groupName, songName)
+	}
+
+	leader := false
+	flight := key
+	if fresh {
+		flight = "fresh\x1f" + key
+	}
+	ch := c.group.DoChan(flight, func() (any, error) {
+		leader = true
+		// the call is shared, so one caller going away must not cancel it for the rest
+		return c.load(context.WithoutCancel(ctx), key, groupName, songName, fresh)
+	})
+
+	select {
+	case <-ctx.Done():
+		return models.Song{Group: groupName, Song: songName}, ctx.Err()
+	case res := <-ch:
+		if res.Shared && !leader {
+			cacheEvents.WithLabelValues("coalesced").Inc()
+		}
+		if res.Err != nil {
+			return models.Song{Group: groupName, Song: songName}, res.Err
+		}
+		return entryResult(res.Val.(models.CachedInfo), groupName, songName)
+	}
+}
+
+// Ping forwards to the wrapped Fetcher when it can be pinged.
+func (c *Cache) Ping(ctx context.Context) error {
+	if p, ok := c.next.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *Cache) load(ctx context.Context, key, groupName, songName string, fresh bool) (models.CachedInfo, error) {
+	if c.store != nil && !fresh {
+		entry, ok, err := c.store.GetCachedInfo(ctx, key)
+		if err != nil {
+			cacheEvents.WithLabelValues("store_errors").Inc()
+			logging.FromContext(ctx).WarnContext(ctx, "Can't read metadata cache", "error", err)
+		} else if ok {
+			cacheEvents.WithLabelValues("persistent_hits").Inc()
+			c.set(key, entry)
+			return entry, nil
+		}
+	}
+
+	cacheEvents.WithLabelValues("misses").Inc()
+	info, err := c.next.GetMusicInfo(ctx, groupName, songName)
+
+	var entry models.CachedInfo
+	switch {
+	case err == nil:
+		entry = models.CachedInfo{Info: info, ExpiresAt: c.now().Add(c.cfg.TTL)}
+	case errors.Is(err, ErrNotFound) && c.cfg.NegativeTTL > 0:
+		entry = models.CachedInfo{NotFound: true, ExpiresAt: c.now().Add(c.cfg.NegativeTTL)}
+	default:
+		return entry, err
+	}
+
+	c.set(key, entry)
+	if c.store != nil {
+		if err := c.store.PutCachedInfo(ctx, key, entry); err != nil {
+			cacheEvents.WithLabelValues("store_errors").Inc()
+			logging.FromContext(ctx).WarnContext(ctx, "Can't write metadata cache", "error", err)
+		}
+	}
+	return entry, nil
+}
+
+func (c *Cache) get(key string) (models.CachedInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return entry, false
+	}
+	if !c.now().Before(entry.ExpiresAt) {
+		delete(c.entries, key)
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *Cache) set(key string, entry models.CachedInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cfg.MaxEntries > 0 && len(c.entries) >= c.cfg.MaxEntries {
+		c.evict()
+	}
+	c.entries[key] = entry
+}
+
+// evict drops expired entries, and if that frees nothing, an arbitrary tenth
+// of the cache. Must be called with mu held.
+func (c *Cache) evict() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.ExpiresAt) {
+			delete(c.entries, key)
+		}
+	}
+
+	if len(c.entries) < c.cfg.MaxEntries {
+		return
+	}
+
+	drop := max(c.cfg.MaxEntries/10, 1)
+	for key := range c.entries {
+		if drop <= 0 {
+			break
+		}
+		delete(c.entries, key)
+		drop--
+	}
+}
+
+func entryResult(entry models.CachedInfo, groupName, songName string) (models.Song, error) {
+	if entry.NotFound {
+		return models.Song{Group: groupName, Song: songName}, ErrNotFound
+	}
+	song := entry.Info
+	song.Group = groupName
+	song.Song = songName
+	return song, nil
+}
+
+func cacheKey(groupName, songName string) string {
+	return strings.ToLower(strings.TrimSpace(groupName)) + "\x1f" + strings.ToLower(strings.TrimSpace(songName))
+}
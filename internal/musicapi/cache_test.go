@@ -0,0 +1,249 @@
+package musicapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/models"
+)
+
+// stubFetcher answers from songs, after release is closed if it is set.
+type stubFetcher struct {
+	songs   map[string]models.Song
+	release chan struct{}
+	calls   atomic.Int32
+	err     error
+}
+
+func (f *stubFetcher) GetMusicInfo(ctx context.Context, groupName, songName string) (models.Song, error) {
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	if f.err != nil {
+		return models.Song{}, f.err
+	}
+	song, ok := f.songs[groupName+"/"+songName]
+	if !ok {
+		return models.Song{}, ErrNotFound
+	}
+	return song, nil
+}
+
+func newStub() *stubFetcher {
+	return &stubFetcher{songs: map[string]models.Song{
+		"Muse/Starlight": {Text: "Far away", Link: "https://example.com/starlight"},
+		"Muse/Uprising":  {Text: "Paranoia", Link: "https://example.com/uprising"},
+	}}
+}
+
+func newTestCache(next Fetcher, store CacheStore, cfg config.Cache) (*Cache, *time.Time) {
+	c := NewCache(next, store, cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+var cacheConfig = config.Cache{TTL: time.Hour, NegativeTTL: time.Minute, MaxEntries: 100}
+
+func TestCacheCoalesces(t *testing.T) {
+	stub := newStub()
+	stub.release = make(chan struct{})
+	c, _ := newTestCache(stub, nil, cacheConfig)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			song, err := c.GetMusicInfo(context.Background(), "Muse", "Starlight")
+			if err == nil && song.Text != "Far away" {
+				err = fmt.Errorf("got %+v", song)
+			}
+			errs <- err
+		}()
+	}
+	for stub.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// give the rest time to join the call in flight
+	time.Sleep(20 * time.Millisecond)
+	close(stub.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GetMusicInfo: %v", err)
+		}
+	}
+	if n := stub.calls.Load(); n != 1 {
+		t.Errorf("upstream got %d calls for 10 concurrent lookups, want 1", n)
+	}
+}
+
+// TestCacheCallerGivesUp checks that a caller going away neither cancels
+// the shared call nor keeps its result from the cache.
+func TestCacheCallerGivesUp(t *testing.T) {
+	stub := newStub()
+	stub.release = make(chan struct{})
+	c, _ := newTestCache(stub, nil, cacheConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := c.GetMusicInfo(ctx, "Muse", "Starlight")
+		done <- err
+	}()
+	for stub.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled lookup = %v, want context.Canceled", err)
+	}
+
+	close(stub.release)
+	for {
+		if _, ok := c.get(cacheKey("Muse", "Starlight")); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.GetMusicInfo(context.Background(), "Muse", "Starlight"); err != nil || stub.calls.Load() != 1 {
+		t.Errorf("lookup after the cancelled one = %v with %d upstream calls, want a hit", err, stub.calls.Load())
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	stub := newStub()
+	c, now := newTestCache(stub, nil, cacheConfig)
+	ctx := context.Background()
+
+	lookups := []struct {
+		after       time.Duration
+		group, song string
+		wantErr     error
+		calls       int32
+	}{
+		{0, "Muse", "Starlight", nil, 1},
+		{0, "Nobody", "Nothing", ErrNotFound, 2},
+		// both cached
+		{30 * time.Second, "Muse", "Starlight", nil, 2},
+		{0, "Nobody", "Nothing", ErrNotFound, 2},
+		// the 404 expires after NegativeTTL, the song after TTL
+		{30 * time.Second, "Nobody", "Nothing", ErrNotFound, 3},
+		{0, "Muse", "Starlight", nil, 3},
+		{time.Hour, "Muse", "Starlight", nil, 4},
+	}
+	for i, l := range lookups {
+		*now = now.Add(l.after)
+		if _, err := c.GetMusicInfo(ctx, l.group, l.song); !errors.Is(err, l.wantErr) {
+			t.Errorf("lookup %d of %s = %v, want %v", i+1, l.song, err, l.wantErr)
+		}
+		if n := stub.calls.Load(); n != l.calls {
+			t.Errorf("after lookup %d upstream got %d calls, want %d", i+1, n, l.calls)
+		}
+	}
+
+	// failures other than 404 aren't cached
+	stub.err = errors.New("upstream down")
+	if _, err := c.GetMusicInfo(ctx, "Muse", "Uprising"); err == nil {
+		t.Fatalf("lookup with the upstream down succeeded")
+	}
+	stub.err = nil
+	if _, err := c.GetMusicInfo(ctx, "Muse", "Uprising"); err != nil || stub.calls.Load() != 6 {
+		t.Errorf("lookup once the upstream is back = %v after %d calls, want it fetched again", err, stub.calls.Load())
+	}
+}
+
+func TestCacheNoCache(t *testing.T) {
+	stub := newStub()
+	c, _ := newTestCache(stub, nil, cacheConfig)
+	ctx := context.Background()
+
+	c.GetMusicInfo(ctx, "Muse", "Starlight")
+	stub.songs["Muse/Starlight"] = models.Song{Text: "Far away, remastered"}
+	song, err := c.GetMusicInfo(NoCache(ctx), "Muse", "Starlight")
+	if err != nil || song.Text != "Far away, remastered" || stub.calls.Load() != 2 {
+		t.Errorf("NoCache lookup = %+v, %v; want it fetched again", song, err)
+	}
+	if song, _ := c.GetMusicInfo(ctx, "Muse", "Starlight"); song.Text != "Far away, remastered" {
+		t.Errorf("lookup after NoCache = %+v, want the refetched info cached", song)
+	}
+}
+
+func TestCachePersistent(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemory()
+	stub := newStub()
+	first, now := newTestCache(stub, store, cacheConfig)
+
+	if _, err := first.GetMusicInfo(ctx, "Muse", "Starlight"); err != nil {
+		t.Fatalf("GetMusicInfo: %v", err)
+	}
+	first.GetMusicInfo(ctx, "Nobody", "Nothing")
+	entry, ok, err := store.GetCachedInfo(ctx, cacheKey("Muse", "Starlight"))
+	if err != nil || !ok || entry.Info.Text != "Far away" || !entry.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("stored entry = %+v, %v, %v; want the info until the TTL ends", entry, ok, err)
+	}
+
+	// a second instance, e.g. after a restart, reads the store first
+	second, _ := newTestCache(stub, store, cacheConfig)
+	song, err := second.GetMusicInfo(ctx, "Muse", "Starlight")
+	if err != nil || song.Text != "Far away" || song.Group != "Muse" {
+		t.Errorf("lookup from the store = %+v, %v", song, err)
+	}
+	if _, err := second.GetMusicInfo(ctx, "Nobody", "Nothing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("stored 404 = %v, want ErrNotFound", err)
+	}
+	if n := stub.calls.Load(); n != 2 {
+		t.Errorf("upstream got %d calls, want only the first instance's 2", n)
+	}
+
+	// prune drops what expired from memory and the store
+	*now = now.Add(2 * time.Minute)
+	first.prune(ctx)
+	if _, ok, _ := store.GetCachedInfo(ctx, cacheKey("Nobody", "Nothing")); ok {
+		t.Errorf("expired 404 is still stored after prune")
+	}
+	if _, ok := first.entries[cacheKey("Nobody", "Nothing")]; ok {
+		t.Errorf("expired 404 is still in memory after prune")
+	}
+	if _, ok, _ := store.GetCachedInfo(ctx, cacheKey("Muse", "Starlight")); !ok {
+		t.Errorf("prune dropped an entry that hasn't expired")
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	stub := &stubFetcher{songs: map[string]models.Song{}}
+	for i := range 50 {
+		stub.songs[fmt.Sprintf("Group/%d", i)] = models.Song{Text: "la"}
+	}
+	cfg := cacheConfig
+	cfg.MaxEntries = 20
+	c, now := newTestCache(stub, nil, cfg)
+	ctx := context.Background()
+
+	for i := range 50 {
+		c.GetMusicInfo(ctx, "Group", fmt.Sprint(i))
+		if n := len(c.entries); n > cfg.MaxEntries {
+			t.Fatalf("cache holds %d entries, over its %d", n, cfg.MaxEntries)
+		}
+	}
+
+	// expired entries go before live ones
+	*now = now.Add(2 * time.Hour)
+	c.GetMusicInfo(ctx, "Group", "0")
+	if n := len(c.entries); n != 1 {
+		t.Errorf("cache holds %d entries after the rest expired, want only the new one", n)
+	}
+}
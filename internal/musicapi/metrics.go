@@ -0,0 +1,55 @@
+package musicapi
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	outcomeSuccess      = "success"
+	outcomeNotFound     = "not_found"
+	outcomeClientError  = "client_error"
+	outcomeServerError  = "server_error"
+	outcomeTimeout      = "timeout"
+	outcomeNetworkError = "network_error"
+	outcomeBadResponse  = "bad_response"
+	outcomeCircuitOpen  = "circuit_open"
+	outcomeCanceled     = "canceled"
+	outcomeRetry        = "retry"
+	outcomeError        = "error"
+)
+
+// Every label value is one of the outcome constants or cache events, so the
+// series stay bounded.
+var (
+	calls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "musicapi_calls_total",
+		Help: "Music info calls and retries by outcome.",
+	}, []string{"outcome"})
+
+	attemptDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "musicapi_attempt_duration_seconds",
+		Help:    "Duration of single requests to the music info service by outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "musicapi_breaker_state",
+		Help: "Circuit breaker state: 0 closed, 1 open, 2 half-open.",
+	})
+
+	cacheEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "musicapi_cache_events_total",
+		Help: "Metadata cache lookups by event: hits, negative_hits, persistent_hits, misses, coalesced, store_errors.",
+	}, []string{"event"})
+)
+
+func record(outcome string) {
+	calls.WithLabelValues(outcome).Inc()
+}
+
+func observeAttempt(outcome string, d time.Duration) {
+	attemptDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
@@ -1,43 +1,250 @@
 package musicapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"time"
 
+	"music-library/internal/config"
+	"music-library/internal/logging"
 	"music-library/internal/models"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const maxBodySize = 1 << 20
+
+var (
+	ErrNotFound    = errors.New("song info not found")
+	ErrCircuitOpen = errors.New("music info service unavailable")
 )
 
-func GetMusicInfo(group_name, song_name string) (models.Song, error) {
+type Fetcher interface {
+	GetMusicInfo(ctx context.Context, groupName, songName string) (models.Song, error)
+}
+
+type Client struct {
+	cfg     config.MusicAPI
+	http    *http.Client
+	breaker *breaker
+}
+
+func New(cfg config.MusicAPI) *Client {
+	return &Client{
+		cfg:     cfg,
+		http:    &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		breaker: newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+// upstreamError is a failed attempt that may be worth repeating.
+type upstreamError struct {
+	outcome   string
+	retryable bool
+	err       error
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+func (e *upstreamError) Unwrap() error { return e.err }
+
+var tracer = otel.Tracer("music-library/internal/musicapi")
+
+// GetMusicInfo spans the whole lookup, retries included; each request gets a
+// child span from the transport, which also sends the W3C trace context.
+func (c *Client) GetMusicInfo(ctx context.Context, groupName, songName string) (models.Song, error) {
+	ctx, span := tracer.Start(ctx, "musicapi.GetMusicInfo", trace.WithAttributes(
+		attribute.String("music.group", groupName),
+		attribute.String("music.song", songName)))
+	defer span.End()
+
+	song, err := c.getMusicInfo(ctx, groupName, songName)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return song, err
+}
+
+func (c *Client) getMusicInfo(ctx context.Context, groupName, songName string) (models.Song, error) {
 	song := models.Song{
-		Group: group_name,
-		Song:  song_name,
+		Group: groupName,
+		Song:  songName,
 	}
-	query := fmt.Sprintf("%s?group=%s&song=%s", os.Getenv("EXTERNAL_API_URL"), url.QueryEscape(group_name), url.QueryEscape(song_name))
 
-	resp, err := http.Get(query)
+	if !c.breaker.allow() {
+		record(outcomeCircuitOpen)
+		return song, ErrCircuitOpen
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		info, err := c.fetch(ctx, groupName, songName)
+		if err == nil {
+			observeAttempt(outcomeSuccess, time.Since(start))
+			record(outcomeSuccess)
+			c.breaker.success()
+			return info, nil
+		}
+
+		var uerr *upstreamError
+		if !errors.As(err, &uerr) {
+			observeAttempt(outcomeError, time.Since(start))
+			record(outcomeError)
+			c.breaker.failure()
+			return song, err
+		}
+		observeAttempt(uerr.outcome, time.Since(start))
+		record(uerr.outcome)
+
+		switch {
+		case uerr.outcome == outcomeCanceled:
+			c.breaker.release()
+			return song, err
+		case !uerr.retryable && uerr.outcome != outcomeBadResponse:
+			// the upstream answered sensibly, it's just not what we wanted
+			c.breaker.success()
+			return song, err
+		case !uerr.retryable || attempt >= c.cfg.MaxRetries:
+			c.breaker.failure()
+			return song, err
+		}
+
+		delay := c.backoff(attempt)
+		logging.FromContext(ctx).DebugContext(ctx, "Retrying music info request", "attempt", attempt+1, "delay", delay, "error", err)
+		record(outcomeRetry)
+
+		select {
+		case <-ctx.Done():
+			record(outcomeCanceled)
+			c.breaker.release()
+			return song, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) fetch(ctx context.Context, groupName, songName string) (models.Song, error) {
+	song := models.Song{
+		Group: groupName,
+		Song:  songName,
+	}
+
+	u, err := url.Parse(c.cfg.URL)
 	if err != nil {
-		return song, err
+		return song, fmt.Errorf("invalid music info url: %w", err)
 	}
+	q := u.Query()
+	q.Set("group", groupName)
+	q.Set("song", songName)
+	u.RawQuery = q.Encode()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil || body == nil {
-		return song, err
+	reqCtx := ctx
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
 	}
 
-	if err := json.Unmarshal(body, &song); err != nil {
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
 		return song, err
 	}
+	req.Header.Set("Accept", "application/json")
 
-	if err := resp.Body.Close(); err != nil {
-		return song, err
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return song, classifyTransportError(ctx, err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return song, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+		return song, classifyStatus(resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&song); err != nil {
+		if reqCtx.Err() != nil {
+			return song, classifyTransportError(ctx, err)
+		}
+		return song, &upstreamError{outcome: outcomeBadResponse, err: fmt.Errorf("decode music info: %w", err)}
 	}
+	song.Group = groupName
+	song.Song = songName
+
 	return song, nil
 }
+
+// Ping checks the upstream answers at all. Any response below 500 counts,
+// the info endpoint rejects a request without a song anyway. Pings don't
+// move the circuit breaker.
+func (c *Client) Ping(ctx context.Context) error {
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.cfg.BaseBackoff << attempt
+	if d <= 0 || d > c.cfg.MaxBackoff {
+		d = c.cfg.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
+
+func classifyStatus(code int) error {
+	err := fmt.Errorf("unexpected status code: %d", code)
+	switch {
+	case code == http.StatusNotFound:
+		return &upstreamError{outcome: outcomeNotFound, err: ErrNotFound}
+	case code == http.StatusTooManyRequests || code >= 500:
+		return &upstreamError{outcome: outcomeServerError, retryable: true, err: err}
+	default:
+		return &upstreamError{outcome: outcomeClientError, err: err}
+	}
+}
+
+// classifyTransportError tells a caller that gave up (ctx is the caller's
+// context, not the per-attempt one) apart from an upstream that is too slow.
+func classifyTransportError(ctx context.Context, err error) error {
+	var nerr net.Error
+	switch {
+	case ctx.Err() != nil:
+		return &upstreamError{outcome: outcomeCanceled, err: err}
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout()):
+		return &upstreamError{outcome: outcomeTimeout, retryable: true, err: err}
+	default:
+		return &upstreamError{outcome: outcomeNetworkError, retryable: true, err: err}
+	}
+}
@@ -0,0 +1,228 @@
+package musicapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/fakeinfo"
+)
+
+// upstream is the fake music info service counting the requests it gets.
+type upstream struct {
+	*fakeinfo.Server
+	url      string
+	requests atomic.Int32
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+	u := &upstream{Server: fakeinfo.New(fakeinfo.DefaultFixtures(), fakeinfo.Options{})}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		u.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	u.url = srv.URL + "/info"
+	return u
+}
+
+func clientConfig(url string) config.MusicAPI {
+	cfg := config.Default().MusicAPI
+	cfg.URL = url
+	cfg.Timeout = time.Second
+	cfg.MaxRetries = 2
+	cfg.BaseBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	cfg.BreakerThreshold = 0
+	return cfg
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        fakeinfo.Options
+		group, song string
+		wantErr     error
+		attempts    int32
+	}{
+		{name: "found", group: "Muse", song: "Starlight", attempts: 1},
+		{name: "not found", group: "Nobody", song: "Nothing", wantErr: ErrNotFound, attempts: 1},
+		{name: "bad request", group: "", song: "Starlight", attempts: 1},
+		{name: "server error", opts: fakeinfo.Options{ErrorRate: 1}, group: "Muse", song: "Starlight", attempts: 3},
+		{name: "timeout", opts: fakeinfo.Options{Latency: time.Second}, group: "Muse", song: "Starlight", attempts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUpstream(t)
+			u.SetOptions(tt.opts)
+			cfg := clientConfig(u.url)
+			cfg.Timeout = 50 * time.Millisecond
+			c := New(cfg)
+
+			song, err := c.GetMusicInfo(context.Background(), tt.group, tt.song)
+			switch {
+			case tt.name == "found":
+				if err != nil || song.Text == "" || song.Group != "Muse" {
+					t.Errorf("GetMusicInfo = %+v, %v; want the fixture", song, err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("GetMusicInfo error = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err == nil {
+					t.Errorf("GetMusicInfo succeeded, want an error")
+				}
+			}
+			if n := u.requests.Load(); n != tt.attempts {
+				t.Errorf("upstream got %d requests, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+// breakerClient gives up after one attempt and opens its breaker after two
+// failed calls for a minute of the returned clock.
+func breakerClient(t *testing.T) (*Client, *upstream, *time.Time) {
+	t.Helper()
+	u := newUpstream(t)
+	cfg := clientConfig(u.url)
+	cfg.MaxRetries = 0
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = time.Minute
+	c := New(cfg)
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+	return c, u, &now
+}
+
+func lookup(c *Client) error {
+	_, err := c.GetMusicInfo(context.Background(), "Muse", "Starlight")
+	return err
+}
+
+func TestBreaker(t *testing.T) {
+	c, u, now := breakerClient(t)
+
+	// answers the upstream means, 404s included, don't count as failures
+	for range 3 {
+		if _, err := c.GetMusicInfo(context.Background(), "Nobody", "Nothing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetMusicInfo of an unknown song = %v, want ErrNotFound", err)
+		}
+	}
+	if c.breaker.state != stateClosed {
+		t.Fatalf("breaker is %s after 404s, want closed", c.breaker.state)
+	}
+
+	u.SetOptions(fakeinfo.Options{ErrorRate: 1})
+	for i := range 2 {
+		if err := lookup(c); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("failing call %d = %v, want the upstream's error", i+1, err)
+		}
+	}
+	if c.breaker.state != stateOpen {
+		t.Fatalf("breaker is %s after 2 failures, want open", c.breaker.state)
+	}
+	before := u.requests.Load()
+	if err := lookup(c); !errors.Is(err, ErrCircuitOpen) || u.requests.Load() != before {
+		t.Errorf("call on the open breaker = %v, want ErrCircuitOpen without a request", err)
+	}
+
+	// a failed probe opens the breaker again at once
+	*now = now.Add(time.Minute)
+	if err := lookup(c); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("probe = %v, want the upstream's error", err)
+	}
+	if c.breaker.state != stateOpen || !errors.Is(lookup(c), ErrCircuitOpen) {
+		t.Errorf("breaker is %s after a failed probe, want open", c.breaker.state)
+	}
+
+	// a good probe closes it
+	*now = now.Add(time.Minute)
+	u.SetOptions(fakeinfo.Options{})
+	if err := lookup(c); err != nil {
+		t.Errorf("probe = %v, want success", err)
+	}
+	if c.breaker.state != stateClosed || c.breaker.failures != 0 {
+		t.Errorf("breaker is %s with %d failures after a good probe, want closed", c.breaker.state, c.breaker.failures)
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	if b.allow() {
+		t.Fatalf("open breaker let a call through")
+	}
+	now = now.Add(time.Minute)
+	if !b.allow() || b.state != stateHalfOpen {
+		t.Fatalf("breaker after the cooldown is %s, want a half-open probe", b.state)
+	}
+	if b.allow() {
+		t.Errorf("half-open breaker let a second call through during the probe")
+	}
+	b.release()
+	if !b.allow() {
+		t.Errorf("released probe slot wasn't handed out again")
+	}
+}
+
+// TestBreakerCancelledProbe checks that a caller giving up mid-probe leaves
+// the slot for the next one instead of keeping the breaker half-open with
+// nobody probing.
+func TestBreakerCancelledProbe(t *testing.T) {
+	c, u, now := breakerClient(t)
+	u.SetOptions(fakeinfo.Options{ErrorRate: 1})
+	lookup(c)
+	lookup(c)
+
+	*now = now.Add(time.Minute)
+	u.SetOptions(fakeinfo.Options{Latency: 10 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetMusicInfo(ctx, "Muse", "Starlight"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("cancelled probe = %v, want its context's error", err)
+	}
+	if c.breaker.state != stateHalfOpen || c.breaker.probing {
+		t.Fatalf("breaker after a cancelled probe is %s, probing %v; want half-open with the slot free", c.breaker.state, c.breaker.probing)
+	}
+
+	u.SetOptions(fakeinfo.Options{})
+	if err := lookup(c); err != nil {
+		t.Errorf("next probe = %v, want success", err)
+	}
+	if c.breaker.state != stateClosed {
+		t.Errorf("breaker is %s, want closed", c.breaker.state)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New(config.MusicAPI{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+
+	for _, attempt := range []int{0, 1, 2, 3, 4, 10, 40, 63} {
+		limit := min(100*time.Millisecond<<attempt, time.Second)
+		if attempt >= 10 {
+			// shifted past the cap, or out of range altogether
+			limit = time.Second
+		}
+		for range 200 {
+			if d := c.backoff(attempt); d < 0 || d >= limit {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v)", attempt, d, limit)
+			}
+		}
+	}
+
+	if d := New(config.MusicAPI{}).backoff(3); d != 0 {
+		t.Errorf("backoff without a base or cap = %v, want 0", d)
+	}
+}
@@ -0,0 +1,68 @@
+package outbox
+
+import (
+	"strings"
+	"sync"
+)
+
+// Bus is an in-process message bus with NATS subject matching: subjects are
+// dot separated tokens, "*" in a subscription matches one token and a
+// trailing ">" the rest. It lets the bus sink run without a NATS server.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[int]subscription
+	lastId int
+}
+
+type subscription struct {
+	tokens  []string
+	handler func(subject string, data []byte)
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]subscription)}
+}
+
+// Publish calls the handler of every matching subscription. Handlers run on
+// the publisher's goroutine and must not block.
+func (b *Bus) Publish(subject string, data []byte) error {
+	tokens := strings.Split(subject, ".")
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs {
+		if matchSubject(sub.tokens, tokens) {
+			sub.handler(subject, data)
+		}
+	}
+	return nil
+}
+
+// Subscribe calls handler with the messages published on subjects matching
+// subject until the returned func is called.
+func (b *Bus) Subscribe(subject string, handler func(subject string, data []byte)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	id := b.lastId
+	b.subs[id] = subscription{tokens: strings.Split(subject, "."), handler: handler}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+func matchSubject(pattern, subject []string) bool {
+	for i, token := range pattern {
+		if token == ">" {
+			return len(subject) > i
+		}
+		if i >= len(subject) || (token != "*" && token != subject[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(subject)
+}
@@ -0,0 +1,75 @@
+// Package outbox publishes song changes reliably. Events are appended to
+// the outbox table through the transaction making the change, so a rolled
+// back change is never announced and a committed one is never lost, and the
+// Relay hands them to every sink in order afterwards.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/models"
+)
+
+const (
+	SongCreated = "song.created"
+	SongUpdated = "song.updated"
+	SongDeleted = "song.deleted"
+)
+
+// Events are the types published.
+var Events = []string{SongCreated, SongUpdated, SongDeleted}
+
+var songEvents = map[string]string{
+	audit.Create: SongCreated,
+	audit.Update: SongUpdated,
+	audit.Delete: SongDeleted,
+}
+
+// Writer stores events. Every database.Service is one; pass the one a
+// WithTx func gets.
+type Writer interface {
+	AppendOutbox(ctx context.Context, event models.Event) error
+}
+
+// SongChange is the data of song events.
+type SongChange struct {
+	Song models.Song `json:"song"`
+	// Previous is the song before an update.
+	Previous *models.Song `json:"previous,omitempty"`
+}
+
+// SongChanged appends the event for an audit action on a song. before is
+// nil for creates and after for deletes.
+func SongChanged(ctx context.Context, w Writer, action string, before, after *models.Song) error {
+	change := SongChange{Previous: before}
+	switch {
+	case after != nil:
+		change.Song = *after
+	case before != nil:
+		change.Song, change.Previous = *before, nil
+	}
+	return Append(ctx, w, songEvents[action], change)
+}
+
+// Append appends an event of type typ carrying data.
+func Append(ctx context.Context, w Writer, typ string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	return w.AppendOutbox(ctx, models.Event{
+		Id:        hex.EncodeToString(b),
+		Type:      typ,
+		CreatedAt: time.Now().UTC(),
+		Data:      raw,
+	})
+}
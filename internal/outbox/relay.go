@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// pruneInterval is how often relayed events older than the retention are
+// deleted.
+const pruneInterval = time.Hour
+
+var (
+	published = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_published_total",
+		Help: "Outbox events published by sink.",
+	}, []string{"sink"})
+
+	publishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_publish_errors_total",
+		Help: "Failed outbox batches by sink.",
+	}, []string{"sink"})
+)
+
+// Relay numbers committed events and publishes them to each sink from the
+// sink's own position, so a failing sink holds back only itself. Several
+// instances can relay the same outbox; a sink's batch is published by one
+// of them at a time.
+type Relay struct {
+	db        database.Service
+	sinks     []Sink
+	interval  time.Duration
+	batch     int
+	retention time.Duration
+	// sequenced are told when events got their sequence numbers.
+	sequenced []func()
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRelay(db database.Service, cfg config.Outbox, sinks ...Sink) *Relay {
+	return &Relay{
+		db:        db,
+		sinks:     sinks,
+		interval:  cfg.PollInterval,
+		batch:     cfg.BatchSize,
+		retention: cfg.Retention,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (r *Relay) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go r.run(ctx)
+	slog.Info("Outbox relay started", "sinks", len(r.sinks))
+}
+
+// Stop ends the relay and closes the sinks that need it. Events not yet
+// published stay in the outbox for the next start.
+func (r *Relay) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+	for _, sink := range r.sinks {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Error("Can't close outbox sink", "sink", sink.Name(), "error", err)
+			}
+		}
+	}
+	slog.Info("Outbox relay stopped")
+}
+
+// OnSequenced has notify called whenever the relay numbered new events.
+// Call it before Start.
+func (r *Relay) OnSequenced(notify func()) {
+	r.sequenced = append(r.sequenced, notify)
+}
+
+// Notify wakes the relay so an event committed just now doesn't wait for
+// the next poll.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	var pruned time.Time
+	for ctx.Err() == nil {
+		r.relay(ctx)
+		if time.Since(pruned) >= pruneInterval {
+			r.prune(ctx)
+			pruned = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-r.wake:
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+func (r *Relay) relay(ctx context.Context) {
+	n, err := r.db.SequenceOutbox(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Can't number outbox events", "error", err)
+		}
+		return
+	}
+	if n > 0 {
+		for _, notify := range r.sequenced {
+			notify()
+		}
+	}
+
+	for _, sink := range r.sinks {
+		for ctx.Err() == nil {
+			n, err := r.publish(ctx, sink)
+			if err != nil {
+				publishErrors.WithLabelValues(sink.Name()).Inc()
+				if ctx.Err() == nil {
+					slog.Warn("Can't publish outbox events", "sink", sink.Name(), "error", err)
+				}
+				break
+			}
+			if n < r.batch {
+				break
+			}
+		}
+	}
+}
+
+// publish hands the next batch to sink and reports its size.
+func (r *Relay) publish(ctx context.Context, sink Sink) (int, error) {
+	var events []models.Event
+	err := r.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		if events, err = tx.ClaimOutbox(ctx, sink.Name(), r.batch); err != nil || len(events) == 0 {
+			return err
+		}
+		if err := sink.Publish(ctx, tx, events); err != nil {
+			return err
+		}
+		return tx.AckOutbox(ctx, sink.Name(), events[len(events)-1].Seq)
+	})
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	published.WithLabelValues(sink.Name()).Add(float64(len(events)))
+	if c, ok := sink.(committer); ok {
+		c.Committed()
+	}
+	return len(events), nil
+}
+
+func (r *Relay) prune(ctx context.Context) {
+	names := make([]string, len(r.sinks))
+	for i, sink := range r.sinks {
+		names[i] = sink.Name()
+	}
+	// cursors left by sinks no longer configured would hold back pruning
+	// forever, so only the relay's own count
+	n, err := r.db.PruneOutbox(ctx, time.Now().Add(-r.retention), names)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Can't prune outbox", "error", err)
+		}
+		return
+	}
+	if n > 0 {
+		slog.Info("Pruned outbox", "events", n)
+	}
+}
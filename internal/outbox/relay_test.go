@@ -0,0 +1,213 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/models"
+)
+
+// recordingSink keeps what it is sent, refusing the first failures batches.
+type recordingSink struct {
+	name     string
+	failures int
+
+	mu     sync.Mutex
+	events []models.Event
+	closed bool
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Publish(_ context.Context, _ database.Service, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink is down")
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// ids are the ids of the events received, in order.
+func (s *recordingSink) ids() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for i, event := range s.events {
+		if event.Seq != int64(i+1) {
+			return append(ids, fmt.Sprintf("seq %d at %d", event.Seq, i))
+		}
+		ids = append(ids, event.Id)
+	}
+	return ids
+}
+
+func newRelay(db database.Service, sinks ...Sink) *Relay {
+	cfg := config.Default().Outbox
+	cfg.BatchSize = 2
+	return NewRelay(db, cfg, sinks...)
+}
+
+// commit appends an event with each id in a transaction of its own.
+func commit(t *testing.T, db database.Service, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		err := db.WithTx(context.Background(), func(tx database.Service) error {
+			return tx.AppendOutbox(context.Background(), models.Event{Id: id, Type: SongCreated, CreatedAt: time.Now(), Data: []byte("{}")})
+		})
+		if err != nil {
+			t.Fatalf("AppendOutbox(%s): %v", id, err)
+		}
+	}
+}
+
+func TestRelayOrder(t *testing.T) {
+	db := database.NewMemory()
+	sink := &recordingSink{name: "a"}
+	r := newRelay(db, sink)
+	ctx := context.Background()
+
+	commit(t, db, "e1", "e2")
+	rollback := errors.New("rollback")
+	err := db.WithTx(ctx, func(tx database.Service) error {
+		if err := tx.AppendOutbox(ctx, models.Event{Id: "lost", Type: SongCreated, CreatedAt: time.Now()}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithTx = %v, want the rollback", err)
+	}
+	commit(t, db, "e3", "e4", "e5")
+
+	// five events go out in batches of two in one pass
+	r.relay(ctx)
+	if got := sink.ids(); !slices.Equal(got, []string{"e1", "e2", "e3", "e4", "e5"}) {
+		t.Errorf("sink got %v, want the committed events in order", got)
+	}
+
+	commit(t, db, "e6")
+	r.relay(ctx)
+	r.relay(ctx)
+	if got := sink.ids(); !slices.Equal(got, []string{"e1", "e2", "e3", "e4", "e5", "e6"}) {
+		t.Errorf("sink got %v after another event, want each event once", got)
+	}
+}
+
+func TestRelayFailingSink(t *testing.T) {
+	db := database.NewMemory()
+	good, bad := &recordingSink{name: "good"}, &recordingSink{name: "bad", failures: 1}
+	r := newRelay(db, bad, good)
+	ctx := context.Background()
+
+	commit(t, db, "e1", "e2", "e3")
+	r.relay(ctx)
+	if got := good.ids(); !slices.Equal(got, []string{"e1", "e2", "e3"}) {
+		t.Errorf("good sink got %v while the other failed, want all", got)
+	}
+	if got := bad.ids(); len(got) != 0 {
+		t.Errorf("failing sink got %v", got)
+	}
+
+	// the failed sink catches up from its own position
+	commit(t, db, "e4")
+	r.relay(ctx)
+	if got := bad.ids(); !slices.Equal(got, []string{"e1", "e2", "e3", "e4"}) {
+		t.Errorf("recovered sink got %v, want all from the start", got)
+	}
+	if got := good.ids(); !slices.Equal(got, []string{"e1", "e2", "e3", "e4"}) {
+		t.Errorf("good sink got %v, want only the new event again", got)
+	}
+}
+
+func TestRelayPrune(t *testing.T) {
+	db := database.NewMemory()
+	ctx := context.Background()
+	a, b := &recordingSink{name: "a"}, &recordingSink{name: "b", failures: 1}
+
+	// a sink of an earlier configuration left its cursor at the start
+	commit(t, db, "e1", "e2", "e3")
+	newRelay(db, &recordingSink{name: "gone", failures: 1}).relay(ctx)
+
+	r := newRelay(db, a, b)
+	r.retention = -time.Minute
+	remaining := func() []string {
+		t.Helper()
+		events, err := db.ListOutbox(ctx, 0, 10)
+		if err != nil {
+			t.Fatalf("ListOutbox: %v", err)
+		}
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.Id)
+		}
+		return ids
+	}
+
+	r.relay(ctx)
+	r.prune(ctx)
+	if got := remaining(); !slices.Equal(got, []string{"e1", "e2", "e3"}) {
+		t.Errorf("outbox holds %v, want all while b hasn't had any", got)
+	}
+
+	r.relay(ctx)
+	r.prune(ctx)
+	if got := remaining(); !slices.Equal(got, []string{"e3"}) {
+		t.Errorf("outbox holds %v, want only the newest once a and b had all", got)
+	}
+
+	// events within the retention stay
+	commit(t, db, "e4")
+	r.relay(ctx)
+	r.retention = time.Hour
+	r.prune(ctx)
+	if got := remaining(); !slices.Equal(got, []string{"e3", "e4"}) {
+		t.Errorf("outbox holds %v, want the recent events kept", got)
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	db := database.NewMemory()
+	sink := &recordingSink{name: "a"}
+	r := newRelay(db, sink)
+	r.interval = time.Hour
+	sequenced := make(chan struct{}, 10)
+	r.OnSequenced(func() { sequenced <- struct{}{} })
+	r.Start()
+
+	commit(t, db, "e1")
+	r.Notify()
+	select {
+	case <-sequenced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay didn't number the event it was notified of")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.ids()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	r.Stop()
+
+	if got := sink.ids(); !slices.Equal(got, []string{"e1"}) {
+		t.Errorf("sink got %v, want e1", got)
+	}
+	if !sink.closed {
+		t.Errorf("Stop didn't close the sink")
+	}
+}
@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"music-library/internal/database"
+	"music-library/internal/models"
+)
+
+// Sink receives every event once its sequence number is set, in order. A
+// batch that fails is offered again, so events may arrive more than once;
+// consumers dedupe on Seq.
+type Sink interface {
+	// Name keys the sink's position in the outbox; renaming a sink starts
+	// it over from the oldest event kept.
+	Name() string
+	// Publish sends events. tx is the transaction that moves the sink's
+	// position past them; sinks storing events in the database write
+	// through it, so they get each event exactly once.
+	Publish(ctx context.Context, tx database.Service, events []models.Event) error
+}
+
+// committer is a sink that acts once a batch it published is committed.
+type committer interface {
+	Committed()
+}
+
+// WriterSink writes events as JSON lines.
+type WriterSink struct {
+	name string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+// NewFileSink appends events to the file at path.
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink("file", f), nil
+}
+
+func (s *WriterSink) Name() string { return s.name }
+
+func (s *WriterSink) Publish(_ context.Context, _ database.Service, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := bufio.NewWriter(s.w)
+	enc := json.NewEncoder(w)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.w.(*os.File); ok && f != os.Stdout {
+		return f.Sync()
+	}
+	return nil
+}
+
+// Close closes the file of a file sink.
+func (s *WriterSink) Close() error {
+	if f, ok := s.w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// Publisher sends a message on a subject. A *nats.Conn is one, and Bus is
+// an in-process stand-in.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// PublisherSink publishes each event as JSON on prefix.<type>, e.g.
+// music-library.song.created.
+type PublisherSink struct {
+	publisher Publisher
+	prefix    string
+}
+
+func NewPublisherSink(publisher Publisher, prefix string) *PublisherSink {
+	return &PublisherSink{publisher: publisher, prefix: prefix}
+}
+
+func (s *PublisherSink) Name() string { return "bus" }
+
+func (s *PublisherSink) Publish(_ context.Context, _ database.Service, events []models.Event) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := s.publisher.Publish(s.prefix+"."+event.Type, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
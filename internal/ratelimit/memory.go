@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps the buckets of a single instance.
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]bucket),
+		now:     time.Now,
+	}
+}
+
+func (m *memoryStore) TakeToken(ctx context.Context, key string, perSecond float64, burst int) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = bucket{tokens: float64(burst), updated: now}
+	}
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	m.buckets[key] = b
+	return b.tokens, allowed, nil
+}
+
+func (m *memoryStore) PruneTokenBuckets(ctx context.Context, idle time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, b := range m.buckets {
+		if m.now().Sub(b.updated) > idle {
+			delete(m.buckets, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Package ratelimit throttles clients with token buckets, one per client and
+// route class. Buckets live in memory, or in Postgres when several instances
+// have to share them.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/models"
+)
+
+// Route classes, each limited separately.
+const (
+	Read  = "read"
+	Write = "write"
+	// Enrich is for routes that make calls to the music info service.
+	Enrich = "enrich"
+	// SignIn is taken per client IP by every request with credentials,
+	// before they are checked, bounding how fast keys can be guessed.
+	SignIn = "signin"
+)
+
+// Classes are the route classes, whose limits API keys can override.
+var Classes = []string{Read, Write, Enrich}
+
+// pruneInterval is how often buckets that are full again get dropped.
+const pruneInterval = time.Minute
+
+// Store keeps the buckets. database.TokenBuckets is one.
+type Store interface {
+	TakeToken(ctx context.Context, key string, perSecond float64, burst int) (tokens float64, allowed bool, err error)
+	PruneTokenBuckets(ctx context.Context, idle time.Duration) error
+}
+
+// Result describes the bucket after a request, for the RateLimit headers.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the bucket will be full again, RetryAfter when the
+	// next request will be let through.
+	Reset      time.Duration
+	RetryAfter time.Duration
+	// Window is how long an empty bucket takes to fill.
+	Window time.Duration
+}
+
+type Limiter struct {
+	store  Store
+	limits map[string]models.RateLimit
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(store Store, cfg config.RateLimit) *Limiter {
+	return &Limiter{
+		store: store,
+		limits: map[string]models.RateLimit{
+			Read:   {PerMinute: cfg.ReadPerMinute, Burst: cfg.ReadBurst},
+			Write:  {PerMinute: cfg.WritePerMinute, Burst: cfg.WriteBurst},
+			Enrich: {PerMinute: cfg.EnrichPerMinute, Burst: cfg.EnrichBurst},
+			SignIn: {PerMinute: cfg.SignInPerMinute, Burst: cfg.SignInBurst},
+		},
+	}
+}
+
+// Allow takes a token from the client's bucket for class. overrides, the
+// client's own limits if it has any, replace the configured ones.
+func (l *Limiter) Allow(ctx context.Context, client, class string, overrides map[string]models.RateLimit) (Result, error) {
+	limit := l.limits[class]
+	if override, ok := overrides[class]; ok && override.PerMinute > 0 && override.Burst > 0 {
+		limit = override
+	}
+	perSecond := float64(limit.PerMinute) / 60
+
+	tokens, allowed, err := l.store.TakeToken(ctx, class+":"+client, perSecond, limit.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Burst) - tokens) / perSecond),
+		Window:    seconds(float64(limit.Burst) / perSecond),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+	return res, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// Start drops idle buckets in the background until Stop.
+func (l *Limiter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+
+	l.wg.Add(1)
+	go l.run(ctx)
+}
+
+func (l *Limiter) Stop() {
+	if l.cancel == nil {
+		return
+	}
+	l.cancel()
+	l.wg.Wait()
+}
+
+func (l *Limiter) run(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.store.PruneTokenBuckets(ctx, l.idle()); err != nil && ctx.Err() == nil {
+				slog.Warn("Can't prune rate limit buckets", "error", err)
+			}
+		}
+	}
+}
+
+// idle is how long it takes the slowest configured bucket to fill. Buckets
+// of keys with slower overrides may go early, which only lets them start
+// over with a full bucket.
+func (l *Limiter) idle() time.Duration {
+	var idle time.Duration
+	for _, limit := range l.limits {
+		idle = max(idle, seconds(float64(limit.Burst)*60/float64(limit.PerMinute)))
+	}
+	return idle
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/models"
+)
+
+// clock is a time that only moves when the test says so.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newLimiter(t *testing.T) (*Limiter, *clock) {
+	t.Helper()
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore().(*memoryStore)
+	store.now = c.Now
+	cfg := config.Default().RateLimit
+	// 1 token every 2s, 3 at most
+	cfg.WritePerMinute, cfg.WriteBurst = 30, 3
+	return New(store, cfg), c
+}
+
+func allow(t *testing.T, l *Limiter, client, class string, overrides map[string]models.RateLimit) Result {
+	t.Helper()
+	res, err := l.Allow(context.Background(), client, class, overrides)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	return res
+}
+
+func TestAllowBurst(t *testing.T) {
+	l, _ := newLimiter(t)
+
+	for i, remaining := range []int{2, 1, 0} {
+		res := allow(t, l, "key:1", Write, nil)
+		if !res.Allowed || res.Remaining != remaining || res.Limit != 3 || res.Window != 6*time.Second || res.RetryAfter != 0 {
+			t.Errorf("request %d = %+v, want allowed with %d left of 3", i+1, res, remaining)
+		}
+	}
+	res := allow(t, l, "key:1", Write, nil)
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 2*time.Second || res.Reset != 6*time.Second {
+		t.Errorf("request past the burst = %+v, want refused, retry after 2s and full in 6s", res)
+	}
+
+	// other clients and other classes have buckets of their own
+	if res := allow(t, l, "key:2", Write, nil); !res.Allowed {
+		t.Errorf("another client was refused: %+v", res)
+	}
+	if res := allow(t, l, "key:1", Read, nil); !res.Allowed || res.Limit != config.Default().RateLimit.ReadBurst {
+		t.Errorf("another class = %+v, want allowed with the read limit", res)
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	l, c := newLimiter(t)
+	for range 3 {
+		allow(t, l, "key:1", Write, nil)
+	}
+
+	c.advance(time.Second)
+	if res := allow(t, l, "key:1", Write, nil); res.Allowed || res.RetryAfter != time.Second {
+		t.Errorf("after 1s = %+v, want refused, retry after another 1s", res)
+	}
+	c.advance(time.Second)
+	if res := allow(t, l, "key:1", Write, nil); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("after 2s = %+v, want the refilled token", res)
+	}
+
+	// the bucket fills up to the burst and no further
+	c.advance(time.Hour)
+	if res := allow(t, l, "key:1", Write, nil); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("after an hour = %+v, want a full bucket less this request", res)
+	}
+}
+
+func TestAllowOverrides(t *testing.T) {
+	l, _ := newLimiter(t)
+	overrides := map[string]models.RateLimit{Write: {PerMinute: 60, Burst: 5}}
+
+	for i := range 5 {
+		if res := allow(t, l, "key:1", Write, overrides); !res.Allowed || res.Limit != 5 {
+			t.Fatalf("request %d with a burst of 5 = %+v", i+1, res)
+		}
+	}
+	if res := allow(t, l, "key:1", Write, overrides); res.Allowed || res.RetryAfter != time.Second {
+		t.Errorf("request past the override = %+v, want refused, retry after 1s", res)
+	}
+
+	// overrides of other classes and incomplete ones are ignored
+	broken := map[string]models.RateLimit{Read: {PerMinute: 1, Burst: 1}, Write: {PerMinute: 0, Burst: 100}}
+	if res := allow(t, l, "key:2", Write, broken); res.Limit != 3 {
+		t.Errorf("Limit with unusable overrides = %d, want the configured 3", res.Limit)
+	}
+}
+
+func TestPruneTokenBuckets(t *testing.T) {
+	l, c := newLimiter(t)
+	store := l.store.(*memoryStore)
+	allow(t, l, "key:1", Write, nil)
+	c.advance(time.Minute)
+	allow(t, l, "key:2", Write, nil)
+
+	if err := store.PruneTokenBuckets(context.Background(), 30*time.Second); err != nil {
+		t.Fatalf("PruneTokenBuckets: %v", err)
+	}
+	if _, ok := store.buckets["write:key:1"]; ok {
+		t.Errorf("idle bucket wasn't pruned")
+	}
+	if _, ok := store.buckets["write:key:2"]; !ok {
+		t.Errorf("bucket in use was pruned")
+	}
+}
+
+func TestIdle(t *testing.T) {
+	l, _ := newLimiter(t)
+	// the sign-in bucket, 200 at 1200 a minute, takes 10s; enrich, 10 at 30 a
+	// minute, takes 20s and is the slowest
+	if got := l.idle(); got != 20*time.Second {
+		t.Errorf("idle = %v, want 20s", got)
+	}
+}
@@ -0,0 +1,17 @@
+package retry
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff is the delay before retrying after attempt failed tries: base
+// doubled for every try after the first up to limit, with up to a fifth
+// added so retries failed together don't line up again.
+func Backoff(attempt int, base, limit time.Duration) time.Duration {
+	d := base << max(attempt-1, 0)
+	if d <= 0 || d > limit {
+		d = limit
+	}
+	return d + rand.N(d/5+1)
+}
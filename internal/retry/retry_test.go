@@ -0,0 +1,27 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if got := Backoff(tt.attempt, time.Second, 10*time.Second); got < tt.want || got > tt.want+tt.want/5 {
+				t.Errorf("Backoff(%d) = %s, want %s plus at most a fifth", tt.attempt, got, tt.want)
+			}
+		}
+	}
+}
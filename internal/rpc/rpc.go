@@ -0,0 +1,154 @@
+// Package rpc serves the song library over gRPC. The messages and services
+// are generated into libraryv1 from proto/ with make proto.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/library"
+	"music-library/internal/logging"
+	"music-library/internal/models"
+	"music-library/internal/rpc/libraryv1"
+	"music-library/internal/server/query"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type SongService struct {
+	libraryv1.UnimplementedSongServiceServer
+	lib *library.Library
+}
+
+func NewSongService(lib *library.Library) *SongService {
+	return &SongService{lib: lib}
+}
+
+func (s *SongService) ListSongs(ctx context.Context, req *libraryv1.ListSongsRequest) (*libraryv1.ListSongsResponse, error) {
+	opts := query.Options{
+		Paginator: query.NewPaginator(int(req.Page), int(req.Limit)),
+		Search:    strings.TrimSpace(req.Q),
+	}
+	for _, filter := range []query.Filter{
+		{Field: "group", Value: req.Group},
+		{Field: "song", Value: req.Song},
+		{Field: "releaseDate", Value: req.ReleaseDate},
+		{Field: "text", Value: req.Text},
+		{Field: "link", Value: req.Link},
+	} {
+		if filter.Value != "" {
+			opts.Filters = append(opts.Filters, filter)
+		}
+	}
+
+	songs, err := s.lib.Songs(ctx, opts)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	res := &libraryv1.ListSongsResponse{Songs: make([]*libraryv1.Song, len(songs))}
+	for i, song := range songs {
+		res.Songs[i] = songMessage(song)
+	}
+	return res, nil
+}
+
+func (s *SongService) GetSong(ctx context.Context, req *libraryv1.GetSongRequest) (*libraryv1.Song, error) {
+	song, err := s.lib.Song(ctx, id(req.Id))
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return songMessage(song), nil
+}
+
+func (s *SongService) CreateSong(ctx context.Context, req *libraryv1.CreateSongRequest) (*libraryv1.CreateSongResponse, error) {
+	accepted, err := s.lib.Create(ctx, models.NewSong{Group: req.Group, Song: req.Song})
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return &libraryv1.CreateSongResponse{
+		Id:               int64(accepted.Id),
+		JobId:            int64(accepted.JobId),
+		EnrichmentStatus: accepted.EnrichmentStatus,
+	}, nil
+}
+
+func (s *SongService) UpdateSong(ctx context.Context, req *libraryv1.UpdateSongRequest) (*libraryv1.Song, error) {
+	changes := library.Changes{
+		Song:        req.Song,
+		ReleaseDate: req.ReleaseDate,
+		Text:        req.Text,
+		Link:        req.Link,
+	}
+	song, err := s.lib.Update(ctx, id(req.Id), changes.Apply)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return songMessage(song), nil
+}
+
+func (s *SongService) DeleteSong(ctx context.Context, req *libraryv1.DeleteSongRequest) (*libraryv1.Song, error) {
+	song, err := s.lib.Delete(ctx, id(req.Id))
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return songMessage(song), nil
+}
+
+func (s *SongService) GetVerse(ctx context.Context, req *libraryv1.GetVerseRequest) (*libraryv1.GetVerseResponse, error) {
+	song, err := s.lib.Song(ctx, id(req.Id))
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	text, count, err := library.Verse(song, int(req.Number))
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return &libraryv1.GetVerseResponse{Text: text, Count: int32(count)}, nil
+}
+
+func id(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func songMessage(song models.Song) *libraryv1.Song {
+	msg := &libraryv1.Song{
+		Id:               int64(song.Id),
+		Group:            song.Group,
+		Song:             song.Song,
+		ReleaseDate:      song.Value(models.FieldReleaseDate),
+		Text:             song.Text,
+		Link:             song.Link,
+		EnrichmentStatus: song.EnrichmentStatus,
+		EditedFields:     song.EditedFields,
+		CreatedBy:        song.CreatedBy,
+		UpdatedBy:        song.UpdatedBy,
+	}
+	if song.EnrichedAt != nil {
+		msg.EnrichedAt = timestamppb.New(*song.EnrichedAt)
+	}
+	return msg
+}
+
+// statusOf maps the library's errors to status codes, hiding the details of
+// unexpected ones from clients.
+func statusOf(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, customErrors.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, customErrors.ErrNoVerse):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, customErrors.ErrInvalidData):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "gRPC call", "error", err.Error())
+	return status.Error(codes.Internal, customErrors.ErrISE.Error())
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"music-library/internal/audit"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/enrichment"
+	"music-library/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshHandler
+//
+// @Summary		Refresh song metadata
+// @Description	Queue a metadata refresh from the music info service for one song, all songs of a group, or the whole library. Fields edited by hand are kept.
+// @Accept			json
+// @Produce		json
+// @Param			request	body		models.RefreshRequest	true	"Exactly one of songId, group or all"
+// @Success		202		{object}	models.RefreshAccepted
+// @Failure		400		{string}	string	"Bad request"
+// @Failure		404		{string}	string	"Song not found"
+// @Failure		401		{string}	string	"Missing or invalid credentials"
+// @Failure		403		{string}	string	"Caller lacks the required scope"
+// @Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500		{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/admin/refresh [post]
+func (s *Server) RefreshHandler(c *gin.Context) {
+	var req models.RefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	targets := 0
+	for _, set := range []bool{req.SongId != 0, req.Group != "", req.All} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		c.String(http.StatusBadRequest, "exactly one of songId, group or all is required")
+		return
+	}
+
+	if req.SongId != 0 {
+		if _, err := s.db.GetSongById(c.Request.Context(), strconv.Itoa(req.SongId)); err != nil {
+			if errors.Is(err, customErrors.ErrNotFound) {
+				c.String(http.StatusNotFound, err.Error())
+				return
+			}
+			requestLogger(c).Debug("RefreshHandler", "error", err.Error())
+			c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	var n int
+	err := s.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		n, err = tx.EnqueueRefreshJobs(ctx, enrichment.KindRefresh, models.RefreshFilter{SongId: req.SongId, Group: req.Group})
+		if err != nil || n == 0 {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, audit.Refresh, refreshTarget(req), nil, models.RefreshAccepted{Enqueued: n})
+	})
+	if err != nil {
+		requestLogger(c).Debug("RefreshHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	if n > 0 {
+		s.enrichment.Notify()
+	}
+	c.JSON(http.StatusAccepted, models.RefreshAccepted{Enqueued: n})
+}
+
+// refreshTarget is the audit resource id of a refresh request: song:<id>,
+// group:<name> or all.
+func refreshTarget(req models.RefreshRequest) string {
+	switch {
+	case req.SongId != 0:
+		return "song:" + strconv.Itoa(req.SongId)
+	case req.Group != "":
+		return "group:" + req.Group
+	}
+	return "all"
+}
+
+// GetSongRefreshesHandler
+//
+// @Summary		Get song refresh history
+// @Description	Get the metadata changes applied to a song by refreshes, newest first
+// @Accept			json
+// @Produce		json
+// @Param			id	path		int	true	"Song ID"
+// @Success		200	{object}	[]models.SongRefresh
+// @Failure		404	{string}	string	"Song not found"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/admin/songs/{id}/refreshes [get]
+func (s *Server) GetSongRefreshesHandler(c *gin.Context) {
+	songID := c.Param("id")
+
+	if _, err := s.db.GetSongById(c.Request.Context(), songID); err != nil {
+		if errors.Is(err, customErrors.ErrNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("GetSongRefreshesHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+
+	refreshes, err := s.db.GetSongRefreshes(c.Request.Context(), songID)
+	if err != nil {
+		requestLogger(c).Debug("GetSongRefreshesHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.JSON(http.StatusOK, refreshes)
+}
@@ -0,0 +1,45 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"music-library/internal/audit"
+	"music-library/internal/auth"
+	"music-library/internal/models"
+)
+
+func TestRefreshAudited(t *testing.T) {
+	h, db := newServer(t, testConfig())
+	ctx := context.Background()
+	_, key, err := auth.CreateKey(ctx, db, "ops", []string{auth.ScopeAdmin})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	id, err := db.AddNewSong(ctx, models.Song{Group: "Muse", Song: "Starlight", EnrichmentStatus: models.EnrichmentDone})
+	if err != nil {
+		t.Fatalf("AddNewSong: %v", err)
+	}
+
+	body := fmt.Sprintf(`{"songId": %d}`, id)
+	if rec := post(h, "/admin/refresh", body, "192.0.2.1", key); rec.Code != http.StatusAccepted || rec.Body.String() != `{"enqueued":1}` {
+		t.Fatalf("refresh = %d %s, want 202 with 1 enqueued", rec.Code, rec.Body)
+	}
+	entries, err := db.ListAudit(ctx, models.AuditFilter{Resource: audit.Refresh, Limit: 10})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ListAudit = %+v, %v; want the refresh", entries, err)
+	}
+	if e := entries[0]; e.Action != audit.Create || e.ResourceId != fmt.Sprintf("song:%d", id) || e.SourceIP != "192.0.2.1" || string(e.After) != `{"enqueued":1}` {
+		t.Errorf("audit entry = %+v, want the refresh of song %d", e, id)
+	}
+
+	// the song is already queued, so a second request changes nothing
+	if rec := post(h, "/admin/refresh", body, "192.0.2.1", key); rec.Code != http.StatusAccepted || rec.Body.String() != `{"enqueued":0}` {
+		t.Fatalf("second refresh = %d %s, want 202 with none enqueued", rec.Code, rec.Body)
+	}
+	if entries, _ := db.ListAudit(ctx, models.AuditFilter{Resource: audit.Refresh, Limit: 10}); len(entries) != 1 {
+		t.Errorf("audit has %d refreshes, want 1", len(entries))
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuditHandler
+//
+// @Summary		Get audit log
+// @Description	Get the recorded changes to songs, artists, jobs, webhooks and API keys, newest first
+// @Accept			json
+// @Produce		json
+// @Param			actor		query		string	false	"Filter by actor, e.g. key:ci or a username"
+// @Param			resource	query		string	false	"Filter by resource: song, artist, job, refresh, webhook, webhookDelivery or apiKey"
+// @Param			resourceId	query		string	false	"Filter by resource id"
+// @Param			from		query		string	false	"Changes at or after this RFC 3339 time"
+// @Param			to			query		string	false	"Changes before this RFC 3339 time"
+// @Param			page		query		int		false	"Page number, from 1"
+// @Param			limit		query		int		false	"Page size, 10 by default"
+// @Success		200			{object}	[]models.AuditEntry
+// @Failure		400			{string}	string	"Bad request"
+// @Failure		401			{string}	string	"Missing or invalid credentials"
+// @Failure		403			{string}	string	"Caller lacks the required scope"
+// @Failure		429			{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500			{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/audit [get]
+func (s *Server) GetAuditHandler(c *gin.Context) {
+	filter := models.AuditFilter{
+		Actor:      c.Query("actor"),
+		Resource:   c.Query("resource"),
+		ResourceId: c.Query("resourceId"),
+	}
+	for param, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.String(http.StatusBadRequest, customErrors.ErrInvalidData.Error()+": "+param+" must be an RFC 3339 time")
+			return
+		}
+		*t = parsed
+	}
+	filter.Limit, filter.Offset = query.GetPaginator(c).Bounds()
+
+	entries, err := s.db.ListAudit(c.Request.Context(), filter)
+	if err != nil {
+		requestLogger(c).Debug("GetAuditHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"music-library/internal/audit"
+	"music-library/internal/auth"
+	"music-library/internal/customErrors"
+	"music-library/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// requireScope lets a request through only if its API key or token grants
+// scope, or without credentials for songs:read routes when anonymous reads
+// are allowed. Requests with credentials first take a sign-in token for
+// their IP. The caller goes into the request context, its logger, its
+// span and the audit entries of its changes.
+func (s *Server) requireScope(scope string) gin.HandlerFunc {
+	if !s.auth.Enabled {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		key, token := credentials(c)
+		if key == "" && token == "" {
+			if scope == auth.ScopeSongsRead && s.auth.AnonymousRead {
+				c.Next()
+				return
+			}
+			unauthorized(c, "")
+			return
+		}
+		if !s.allowSignIn(c) {
+			return
+		}
+
+		ctx, principal, err := s.signIn(c.Request.Context(), key, token)
+		if err != nil {
+			if errors.Is(err, customErrors.ErrUnauthorized) {
+				requestLogger(c).Info("Rejected credentials", "error", err)
+				unauthorized(c, "invalid_token")
+				return
+			}
+			requestLogger(c).Error("Can't authenticate request", "error", err)
+			c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		if !principal.Has(scope) {
+			c.String(http.StatusForbidden, customErrors.ErrForbidden.Error())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// signIn authenticates the credentials and returns ctx carrying the caller
+// for handlers, logs, the span and audit entries.
+func (s *Server) signIn(ctx context.Context, key, token string) (context.Context, auth.Principal, error) {
+	principal, err := s.authenticate(ctx, key, token)
+	if err != nil {
+		return ctx, principal, err
+	}
+	if principal.KeyId != 0 {
+		s.usage.Record(principal.KeyId)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("enduser.id", principal.Name))
+	ctx = audit.WithActor(auth.WithPrincipal(ctx, principal), principal.Name)
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("principal", principal.Name))
+	return ctx, principal, nil
+}
+
+// authenticate checks an API key, or a bearer token that is either one of
+// our keys or, with OIDC set up, an SSO token.
+func (s *Server) authenticate(ctx context.Context, key, token string) (auth.Principal, error) {
+	if key == "" && !auth.IsAPIKey(token) && s.verifier != nil {
+		return s.verifier.Verify(ctx, token)
+	}
+	return auth.Authenticate(ctx, s.db, key+token)
+}
+
+// credentials returns the X-API-Key header, or else the Authorization
+// bearer token.
+func credentials(c *gin.Context) (key, token string) {
+	return credentialsOf(c.GetHeader(apiKeyHeader), c.GetHeader("Authorization"))
+}
+
+func credentialsOf(apiKey, authorization string) (key, token string) {
+	if apiKey != "" {
+		return apiKey, ""
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return "", strings.TrimSpace(token)
+	}
+	return "", ""
+}
+
+func unauthorized(c *gin.Context, reason string) {
+	challenge := `Bearer realm="music-library"`
+	if reason != "" {
+		challenge += `, error="` + reason + `"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.String(http.StatusUnauthorized, customErrors.ErrUnauthorized.Error())
+	c.Abort()
+}
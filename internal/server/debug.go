@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) PoolStatsHandler(c *gin.Context) {
+	if s.pool == nil {
+		c.String(http.StatusNotFound, "database has no connection pool")
+		return
+	}
+	c.JSON(http.StatusOK, s.pool.PoolStats())
+}
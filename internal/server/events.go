@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/events"
+	"music-library/internal/models"
+	"music-library/internal/outbox"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// streamWriteTimeout replaces the server's WriteTimeout for each write
+	// to a stream, which would otherwise end it after 30 seconds.
+	streamWriteTimeout = 30 * time.Second
+	// reconnectDelay is how long EventSource clients wait to reconnect.
+	reconnectDelay = 3 * time.Second
+)
+
+// EventsHandler
+//
+// @Summary		Stream change events
+// @Description	Stream song.created, song.updated and song.deleted events as server-sent events. Each has the event's seq as its id; reconnecting with Last-Event-ID, or lastEventId, replays the recent events missed, preceded by a reset event if some are no longer kept.
+// @Produce		text/event-stream
+// @Param			type			query		string	false	"Event types, comma separated"
+// @Param			artist			query		string	false	"Only songs of this group"
+// @Param			lastEventId		query		int		false	"Resume after this event, for clients that can't send Last-Event-ID"
+// @Param			Last-Event-ID	header		int		false	"Resume after this event"
+// @Success		200				{object}	models.Event
+// @Failure		400				{string}	string	"Bad request"
+// @Failure		401				{string}	string	"Missing or invalid credentials"
+// @Failure		403				{string}	string	"Caller lacks the required scope"
+// @Failure		429				{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		503				{string}	string	"Shutting down"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/events [get]
+func (s *Server) EventsHandler(c *gin.Context) {
+	filter := events.Filter{Artist: c.Query("artist")}
+	if types := c.Query("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	for _, typ := range filter.Types {
+		if !slices.Contains(outbox.Events, typ) {
+			c.String(http.StatusBadRequest, fmt.Sprintf("%s: unknown event type %q", customErrors.ErrInvalidData, typ))
+			return
+		}
+	}
+
+	lastId := c.GetHeader("Last-Event-ID")
+	if lastId == "" {
+		lastId = c.Query("lastEventId")
+	}
+	var after int64
+	if lastId != "" {
+		var err error
+		if after, err = strconv.ParseInt(lastId, 10, 64); err != nil || after < 0 {
+			c.String(http.StatusBadRequest, customErrors.ErrInvalidData.Error()+": Last-Event-ID must be an event seq")
+			return
+		}
+	}
+
+	if s.shutdown.Err() != nil {
+		c.String(http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+
+	sub, replay, complete := s.events.Subscribe(after, filter)
+	defer s.events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	stream := &eventStream{c: c, rc: http.NewResponseController(c.Writer)}
+	stream.write(fmt.Sprintf("retry: %d\n\n", reconnectDelay.Milliseconds()))
+	if !complete {
+		stream.write("event: reset\ndata: {}\n\n")
+	}
+	for _, event := range replay {
+		stream.send(event)
+	}
+	stream.flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for stream.err == nil {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-s.shutdown.Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			stream.send(event)
+		case <-heartbeat.C:
+			stream.write(": ping\n\n")
+		}
+		stream.flush()
+	}
+	requestLogger(c).Debug("EventsHandler", "error", stream.err.Error())
+}
+
+// eventStream writes server-sent events, stopping at the first error.
+type eventStream struct {
+	c   *gin.Context
+	rc  *http.ResponseController
+	err error
+}
+
+func (s *eventStream) send(event models.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.err = err
+		return
+	}
+	s.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data))
+}
+
+func (s *eventStream) write(text string) {
+	if s.err != nil {
+		return
+	}
+	if s.err = s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); s.err != nil {
+		return
+	}
+	_, s.err = s.c.Writer.WriteString(text)
+}
+
+func (s *eventStream) flush() {
+	if s.err == nil {
+		s.err = s.rc.Flush()
+	}
+}
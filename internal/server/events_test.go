@@ -0,0 +1,125 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"music-library/internal/auth"
+)
+
+// stream reads the frames of an event stream, each one's lines joined by
+// newlines, until the test ends.
+func stream(t *testing.T, url, key, lastId string) (int, <-chan string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", key)
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	frames := make(chan string, 100)
+	go func() {
+		defer close(frames)
+		scanner := bufio.NewScanner(resp.Body)
+		var lines []string
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines = append(lines, scanner.Text())
+				continue
+			}
+			frames <- strings.Join(lines, "\n")
+			lines = nil
+		}
+	}()
+	return resp.StatusCode, frames
+}
+
+// next returns the next frame that isn't a heartbeat, unless heartbeats are
+// what the caller wants.
+func next(t *testing.T, frames <-chan string, heartbeat bool) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				t.Fatal("stream ended")
+			}
+			if heartbeat || frame != ": ping" {
+				return frame
+			}
+		case <-timeout:
+			t.Fatal("no event within 5s")
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	cfg := testConfig()
+	cfg.Events.Heartbeat = 20 * time.Millisecond
+	cfg.RateLimit.Enabled = false
+	h, db := newServer(t, cfg)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	_, key, err := auth.CreateKey(context.Background(), db, "editor", []string{auth.ScopeSongsRead, auth.ScopeSongsWrite})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	status, all := stream(t, srv.URL+"/events", key, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /events = %d, want 200", status)
+	}
+	if frame := next(t, all, false); frame != "retry: 3000" {
+		t.Errorf("first frame = %q, want the reconnect delay", frame)
+	}
+	if frame := next(t, all, true); frame != ": ping" {
+		t.Errorf("idle stream sent %q, want a heartbeat", frame)
+	}
+	_, queen := stream(t, srv.URL+"/events?type=song.created&artist=queen", key, "")
+	next(t, queen, false)
+
+	for _, group := range []string{"Muse", "Queen"} {
+		if rec := post(h, "/songs", `{"group": "`+group+`", "song": "Song"}`, "192.0.2.1", key); rec.Code != http.StatusAccepted {
+			t.Fatalf("POST /songs = %d %s", rec.Code, rec.Body)
+		}
+	}
+	muse, queenCreated := next(t, all, false), next(t, all, false)
+	if !strings.HasPrefix(muse, "id: 1\nevent: song.created\ndata: {") || !strings.Contains(muse, `"group":"Muse"`) {
+		t.Errorf("first event = %q, want Muse's song.created numbered 1", muse)
+	}
+	if !strings.HasPrefix(queenCreated, "id: 2\nevent: song.created\n") {
+		t.Errorf("second event = %q, want Queen's song.created numbered 2", queenCreated)
+	}
+	if frame := next(t, queen, false); !strings.HasPrefix(frame, "id: 2\n") {
+		t.Errorf("filtered stream got %q, want only Queen's song", frame)
+	}
+
+	// a client coming back after seq 1 gets the rest replayed
+	_, resumed := stream(t, srv.URL+"/events", key, "1")
+	next(t, resumed, false)
+	if frame := next(t, resumed, false); !strings.HasPrefix(frame, "id: 2\n") {
+		t.Errorf("resumed stream got %q, want event 2 replayed", frame)
+	}
+
+	for _, bad := range []string{"/events?type=song.played", "/events?lastEventId=x"} {
+		if rec := get(h, bad, "192.0.2.1", key); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", bad, rec.Code)
+		}
+	}
+}
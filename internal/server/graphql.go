@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"music-library/internal/auth"
+	"music-library/internal/customErrors"
+	"music-library/internal/gql"
+	"music-library/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GraphQLHandler
+//
+// @Summary		Run a GraphQL operation
+// @Description	Query songs, their artists and verses with the fields needed, or create, update and delete songs. Operations nested deeper than GRAPHQL_MAX_DEPTH or above GRAPHQL_MAX_COMPLEXITY are rejected before they run. Queries may also be sent with GET, with variables as JSON. Mutations need the songs:write scope and count against the same rate limits as the REST routes.
+// @Accept			json
+// @Produce		json
+// @Param			request			body		gql.Request	false	"Operation"
+// @Param			query			query		string		false	"Query, for GET"
+// @Param			operationName	query		string		false	"Operation to run, for GET"
+// @Param			variables		query		string		false	"Variables as a JSON object, for GET"
+// @Success		200				{object}	gql.Response
+// @Failure		400				{object}	gql.Rejection
+// @Failure		401				{string}	string	"Missing or invalid credentials"
+// @Failure		403				{string}	string	"Caller lacks the required scope"
+// @Failure		405				{string}	string	"Mutation sent with GET"
+// @Failure		429				{string}	string	"Rate limit exceeded, see Retry-After"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/graphql [get]
+// @Router			/graphql [post]
+func (s *Server) GraphQLHandler(c *gin.Context) {
+	var req gql.Request
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.String(http.StatusBadRequest, customErrors.ErrInvalidData.Error()+": variables must be a JSON object")
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	op, errs := s.graphql.Prepare(req)
+	if errs != nil {
+		c.JSON(http.StatusBadRequest, gql.Rejection{Errors: errs})
+		return
+	}
+	if op.Mutations != nil {
+		if c.Request.Method == http.MethodGet {
+			c.Header("Allow", http.MethodPost)
+			c.String(http.StatusMethodNotAllowed, "mutations must be sent with POST")
+			return
+		}
+		if !s.authorizeMutation(c, op) {
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, s.graphql.Execute(c.Request.Context(), op))
+}
+
+// authorizeMutation checks the caller may make the changes of op, taking a
+// token of the class of the matching REST route for each.
+func (s *Server) authorizeMutation(c *gin.Context, op *gql.Operation) bool {
+	if s.auth.Enabled {
+		principal, ok := auth.FromContext(c.Request.Context())
+		if !ok {
+			unauthorized(c, "")
+			return false
+		}
+		if !principal.Has(auth.ScopeSongsWrite) {
+			c.String(http.StatusForbidden, customErrors.ErrForbidden.Error())
+			c.Abort()
+			return false
+		}
+	}
+
+	for _, field := range op.Mutations {
+		class := ratelimit.Write
+		if field == "createSong" {
+			class = ratelimit.Enrich
+		}
+		if !s.allow(c, class) {
+			return false
+		}
+	}
+	return true
+}
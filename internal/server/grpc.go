@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/auth"
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+	"music-library/internal/logging"
+	"music-library/internal/models"
+	"music-library/internal/ratelimit"
+	"music-library/internal/rpc"
+	"music-library/internal/rpc/libraryv1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_requests_total",
+		Help: "gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_request_duration_seconds",
+		Help:    "gRPC call latency by method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+// grpcMethods are the scope and rate limit class of each SongService call,
+// those of the matching REST route. Calls to other services, health checks
+// and reflection, need neither.
+var grpcMethods = map[string]struct{ scope, class string }{
+	libraryv1.SongService_ListSongs_FullMethodName:  {auth.ScopeSongsRead, ratelimit.Read},
+	libraryv1.SongService_GetSong_FullMethodName:    {auth.ScopeSongsRead, ratelimit.Read},
+	libraryv1.SongService_GetVerse_FullMethodName:   {auth.ScopeSongsRead, ratelimit.Read},
+	libraryv1.SongService_CreateSong_FullMethodName: {auth.ScopeSongsWrite, ratelimit.Enrich},
+	libraryv1.SongService_UpdateSong_FullMethodName: {auth.ScopeSongsWrite, ratelimit.Write},
+	libraryv1.SongService_DeleteSong_FullMethodName: {auth.ScopeSongsWrite, ratelimit.Write},
+}
+
+// serveGRPC serves the gRPC API on its own port, or the listener it was
+// given, until stopGRPC.
+func (s *Server) serveGRPC(cfg config.Server) error {
+	lis := s.grpcListener
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort)); err != nil {
+			return err
+		}
+	}
+
+	s.grpc = grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		grpc.ChainUnaryInterceptor(s.observeCall, s.authorizeCall),
+	)
+	libraryv1.RegisterSongServiceServer(s.grpc, rpc.NewSongService(s.library))
+	s.grpcHealth = health.NewServer()
+	s.grpcHealth.SetServingStatus(libraryv1.SongService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	context.AfterFunc(s.shutdown, s.grpcHealth.Shutdown)
+	healthpb.RegisterHealthServer(s.grpc, grpcHealth{Server: s.grpcHealth, s: s})
+	reflection.Register(s.grpc)
+
+	s.grpcServed = make(chan struct{})
+	go func() {
+		defer close(s.grpcServed)
+		slog.Info("Serving gRPC", "port", cfg.GRPCPort)
+		if err := s.grpc.Serve(lis); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// stopGRPC stops accepting calls and waits for those in flight, cutting
+// them off once ctx ends, then for Serve to return.
+func (s *Server) stopGRPC(ctx context.Context) {
+	s.grpcHealth.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("gRPC calls didn't finish in time, closing them")
+		s.grpc.Stop()
+	}
+	<-s.grpcServed
+}
+
+// grpcHealth answers checks of the whole server and of SongService with the
+// readiness probe. Watch reports the status set at startup, which turns to
+// NOT_SERVING once shutdown starts.
+type grpcHealth struct {
+	*health.Server
+	s *Server
+}
+
+func (h grpcHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if req.Service != "" && req.Service != libraryv1.SongService_ServiceDesc.ServiceName {
+		return h.Server.Check(ctx, req)
+	}
+	res := &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}
+	if h.s.readiness(ctx).Status != models.HealthOK {
+		res.Status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return res, nil
+}
+
+// observeCall is the gRPC counterpart of the request id, logging, metrics
+// and recovery middleware. The request id comes from x-request-id metadata
+// and is sent back in the response header.
+func (s *Server) observeCall(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res any, err error) {
+	start := time.Now()
+	id := incoming(ctx, requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+
+	ip := peerIP(ctx)
+	logger := slog.Default().With("request_id", id)
+	ctx = logging.WithLogger(audit.WithRequest(ctx, id, ip), logger)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.ErrorContext(ctx, "gRPC call panicked", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, customErrors.ErrISE.Error())
+		}
+
+		code := status.Code(err)
+		level := slog.LevelInfo
+		switch code {
+		case codes.Internal, codes.Unknown, codes.DataLoss:
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "gRPC call",
+			slog.String("method", info.FullMethod),
+			slog.String("code", code.String()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", ip),
+		)
+		grpcRequests.WithLabelValues(info.FullMethod, code.String()).Inc()
+		grpcDuration.WithLabelValues(info.FullMethod, code.String()).Observe(time.Since(start).Seconds())
+	}()
+
+	return handler(ctx, req)
+}
+
+// authorizeCall checks the credentials in x-api-key or authorization
+// metadata like requireScope, sign-in token first, and takes a rate limit
+// token like rateLimit.
+func (s *Server) authorizeCall(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	method, ok := grpcMethods[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	if s.auth.Enabled {
+		key, token := credentialsOf(incoming(ctx, apiKeyHeader), incoming(ctx, "Authorization"))
+		if key != "" || token != "" {
+			if err := s.allowCall(ctx, "ip:"+peerIP(ctx), ratelimit.SignIn, nil); err != nil {
+				return nil, err
+			}
+			var principal auth.Principal
+			var err error
+			if ctx, principal, err = s.signIn(ctx, key, token); err != nil {
+				if errors.Is(err, customErrors.ErrUnauthorized) {
+					logging.FromContext(ctx).InfoContext(ctx, "Rejected credentials", "error", err)
+					return nil, status.Error(codes.Unauthenticated, customErrors.ErrUnauthorized.Error())
+				}
+				logging.FromContext(ctx).ErrorContext(ctx, "Can't authenticate request", "error", err)
+				return nil, status.Error(codes.Internal, customErrors.ErrISE.Error())
+			}
+			if !principal.Has(method.scope) {
+				return nil, status.Error(codes.PermissionDenied, customErrors.ErrForbidden.Error())
+			}
+		} else if method.scope != auth.ScopeSongsRead || !s.auth.AnonymousRead {
+			return nil, status.Error(codes.Unauthenticated, customErrors.ErrUnauthorized.Error())
+		}
+	}
+
+	principal, _ := auth.FromContext(ctx)
+	if err := s.allowCall(ctx, clientOf(principal, peerIP(ctx)), method.class, principal.RateLimits); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// allowCall takes a token for class like allow, returning ResourceExhausted
+// if the bucket is empty.
+func (s *Server) allowCall(ctx context.Context, client, class string, overrides map[string]models.RateLimit) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	res, err := s.limiter.Allow(ctx, client, class, overrides)
+	if err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Can't check rate limit", "class", class, "error", err)
+		return nil
+	}
+	grpc.SetHeader(ctx, metadata.New(rateLimitHeaders(res)))
+	if !res.Allowed {
+		return status.Error(codes.ResourceExhausted, customErrors.ErrRateLimited.Error())
+	}
+	return nil
+}
+
+// incoming is the first value of the metadata key of the call.
+func incoming(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// peerIP is the address the call came from. Unlike HTTP requests, calls
+// aren't expected through proxies, so no forwarding headers are read.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,199 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"music-library/internal/auth"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/musicapi"
+	"music-library/internal/rpc/libraryv1"
+	"music-library/internal/server"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// noInfo is a music info service that knows no songs.
+type noInfo struct{}
+
+func (noInfo) GetMusicInfo(context.Context, string, string) (models.Song, error) {
+	return models.Song{}, musicapi.ErrNotFound
+}
+
+// grpcServer serves the gRPC API in memory, returning a connection to it
+// and the server's stop func.
+func grpcServer(t *testing.T) (*grpc.ClientConn, database.Service, func(context.Context) error) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	db := database.NewMemory()
+	_, stop, err := server.NewServer(testConfig(), server.WithDatabase(db), server.WithMusicAPI(noInfo{}), server.WithGRPCListener(lis))
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { stop(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, db, stop
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
+func TestGRPCSongs(t *testing.T) {
+	conn, db, _ := grpcServer(t)
+	_, key, err := auth.CreateKey(context.Background(), db, "editor", []string{auth.ScopeSongsRead, auth.ScopeSongsWrite})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	ctx := withKey(key)
+	songs := libraryv1.NewSongServiceClient(conn)
+
+	created, err := songs.CreateSong(ctx, &libraryv1.CreateSongRequest{Group: "Muse", Song: "Starlight"})
+	if err != nil || created.Id == 0 || created.JobId == 0 {
+		t.Fatalf("CreateSong = %v, %v", created, err)
+	}
+	text := "Far away\n\nMy ship is sailing"
+	updated, err := songs.UpdateSong(ctx, &libraryv1.UpdateSongRequest{Id: created.Id, Text: &text})
+	if err != nil || updated.Text != text || updated.Song != "Starlight" || updated.UpdatedBy != "key:editor" {
+		t.Fatalf("UpdateSong = %v, %v", updated, err)
+	}
+	if got, err := songs.GetSong(ctx, &libraryv1.GetSongRequest{Id: created.Id}); err != nil || got.Group != "Muse" || got.Text != text {
+		t.Errorf("GetSong = %v, %v", got, err)
+	}
+	if verse, err := songs.GetVerse(ctx, &libraryv1.GetVerseRequest{Id: created.Id, Number: 2}); err != nil || verse.Text != "My ship is sailing" || verse.Count != 2 {
+		t.Errorf("GetVerse(2) = %v, %v", verse, err)
+	}
+	list, err := songs.ListSongs(ctx, &libraryv1.ListSongsRequest{Group: "Muse"})
+	if err != nil || len(list.Songs) != 1 || list.Songs[0].Id != created.Id {
+		t.Errorf("ListSongs(Muse) = %v, %v", list, err)
+	}
+	if list, err := songs.ListSongs(ctx, &libraryv1.ListSongsRequest{Group: "Queen"}); err != nil || len(list.Songs) != 0 {
+		t.Errorf("ListSongs(Queen) = %v, %v; want none", list, err)
+	}
+	if deleted, err := songs.DeleteSong(ctx, &libraryv1.DeleteSongRequest{Id: created.Id}); err != nil || deleted.Id != created.Id {
+		t.Errorf("DeleteSong = %v, %v", deleted, err)
+	}
+
+	failures := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"get deleted", func() error { _, err := songs.GetSong(ctx, &libraryv1.GetSongRequest{Id: created.Id}); return err }, codes.NotFound},
+		{"update deleted", func() error {
+			_, err := songs.UpdateSong(ctx, &libraryv1.UpdateSongRequest{Id: created.Id, Text: &text})
+			return err
+		}, codes.NotFound},
+		{"delete deleted", func() error {
+			_, err := songs.DeleteSong(ctx, &libraryv1.DeleteSongRequest{Id: created.Id})
+			return err
+		}, codes.NotFound},
+		{"verse of deleted", func() error {
+			_, err := songs.GetVerse(ctx, &libraryv1.GetVerseRequest{Id: created.Id, Number: 1})
+			return err
+		}, codes.NotFound},
+		{"empty group", func() error {
+			_, err := songs.CreateSong(ctx, &libraryv1.CreateSongRequest{Song: "Nameless"})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range failures {
+		if err := tt.call(); status.Code(err) != tt.code {
+			t.Errorf("%s = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+
+	other, err := songs.CreateSong(ctx, &libraryv1.CreateSongRequest{Group: "Queen", Song: "Bohemian Rhapsody"})
+	if err != nil {
+		t.Fatalf("CreateSong: %v", err)
+	}
+	if _, err := songs.GetVerse(ctx, &libraryv1.GetVerseRequest{Id: other.Id, Number: 5}); status.Code(err) != codes.OutOfRange {
+		t.Errorf("GetVerse past the end = %v, want OutOfRange", err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	conn, db, _ := grpcServer(t)
+	_, viewer, err := auth.CreateKey(context.Background(), db, "viewer", []string{auth.ScopeSongsRead})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	songs := libraryv1.NewSongServiceClient(conn)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		call func(ctx context.Context) error
+		code codes.Code
+	}{
+		{"no credentials", context.Background(), func(ctx context.Context) error {
+			_, err := songs.ListSongs(ctx, &libraryv1.ListSongsRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"unknown key", withKey("ml_nope"), func(ctx context.Context) error {
+			_, err := songs.ListSongs(ctx, &libraryv1.ListSongsRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"bad bearer token", metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer nope"), func(ctx context.Context) error {
+			_, err := songs.ListSongs(ctx, &libraryv1.ListSongsRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"viewer writing", withKey(viewer), func(ctx context.Context) error {
+			_, err := songs.CreateSong(ctx, &libraryv1.CreateSongRequest{Group: "Muse", Song: "Starlight"})
+			return err
+		}, codes.PermissionDenied},
+		{"viewer reading", withKey(viewer), func(ctx context.Context) error {
+			_, err := songs.ListSongs(ctx, &libraryv1.ListSongsRequest{})
+			return err
+		}, codes.OK},
+	}
+	for _, tt := range tests {
+		if err := tt.call(tt.ctx); status.Code(err) != tt.code {
+			t.Errorf("%s = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+
+	// health checks need no credentials
+	res, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health check = %v, %v; want SERVING", res, err)
+	}
+}
+
+func TestGRPCShutdown(t *testing.T) {
+	conn, db, stop := grpcServer(t)
+	_, key, err := auth.CreateKey(context.Background(), db, "viewer", []string{auth.ScopeSongsRead})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	songs := libraryv1.NewSongServiceClient(conn)
+	if _, err := songs.ListSongs(withKey(key), &libraryv1.ListSongsRequest{}); err != nil {
+		t.Fatalf("ListSongs: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if _, err := songs.ListSongs(withKey(key), &libraryv1.ListSongsRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("ListSongs after stop = %v, want Unavailable", err)
+	}
+}
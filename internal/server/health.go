@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"music-library/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const readyCheckTimeout = 2 * time.Second
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthHandler
+//
+// @Summary		Liveness probe
+// @Description	Answers as long as the process is serving HTTP, without touching any dependency.
+// @Produce		json
+// @Success		200	{object}	models.Health
+// @Router			/healthz [get]
+func (s *Server) HealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Health{Status: models.HealthOK})
+}
+
+// ReadyHandler
+//
+// @Summary		Readiness probe
+// @Description	Checks the database answers and its schema matches this build, and the music info service if READY_CHECK_UPSTREAM is set. Fails with status draining once shutdown has started.
+// @Produce		json
+// @Success		200	{object}	models.Readiness
+// @Failure		503	{object}	models.Readiness
+// @Router			/readyz [get]
+func (s *Server) ReadyHandler(c *gin.Context) {
+	ready := s.readiness(c.Request.Context())
+	code := http.StatusOK
+	if ready.Status != models.HealthOK {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, ready)
+}
+
+// readiness runs the checks of the readiness probe, which the gRPC health
+// service shares.
+func (s *Server) readiness(ctx context.Context) models.Readiness {
+	if s.shutdown.Err() != nil {
+		return models.Readiness{Status: models.HealthDraining}
+	}
+
+	ready := models.Readiness{Status: models.HealthOK, Checks: make(map[string]models.HealthCheck)}
+	check := func(name string, fn func(ctx context.Context) (string, error)) {
+		ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+		defer cancel()
+
+		start := time.Now()
+		detail, err := fn(ctx)
+		result := models.HealthCheck{Status: models.HealthOK, Detail: detail, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.Status = models.HealthFailing
+			result.Error = err.Error()
+			ready.Status = models.HealthFailing
+		}
+		ready.Checks[name] = result
+	}
+
+	if db := s.health; db != nil {
+		check("database", func(ctx context.Context) (string, error) {
+			return "", db.Ping(ctx)
+		})
+		check("migrations", func(ctx context.Context) (string, error) {
+			status, err := db.SchemaStatus(ctx)
+			if err != nil {
+				return "", err
+			}
+			detail := fmt.Sprintf("version %d of %d", status.Version, status.Latest)
+			switch {
+			case status.Dirty:
+				return detail, errors.New("schema is dirty")
+			case status.Version < status.Latest:
+				return detail, errors.New("schema is behind this build")
+			}
+			return detail, nil
+		})
+	}
+	if s.upstream != nil {
+		check("musicapi", func(ctx context.Context) (string, error) {
+			return "", s.upstream.Ping(ctx)
+		})
+	}
+	return ready
+}
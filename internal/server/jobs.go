@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"music-library/internal/audit"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/enrichment"
+	"music-library/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJobHandler
+//
+// @Summary		Get job by id
+// @Description	Get status of a background job, e.g. song enrichment
+// @Accept			json
+// @Produce		json
+// @Param			id	path		int	true	"Job ID"
+// @Success		200	{object}	models.Job
+// @Failure		404	{string}	string	"Job not found"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/jobs/{id} [get]
+func (s *Server) GetJobHandler(c *gin.Context) {
+	job, err := s.db.GetJobById(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, customErrors.ErrJobNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("GetJobHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// RetryJobHandler
+//
+// @Summary		Retry job
+// @Description	Queue a finished or failed job to run again with a fresh set of attempts
+// @Accept			json
+// @Produce		json
+// @Param			id	path		int	true	"Job ID"
+// @Success		202	{object}	models.Job
+// @Failure		404	{string}	string	"Job not found"
+// @Failure		409	{string}	string	"Job is already queued or running"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/jobs/{id}/retry [post]
+func (s *Server) RetryJobHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	var job models.Job
+	err := s.db.WithTx(ctx, func(tx database.Service) error {
+		before, err := tx.GetJobById(ctx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if job, err = tx.RetryJob(ctx, c.Param("id")); err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.Update, audit.Job, job.Id, before, job); err != nil {
+			return err
+		}
+		if job.Kind != enrichment.KindEnrich {
+			return nil
+		}
+		err = tx.SetEnrichmentStatus(ctx, job.SongId, models.EnrichmentPending)
+		if errors.Is(err, customErrors.ErrNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, customErrors.ErrJobNotFound):
+			c.String(http.StatusNotFound, err.Error())
+		case errors.Is(err, customErrors.ErrJobActive):
+			c.String(http.StatusConflict, err.Error())
+		default:
+			requestLogger(c).Debug("RetryJobHandler", "error", err.Error())
+			c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		}
+		return
+	}
+	s.enrichment.Notify()
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID keeps what a client sends us from injecting anything odd
+// into logs and headers.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware accepts the client's X-Request-ID or makes one up,
+// echoes it back and attaches a logger carrying it to the request context,
+// along with the id and client IP for audit entries.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(requestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		ctx := audit.WithRequest(c.Request.Context(), id, c.ClientIP())
+		c.Request = c.Request.WithContext(logging.WithLogger(ctx, logger))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoggerMiddleware writes one access line per request. The path is logged
+// without its query string, which may carry search terms or credentials.
+func LoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		ctx := c.Request.Context()
+		logger := logging.FromContext(ctx)
+		if err := ctx.Err(); err != nil {
+			logger.WarnContext(ctx, "Request cancelled", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+		}
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "Request",
+			slog.String("method", c.Request.Method),
+			slog.String("route", routeOf(c)),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
+
+// requestLogger is the logger RequestIDMiddleware attached to the request.
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"music-library/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Requests are labelled by route template, never the raw path, and by
+// method only for the methods the API serves, so the series stay bounded.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route template and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+)
+
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		c.Next()
+
+		labels := []string{metricMethod(c.Request.Method), routeOf(c), strconv.Itoa(c.Writer.Status())}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}
+}
+
+// routeOf is the route template the request matched, "unmatched" for 404s
+// outside any route.
+func routeOf(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
+
+// traced leaves probes and metric scrapes out of tracing.
+func traced(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+	return true
+}
+
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// poolCollector exports a server's connection pool as the db_pool_*
+// metrics. It's registered by the server whose backend has a pool, and
+// unregistered when that server stops.
+type poolCollector struct {
+	pool database.PoolReporter
+}
+
+type poolMetric struct {
+	desc  *prometheus.Desc
+	kind  prometheus.ValueType
+	value func(s database.PoolStats) float64
+}
+
+var poolMetrics = []poolMetric{
+	{prometheus.NewDesc("db_pool_max_conns", "Maximum size of the database connection pool.", nil, nil), prometheus.GaugeValue, func(s database.PoolStats) float64 { return float64(s.MaxConns) }},
+	{prometheus.NewDesc("db_pool_total_conns", "Open database connections.", nil, nil), prometheus.GaugeValue, func(s database.PoolStats) float64 { return float64(s.TotalConns) }},
+	{prometheus.NewDesc("db_pool_acquired_conns", "Database connections in use.", nil, nil), prometheus.GaugeValue, func(s database.PoolStats) float64 { return float64(s.AcquiredConns) }},
+	{prometheus.NewDesc("db_pool_idle_conns", "Idle database connections.", nil, nil), prometheus.GaugeValue, func(s database.PoolStats) float64 { return float64(s.IdleConns) }},
+	{prometheus.NewDesc("db_pool_waits_total", "Connection acquires that had to wait.", nil, nil), prometheus.CounterValue, func(s database.PoolStats) float64 { return float64(s.WaitCount) }},
+	{prometheus.NewDesc("db_pool_wait_seconds_total", "Time spent acquiring connections.", nil, nil), prometheus.CounterValue, func(s database.PoolStats) float64 { return s.WaitSeconds }},
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range poolMetrics {
+		ch <- m.desc
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.PoolStats()
+	for _, m := range poolMetrics {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.kind, m.value(stats))
+	}
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"music-library/internal/server"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolExported reports whether the default registry has the pool metrics.
+func poolExported(t *testing.T) bool {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "db_pool_max_conns" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPoolMetrics(t *testing.T) {
+	start := func() func(context.Context) error {
+		t.Helper()
+		cfg := testConfig()
+		cfg.Database.Driver = "sqlite"
+		cfg.Database.SQLitePath = filepath.Join(t.TempDir(), "library.db")
+		cfg.Database.AutoMigrate = true
+		_, stop, err := server.NewServer(cfg)
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		return stop
+	}
+
+	if poolExported(t) {
+		t.Fatal("pool metrics exported before any server has a pool")
+	}
+	first := start()
+	if !poolExported(t) {
+		t.Fatal("server on sqlite doesn't export its pool")
+	}
+	// a second server can't take the names while the first holds them
+	second := start()
+	if err := first(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if poolExported(t) {
+		t.Error("pool metrics still exported after the server stopped")
+	}
+	if err := second(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	third := start()
+	defer third(context.Background())
+	if !poolExported(t) {
+		t.Error("server started after the others stopped doesn't export its pool")
+	}
+}
@@ -2,17 +2,33 @@ package query
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-var filterNames = []string{"group", "song", "releaseDate", "text", "link"}
+// FilterNames are the song fields that can be filtered on.
+var FilterNames = []string{"group", "song", "releaseDate", "text", "link"}
 
 type Paginator struct {
 	Limit  string
 	Offset string
 }
 
+// Bounds returns the numeric limit and offset, falling back to the first
+// page of 10 for missing or invalid values.
+func (p Paginator) Bounds() (limit, offset int) {
+	limit, err := strconv.Atoi(p.Limit)
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	offset, err = strconv.Atoi(p.Offset)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
 type Filter struct {
 	Field string
 	Value string
@@ -21,34 +37,36 @@ type Filter struct {
 type Options struct {
 	Paginator Paginator
 	Filters   []Filter
+	// Search holds words that must all appear in the song name or lyrics.
+	Search string
 }
 
 func GetPaginator(c *gin.Context) Paginator {
-	page, _ := c.GetQuery("page")
-	limit, _ := c.GetQuery("limit")
+	page, _ := strconv.Atoi(c.Query("page"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return NewPaginator(page, limit)
+}
 
-	p, err := strconv.Atoi(page)
-	if err != nil {
-		p = 1
+// NewPaginator returns the bounds of page, counted from 1, with limit songs
+// per page. Values below 1 mean the first page and pages of 10.
+func NewPaginator(page, limit int) Paginator {
+	if page < 1 {
+		page = 1
 	}
-
-	l, err := strconv.Atoi(limit)
-	if err != nil {
-		l = 10
+	if limit < 1 {
+		limit = 10
 	}
 
-	offset := (p - 1) * l
-
 	return Paginator{
-		Offset: strconv.Itoa(offset),
-		Limit:  strconv.Itoa(l),
+		Offset: strconv.Itoa((page - 1) * limit),
+		Limit:  strconv.Itoa(limit),
 	}
 }
 
 func GetFilters(c *gin.Context) []Filter {
-	filters := make([]Filter, 0, len(filterNames))
+	filters := make([]Filter, 0, len(FilterNames))
 
-	for _, name := range filterNames {
+	for _, name := range FilterNames {
 		val, _ := c.GetQuery(name)
 		if val != "" {
 			filters = append(filters, Filter{
@@ -65,5 +83,6 @@ func GetOptions(c *gin.Context) Options {
 	return Options{
 		Paginator: GetPaginator(c),
 		Filters:   GetFilters(c),
+		Search:    strings.TrimSpace(c.Query("q")),
 	}
 }
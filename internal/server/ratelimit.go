@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"music-library/internal/auth"
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+	"music-library/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var httpRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_rate_limited_total",
+	Help: "Requests rejected with 429 by route class.",
+}, []string{"class"})
+
+// rateLimit takes a token from the caller's bucket for class and rejects the
+// request with 429 when it is empty. It goes after requireScope, which
+// identifies the caller.
+func (s *Server) rateLimit(class string) gin.HandlerFunc {
+	if s.limiter == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		if s.allow(c, class) {
+			c.Next()
+		}
+	}
+}
+
+// allow takes a token for class, or aborts the request with 429 and reports
+// false. If the store fails the request is let through.
+func (s *Server) allow(c *gin.Context, class string) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	principal, _ := auth.FromContext(c.Request.Context())
+	return s.take(c, clientOf(principal, c.ClientIP()), class, principal.RateLimits)
+}
+
+// allowSignIn takes a sign-in token for the client IP, ahead of checking the
+// request's credentials.
+func (s *Server) allowSignIn(c *gin.Context) bool {
+	if s.limiter == nil {
+		return true
+	}
+	return s.take(c, "ip:"+c.ClientIP(), ratelimit.SignIn, nil)
+}
+
+func (s *Server) take(c *gin.Context, client, class string, overrides map[string]models.RateLimit) bool {
+	res, err := s.limiter.Allow(c.Request.Context(), client, class, overrides)
+	if err != nil {
+		requestLogger(c).Warn("Can't check rate limit", "class", class, "error", err)
+		return true
+	}
+
+	for name, value := range rateLimitHeaders(res) {
+		c.Header(name, value)
+	}
+	if !res.Allowed {
+		httpRateLimited.WithLabelValues(class).Inc()
+		c.String(http.StatusTooManyRequests, customErrors.ErrRateLimited.Error())
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// clientOf is whom the request counts against: its API key, its token's
+// subject, or for anonymous requests the client IP.
+func clientOf(p auth.Principal, ip string) string {
+	switch {
+	case p.KeyId != 0:
+		return "key:" + strconv.Itoa(p.KeyId)
+	case p.Subject != "":
+		return "user:" + p.Subject
+	default:
+		return "ip:" + ip
+	}
+}
+
+// rateLimitHeaders describe the caller's bucket after res, with when to
+// retry if it was rejected.
+func rateLimitHeaders(res ratelimit.Result) map[string]string {
+	headers := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(res.Limit),
+		"RateLimit-Remaining": strconv.Itoa(res.Remaining),
+		"RateLimit-Reset":     strconv.Itoa(int(res.Reset.Seconds())),
+		"RateLimit-Policy":    fmt.Sprintf("%d;w=%d", res.Limit, int(res.Window.Seconds())),
+	}
+	if !res.Allowed {
+		headers["Retry-After"] = strconv.Itoa(int(res.RetryAfter.Seconds()))
+	}
+	return headers
+}
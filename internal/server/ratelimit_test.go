@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"music-library/internal/auth"
+	"music-library/internal/models"
+)
+
+func TestRateLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit.ReadPerMinute, cfg.RateLimit.ReadBurst = 6, 2
+	h, db := newServer(t, cfg)
+
+	ctx := context.Background()
+	_, limited, err := auth.CreateKey(ctx, db, "limited", []string{auth.ScopeSongsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, raised, err := auth.CreateKey(ctx, db, "raised", []string{auth.ScopeSongsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAPIKeyRateLimits(ctx, strconv.Itoa(key.Id), map[string]models.RateLimit{"read": {PerMinute: 60, Burst: 4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		rec := get(h, "/songs", "192.0.2.1", limited)
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Remaining") != remaining ||
+			rec.Header().Get("RateLimit-Policy") != "2;w=20" || rec.Header().Get("Retry-After") != "" {
+			t.Errorf("request %d = %d %v, want 200 with %s of 2 left", i+1, rec.Code, rec.Header(), remaining)
+		}
+	}
+	rec := get(h, "/songs", "192.0.2.1", limited)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" || rec.Body.String() != "rate limit exceeded" {
+		t.Errorf("request past the burst = %d %q %v, want 429 with Retry-After: 10", rec.Code, rec.Body, rec.Header())
+	}
+
+	// the key with its own limit, from the same IP, isn't held back
+	for i := range 4 {
+		if rec := get(h, "/songs", "192.0.2.1", raised); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "4" {
+			t.Fatalf("request %d with the raised key = %d, limit %s", i+1, rec.Code, rec.Header().Get("RateLimit-Limit"))
+		}
+	}
+	if rec := get(h, "/songs", "192.0.2.1", raised); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("request past the raised limit = %d, want 429", rec.Code)
+	}
+}
+
+// TestSignInRateLimit checks that guessing keys is limited per IP before
+// the keys are looked at.
+func TestSignInRateLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit.SignInPerMinute, cfg.RateLimit.SignInBurst = 1, 2
+	h, db := newServer(t, cfg)
+	_, valid, err := auth.CreateKey(context.Background(), db, "valid", []string{auth.ScopeSongsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, guess := range []string{"ml_guess1", "ml_guess2"} {
+		if rec := get(h, "/songs", "192.0.2.1", guess); rec.Code != http.StatusUnauthorized {
+			t.Errorf("guess %d = %d, want 401", i+1, rec.Code)
+		}
+	}
+	rec := get(h, "/songs", "192.0.2.1", "ml_guess3")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("guess past the sign-in burst = %d %v, want 429 with Retry-After: 60", rec.Code, rec.Header())
+	}
+	if rec := get(h, "/songs", "192.0.2.1", valid); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("valid key from the same IP = %d, want 429 before it is checked", rec.Code)
+	}
+
+	if rec := get(h, "/songs", "192.0.2.2", valid); rec.Code != http.StatusOK {
+		t.Errorf("valid key from another IP = %d, want 200", rec.Code)
+	}
+	// requests without credentials don't take sign-in tokens
+	if rec := get(h, "/songs", "192.0.2.1", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("request without credentials = %d, want 401", rec.Code)
+	}
+}
@@ -1,45 +1,75 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"strconv"
-	"strings"
-	"time"
 
+	"music-library/internal/auth"
 	"music-library/internal/customErrors"
+	"music-library/internal/library"
 	"music-library/internal/models"
-	"music-library/internal/musicapi"
+	"music-library/internal/ratelimit"
 	"music-library/internal/server/query"
 
 	_ "music-library/docs"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerfiles "github.com/swaggo/files"
 	swagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-const verseDelimiter = "\n\n"
-
 func (s *Server) RegisterRoutes() http.Handler {
 	r := gin.New()
+	// config validated the addresses
+	r.SetTrustedProxies(s.trustedProxies)
+	r.Use(otelgin.Middleware(s.serviceName, otelgin.WithFilter(traced)))
+	r.Use(RequestIDMiddleware())
 	r.Use(LoggerMiddleware())
+	r.Use(MetricsMiddleware())
 	r.Use(gin.Recovery())
 
 	r.GET("/docs/*any", swagger.WrapHandler(swaggerfiles.Handler))
 
-	r.GET("/songs", s.GetSongsHandler)
+	r.GET("/healthz", s.HealthHandler)
+
+	r.GET("/readyz", s.ReadyHandler)
 
-	r.GET("/songs/:id", s.GetSongByIdHandler)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	r.GET("/songs/:id/:verse", s.GetSongTextByVerseHandler)
+	read, write, enrich := s.rateLimit(ratelimit.Read), s.rateLimit(ratelimit.Write), s.rateLimit(ratelimit.Enrich)
 
-	r.POST("/songs", s.AddNewSongHandler)
+	viewers := r.Group("", s.requireScope(auth.ScopeSongsRead))
+	viewers.GET("/songs", read, s.GetSongsHandler)
+	viewers.GET("/songs/:id", read, s.GetSongByIdHandler)
+	viewers.GET("/songs/:id/:verse", read, s.GetSongTextByVerseHandler)
+	viewers.GET("/jobs/:id", read, s.GetJobHandler)
+	viewers.GET("/events", read, s.EventsHandler)
+	viewers.GET("/graphql", read, s.GraphQLHandler)
+	viewers.POST("/graphql", read, s.GraphQLHandler)
 
-	r.PUT("/songs/:id", s.UpdateSongHandler)
+	editors := r.Group("", s.requireScope(auth.ScopeSongsWrite))
+	editors.POST("/songs", enrich, s.AddNewSongHandler)
+	editors.PUT("/songs/:id", write, s.UpdateSongHandler)
+	editors.DELETE("/songs/:id", write, s.DeleteSongHandler)
+	editors.POST("/jobs/:id/retry", enrich, s.RetryJobHandler)
 
-	r.DELETE("/songs/:id", s.DeleteSongHandler)
+	admins := r.Group("", s.requireScope(auth.ScopeAdmin))
+	admins.GET("/debug/pool", read, s.PoolStatsHandler)
+	admins.POST("/admin/refresh", enrich, s.RefreshHandler)
+	admins.GET("/admin/songs/:id/refreshes", read, s.GetSongRefreshesHandler)
+	admins.GET("/audit", read, s.GetAuditHandler)
+	admins.POST("/webhooks", write, s.CreateWebhookHandler)
+	admins.GET("/webhooks", read, s.GetWebhooksHandler)
+	admins.GET("/webhooks/:id", read, s.GetWebhookHandler)
+	admins.PUT("/webhooks/:id", write, s.UpdateWebhookHandler)
+	admins.DELETE("/webhooks/:id", write, s.DeleteWebhookHandler)
+	admins.GET("/webhooks/:id/deliveries", read, s.GetWebhookDeliveriesHandler)
+	admins.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", write, s.RedeliverWebhookHandler)
 
 	return r
 }
@@ -53,16 +83,21 @@ func (s *Server) RegisterRoutes() http.Handler {
 // @Param			id	path		int	true	"Song ID"
 // @Success		200	{object}	models.Song
 // @Failure		404	{string}	string	"Song not found"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
 // @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
 // @Router			/songs/{id} [get]
 func (s *Server) GetSongByIdHandler(c *gin.Context) {
-	data, err := s.db.GetSongById(c.Param("id"))
+	data, err := s.library.Song(c.Request.Context(), c.Param("id"))
 	if err != nil {
 		if err == customErrors.ErrNotFound {
 			c.String(http.StatusNotFound, err.Error())
 			return
 		}
-		slog.Debug("GetSongByIdHandler", "error", err.Error())
+		requestLogger(c).Debug("GetSongByIdHandler", "error", err.Error())
 		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
 		return
 	}
@@ -76,31 +111,42 @@ func (s *Server) GetSongByIdHandler(c *gin.Context) {
 // @Accept			json
 // @Produce		json
 // @Param			id		path		int	true	"Song ID"
-// @Param			verse	path		int	true	"Verse number"
+// @Param			verse	path		int	true	"Verse number, from 1"
 // @Success		200		{string}	string
-// @Failure		404		{string}	string	"Song not found"
+// @Failure		400		{string}	string	"Verse isn't a number"
+// @Failure		404		{string}	string	"Song or verse not found"
+// @Failure		401		{string}	string	"Missing or invalid credentials"
+// @Failure		403		{string}	string	"Caller lacks the required scope"
+// @Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
 // @Failure		500		{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
 // @Router			/songs/{id}/{verse} [get]
 func (s *Server) GetSongTextByVerseHandler(c *gin.Context) {
-	data, err := s.db.GetSongById(c.Param("id"))
+	data, err := s.library.Song(c.Request.Context(), c.Param("id"))
 	if err != nil {
 		if err == customErrors.ErrNotFound {
 			c.String(http.StatusNotFound, err.Error())
 			return
 		}
-		slog.Debug("GetSongTextByVerseHandler", "error", err.Error())
+		requestLogger(c).Debug("GetSongTextByVerseHandler", "error", err.Error())
 		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
 		return
 	}
-	text := strings.Split(data.Text, verseDelimiter)
+	number, err := library.ParseVerse(c.Param("verse"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	text, count, err := library.Verse(data, number)
 
-	slog.Info(fmt.Sprintf("Lirics of the song %s has %d verses", data.Song, len(text)))
+	requestLogger(c).Info("Split lyrics into verses", "song", data.Song, "verses", count)
 
-	verse, err := strconv.Atoi(c.Param("verse"))
-	if err != nil || verse > len(text) {
-		verse = 1
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
 	}
-	c.String(http.StatusOK, text[verse-1])
+	c.String(http.StatusOK, text)
 }
 
 // GetSongsHandler
@@ -109,13 +155,31 @@ func (s *Server) GetSongTextByVerseHandler(c *gin.Context) {
 // @Description	Get all songs
 // @Accept			json
 // @Produce		json
-// @Success		200	{object}	[]models.Song
-// @Failure		500	{string}	string	"Internal server error"
+// @Param			group		query		string	false	"Filter by group"
+// @Param			song		query		string	false	"Filter by song name"
+// @Param			releaseDate	query		string	false	"Filter by release date, YYYY-MM-DD or DD.MM.YYYY"
+// @Param			text		query		string	false	"Filter by lyrics"
+// @Param			link		query		string	false	"Filter by link"
+// @Param			q			query		string	false	"Search song titles and lyrics, every word must match"
+// @Param			page		query		int		false	"Page number, from 1"
+// @Param			limit		query		int		false	"Page size, 10 by default"
+// @Success		200			{object}	[]models.Song
+// @Failure		400			{string}	string	"Bad request"
+// @Failure		401			{string}	string	"Missing or invalid credentials"
+// @Failure		403			{string}	string	"Caller lacks the required scope"
+// @Failure		429			{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500			{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
 // @Router			/songs [get]
 func (s *Server) GetSongsHandler(c *gin.Context) {
-	data, err := s.db.GetSongs(query.GetOptions(c))
+	data, err := s.library.Songs(c.Request.Context(), query.GetOptions(c))
 	if err != nil {
-		slog.Debug("GetSongsHandler", "error", err.Error())
+		if errors.Is(err, customErrors.ErrInvalidData) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		requestLogger(c).Debug("GetSongsHandler", "error", err.Error())
 		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
 		return
 	}
@@ -125,14 +189,19 @@ func (s *Server) GetSongsHandler(c *gin.Context) {
 // AddNewSongHandler
 //
 //	@Summary		Add new song
-//	@Description	Add new song
+//	@Description	Add new song. The song is stored right away and enriched from the music info service in the background; poll the returned job for progress.
 //	@ID				id
 //	@Accept			json
 //	@Produce		json
 //	@Param			song	body		models.NewSong	true	"Song"
-//	@Success		200		{string}	string
+//	@Success		202		{object}	models.AcceptedSong
 //	@Failure		400		{string}	string	"Bad request"
+//	@Failure		401		{string}	string	"Missing or invalid credentials"
+//	@Failure		403		{string}	string	"Caller lacks the required scope"
+//	@Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
 //	@Failure		500		{string}	string	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
 //	@Router			/songs [post]
 func (s *Server) AddNewSongHandler(c *gin.Context) {
 	var newSong models.NewSong
@@ -141,20 +210,19 @@ func (s *Server) AddNewSongHandler(c *gin.Context) {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	song, err := musicapi.GetMusicInfo(newSong.Group, newSong.Song)
+	accepted, err := s.library.Create(c.Request.Context(), newSong)
 	if err != nil {
-		slog.Debug("AddNewSongHandler", "error", err.Error())
+		if err == customErrors.ErrInvalidData {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		requestLogger(c).Debug("AddNewSongHandler", "error", err.Error())
 		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
 		return
 	}
 
-	err = s.db.AddNewSong(song)
-	if err != nil {
-		slog.Debug("AddNewSongHandler", "error", err.Error())
-		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
-		return
-	}
-	c.String(http.StatusOK, "Song added")
+	c.Header("Location", fmt.Sprintf("/songs/%d", accepted.Id))
+	c.JSON(http.StatusAccepted, accepted)
 }
 
 // UpdateSongHandler
@@ -167,33 +235,38 @@ func (s *Server) AddNewSongHandler(c *gin.Context) {
 // @Success		200		{string}	string
 // @Failure		400		{string}	string	"Bad request"
 // @Failure		404		{string}	string	"Song not found"
+// @Failure		401		{string}	string	"Missing or invalid credentials"
+// @Failure		403		{string}	string	"Caller lacks the required scope"
+// @Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
 // @Failure		500		{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
 // @Router			/songs/{id} [put]
 func (s *Server) UpdateSongHandler(c *gin.Context) {
 	songID := c.Param("id")
 
-	song, err := s.db.GetSongById(songID)
+	// badRequest is why the body couldn't be applied to the song. The body is
+	// kept by ShouldBindBodyWith, as a retried transaction binds it again.
+	var badRequest error
+	_, err := s.library.Update(c.Request.Context(), songID, func(song *models.Song) error {
+		if badRequest = c.ShouldBindBodyWith(song, binding.JSON); badRequest != nil {
+			return badRequest
+		}
+		if song.Id != 0 && songID != strconv.Itoa(song.Id) {
+			badRequest = errors.New("Wrong id")
+		}
+		return badRequest
+	})
+	if badRequest != nil {
+		c.String(http.StatusBadRequest, badRequest.Error())
+		return
+	}
 	if err != nil {
 		if err == customErrors.ErrNotFound {
 			c.String(http.StatusNotFound, err.Error())
 			return
 		}
-		slog.Debug("UpdateSongHandler", "error", err.Error())
-		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
-		return
-	}
-
-	if err := c.ShouldBindJSON(&song); err != nil {
-		c.String(http.StatusBadRequest, err.Error())
-		return
-	}
-	if song.Id != 0 && songID != strconv.Itoa(song.Id) {
-		c.String(http.StatusBadRequest, "Wrong id")
-		return
-	}
-	err = s.db.UpdateSongById(songID, song)
-	if err != nil {
-		slog.Debug("UpdateSongHandler", "error", err.Error())
+		requestLogger(c).Debug("UpdateSongHandler", "error", err.Error())
 		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
 		return
 	}
@@ -210,27 +283,23 @@ func (s *Server) UpdateSongHandler(c *gin.Context) {
 // @Param			id	path		int	true	"Song ID"
 // @Success		200	{string}	string
 // @Failure		404	{string}	string	"Not found"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
 // @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
 // @Router			/songs/{id} [delete]
 func (s *Server) DeleteSongHandler(c *gin.Context) {
 	songID := c.Param("id")
-	err := s.db.DeleteSongById(songID)
-	if err != nil {
+	if _, err := s.library.Delete(c.Request.Context(), songID); err != nil {
 		if err == customErrors.ErrNotFound {
 			c.String(http.StatusNotFound, err.Error())
 			return
 		}
-		slog.Debug("DeleteSongHandler", "error", err.Error())
+		requestLogger(c).Debug("DeleteSongHandler", "error", err.Error())
 		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
 		return
 	}
 	c.String(http.StatusOK, fmt.Sprintf("Song id:%s deleted", songID))
 }
-
-func LoggerMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now()
-		c.Next()
-		slog.Info(fmt.Sprintf("--> [%s] \"%s\" [%d] %s", c.Request.Method, c.Request.URL, c.Writer.Status(), time.Since(start)))
-	}
-}
@@ -1,32 +1,203 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
 	"time"
 
+	"music-library/internal/auth"
+	"music-library/internal/config"
 	"music-library/internal/database"
+	"music-library/internal/enrichment"
+	"music-library/internal/events"
+	"music-library/internal/gql"
+	"music-library/internal/library"
+	"music-library/internal/musicapi"
+	"music-library/internal/outbox"
+	"music-library/internal/ratelimit"
+	"music-library/internal/webhook"
 
-	_ "github.com/joho/godotenv/autoload"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 type Server struct {
-	port int
+	port           int
+	serviceName    string
+	trustedProxies []string
+	// shutdown is done once the process starts shutting down.
+	shutdown context.Context
+	upstream pinger
 
-	db database.Service
+	db      database.Service
+	library *library.Library
+	graphql *gql.Schema
+	// pool and health are the optional interfaces of the backend db wraps.
+	pool   database.PoolReporter
+	health database.HealthChecker
+	// poolMetrics exports pool, unless the backend has none or another
+	// server registered its own first.
+	poolMetrics *poolCollector
+	info        musicapi.Fetcher
+	// cache is the metadata cache in front of info, unless WithMusicAPI
+	// replaced both.
+	cache      *musicapi.Cache
+	enrichment *enrichment.Pool
+	scheduler  *enrichment.Scheduler
+	webhooks   *webhook.Dispatcher
+	relay      *outbox.Relay
+	events     *events.Broker
+	heartbeat  time.Duration
+	// publisher is where the bus sink sends events.
+	publisher outbox.Publisher
+
+	// grpc is nil unless GRPC_PORT or a listener is set; grpcServed is
+	// closed once its Serve returns.
+	grpc         *grpc.Server
+	grpcHealth   *health.Server
+	grpcServed   chan struct{}
+	grpcListener net.Listener
+
+	// ownDB is set when the server opened db, and closes it in stop.
+	ownDB bool
+
+	auth     config.Auth
+	usage    *auth.Usage
+	verifier *auth.Verifier
+	limiter  *ratelimit.Limiter
 }
 
-func NewServer() *http.Server {
-	port, err := strconv.Atoi(os.Getenv("PORT"))
-	if err != nil {
-		port = 4001
+type Option func(*Server)
+
+// WithDatabase makes the server use db instead of the configured database.
+func WithDatabase(db database.Service) Option {
+	return func(s *Server) {
+		s.db = db
+	}
+}
+
+// WithMusicAPI replaces the cached music info client used for enrichment.
+func WithMusicAPI(info musicapi.Fetcher) Option {
+	return func(s *Server) {
+		s.info = info
+	}
+}
+
+// WithPublisher makes the bus outbox sink publish to p, e.g. a NATS
+// connection, instead of an in-process bus.
+func WithPublisher(p outbox.Publisher) Option {
+	return func(s *Server) {
+		s.publisher = p
 	}
+}
+
+// WithGRPCListener serves the gRPC API on lis instead of GRPC_PORT.
+func WithGRPCListener(lis net.Listener) Option {
+	return func(s *Server) {
+		s.grpcListener = lis
+	}
+}
+
+// WithShutdown fails readiness as soon as ctx is done, ahead of the
+// http.Server shutting down.
+func WithShutdown(ctx context.Context) Option {
+	return func(s *Server) {
+		s.shutdown = ctx
+	}
+}
+
+// NewServer builds the API server and starts its background workers. The
+// returned stop func ends them and closes the database; call it after the
+// http.Server has shut down.
+func NewServer(cfg config.Config, opts ...Option) (*http.Server, func(ctx context.Context) error, error) {
 	NewServer := &Server{
-		port: port,
+		port:           cfg.Server.Port,
+		serviceName:    cfg.Tracing.ServiceName,
+		trustedProxies: cfg.Server.TrustedProxies,
+		shutdown:       context.Background(),
+		auth:           cfg.Auth,
+	}
+	for _, opt := range opts {
+		opt(NewServer)
+	}
+	built := false
+	if cfg.Auth.Enabled && cfg.Auth.OIDC.JWKS != "" {
+		verifier, err := auth.NewVerifier(context.Background(), cfg.Auth.OIDC)
+		if err != nil {
+			return nil, nil, err
+		}
+		NewServer.verifier = verifier
+	}
+	if NewServer.db == nil {
+		db, err := database.New(cfg.Database)
+		if err != nil {
+			return nil, nil, err
+		}
+		NewServer.db = db
+		NewServer.ownDB = true
+		// nothing runs until the server is built, so one failing to build
+		// only has what it opened to close
+		defer func() {
+			if !built {
+				db.Close()
+			}
+		}()
+	}
+	if cfg.RateLimit.Enabled {
+		var store ratelimit.Store = ratelimit.NewMemoryStore()
+		if cfg.RateLimit.Store == "postgres" {
+			buckets, ok := NewServer.db.(database.TokenBuckets)
+			if !ok {
+				return nil, nil, fmt.Errorf("database can't store rate limit buckets")
+			}
+			store = buckets
+		}
+		NewServer.limiter = ratelimit.New(store, cfg.RateLimit)
+	}
+	NewServer.pool, _ = NewServer.db.(database.PoolReporter)
+	NewServer.health, _ = NewServer.db.(database.HealthChecker)
+	NewServer.db = database.Instrument(NewServer.db)
 
-		db: database.New(),
+	if NewServer.info == nil {
+		NewServer.cache = musicapi.NewCache(musicapi.New(cfg.MusicAPI), NewServer.db, cfg.Cache)
+		NewServer.info = NewServer.cache
+	}
+	if p, ok := NewServer.info.(pinger); ok && cfg.Server.ReadyCheckUpstream {
+		NewServer.upstream = p
+	}
+	NewServer.enrichment = enrichment.NewPool(NewServer.db, NewServer.info, cfg.Enrichment.Workers)
+	NewServer.webhooks = webhook.NewDispatcher(NewServer.db, cfg.Webhooks)
+	sinks, err := NewServer.outboxSinks(cfg.Outbox)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if built {
+			return
+		}
+		for _, sink := range sinks {
+			if c, ok := sink.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+	NewServer.relay = outbox.NewRelay(NewServer.db, cfg.Outbox, sinks...)
+	NewServer.events = events.NewBroker(NewServer.db, cfg.Events, cfg.Outbox.PollInterval)
+	NewServer.heartbeat = cfg.Events.Heartbeat
+	NewServer.relay.OnSequenced(NewServer.events.Notify)
+	NewServer.library = library.New(NewServer.db, NewServer.enrichment, NewServer.relay)
+	if NewServer.graphql, err = gql.New(NewServer.library, cfg.GraphQL); err != nil {
+		return nil, nil, err
+	}
+	NewServer.usage = auth.NewUsage(NewServer.db, cfg.Auth.UsageFlushInterval)
+	if !cfg.Auth.Enabled {
+		slog.Warn("Authentication is disabled, every route is open")
 	}
 
 	server := &http.Server{
@@ -37,5 +208,98 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	if cfg.Server.GRPCPort != 0 || NewServer.grpcListener != nil {
+		if err := NewServer.serveGRPC(cfg.Server); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	NewServer.scheduler = enrichment.NewScheduler(NewServer.enrichment, cfg.Enrichment.RefreshInterval, cfg.Enrichment.RefreshMaxAge)
+
+	NewServer.enrichment.Start()
+	NewServer.scheduler.Start()
+	NewServer.events.Start()
+	NewServer.relay.Start()
+	NewServer.webhooks.Start()
+	NewServer.usage.Start()
+	if NewServer.cache != nil {
+		NewServer.cache.Start()
+	}
+	if NewServer.limiter != nil {
+		NewServer.limiter.Start()
+	}
+	if NewServer.pool != nil {
+		NewServer.poolMetrics = &poolCollector{pool: NewServer.pool}
+		if err := prometheus.Register(NewServer.poolMetrics); err != nil {
+			// another server in the process already exports its pool
+			slog.Warn("Not exporting database pool metrics", "error", err)
+			NewServer.poolMetrics = nil
+		}
+	}
+	built = true
+	return server, NewServer.stop, nil
+}
+
+// stop ends the gRPC server and the background workers, waiting for the
+// calls, jobs, deliveries and flushes they are in the middle of, then closes
+// the database. If ctx ends first the database is left open for the workers
+// still running.
+func (s *Server) stop(ctx context.Context) error {
+	if s.poolMetrics != nil {
+		prometheus.Unregister(s.poolMetrics)
+	}
+	if s.grpc != nil {
+		s.stopGRPC(ctx)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.scheduler.Stop()
+		s.enrichment.Stop()
+		s.relay.Stop()
+		s.events.Stop()
+		s.webhooks.Stop()
+		s.usage.Stop()
+		if s.cache != nil {
+			s.cache.Stop()
+		}
+		if s.limiter != nil {
+			s.limiter.Stop()
+		}
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("stopping workers: %w", ctx.Err())
+	}
+
+	if s.ownDB {
+		return s.db.Close()
+	}
+	return nil
+}
+
+func (s *Server) outboxSinks(cfg config.Outbox) ([]outbox.Sink, error) {
+	var sinks []outbox.Sink
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "webhooks":
+			sinks = append(sinks, webhook.NewSink(s.webhooks))
+		case "stdout":
+			sinks = append(sinks, outbox.NewWriterSink("stdout", os.Stdout))
+		case "file":
+			sink, err := outbox.NewFileSink(cfg.File)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "bus":
+			if s.publisher == nil {
+				s.publisher = outbox.NewBus()
+			}
+			sinks = append(sinks, outbox.NewPublisherSink(s.publisher, cfg.SubjectPrefix))
+		}
+	}
+	return sinks, nil
 }
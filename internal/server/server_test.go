@@ -0,0 +1,64 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/server"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testConfig serves no gRPC and has auth and rate limits on.
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.Server.GRPCPort = 0
+	cfg.Auth.Enabled = true
+	cfg.RateLimit.Enabled = true
+	return cfg
+}
+
+// newServer builds the server on a memory database, stopped when the test
+// ends.
+func newServer(t *testing.T, cfg config.Config, opts ...server.Option) (http.Handler, database.Service) {
+	t.Helper()
+	db := database.NewMemory()
+	srv, stop, err := server.NewServer(cfg, append([]server.Option{server.WithDatabase(db)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { stop(context.Background()) })
+	return srv.Handler, db
+}
+
+// get serves a GET of path from ip, with key in X-API-Key if set.
+func get(h http.Handler, path, ip, key string) *httptest.ResponseRecorder {
+	return serve(h, httptest.NewRequest(http.MethodGet, path, nil), ip, key)
+}
+
+// post serves a POST of the JSON body to path from ip, with key in
+// X-API-Key if set.
+func post(h http.Handler, path, body, ip, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return serve(h, req, ip, key)
+}
+
+func serve(h http.Handler, req *http.Request, ip, key string) *httptest.ResponseRecorder {
+	req.RemoteAddr = ip + ":40000"
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
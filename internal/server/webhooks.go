@@ -0,0 +1,319 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"music-library/internal/audit"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/server/query"
+	"music-library/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateWebhookHandler
+//
+// @Summary		Create webhook
+// @Description	Subscribe a URL to song.created, song.updated and song.deleted events. The response holds the signing secret, which isn't shown again.
+// @Accept			json
+// @Produce		json
+// @Param			webhook	body		models.WebhookRequest	true	"Webhook"
+// @Success		201		{object}	models.Webhook
+// @Failure		400		{string}	string	"Bad request"
+// @Failure		401		{string}	string	"Missing or invalid credentials"
+// @Failure		403		{string}	string	"Caller lacks the required scope"
+// @Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500		{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks [post]
+func (s *Server) CreateWebhookHandler(c *gin.Context) {
+	hook, ok := bindWebhook(c)
+	if !ok {
+		return
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		requestLogger(c).Debug("CreateWebhookHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	hook.Secret = secret
+
+	ctx := c.Request.Context()
+	var created models.Webhook
+	err = s.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		if created, err = tx.CreateWebhook(ctx, hook); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, audit.Webhook, created.Id, nil, withoutSecret(created))
+	})
+	if err != nil {
+		requestLogger(c).Debug("CreateWebhookHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetWebhooksHandler
+//
+// @Summary		Get all webhooks
+// @Description	Get all webhooks, without their secrets
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	[]models.Webhook
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks [get]
+func (s *Server) GetWebhooksHandler(c *gin.Context) {
+	hooks, err := s.db.ListWebhooks(c.Request.Context())
+	if err != nil {
+		requestLogger(c).Debug("GetWebhooksHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	for i := range hooks {
+		hooks[i] = withoutSecret(hooks[i])
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// GetWebhookHandler
+//
+// @Summary		Get webhook by id
+// @Description	Get webhook by id, without its secret
+// @Accept			json
+// @Produce		json
+// @Param			id	path		int	true	"Webhook ID"
+// @Success		200	{object}	models.Webhook
+// @Failure		404	{string}	string	"Webhook not found"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks/{id} [get]
+func (s *Server) GetWebhookHandler(c *gin.Context) {
+	hook, err := s.db.GetWebhook(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, customErrors.ErrWebhookNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("GetWebhookHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.JSON(http.StatusOK, withoutSecret(hook))
+}
+
+// UpdateWebhookHandler
+//
+// @Summary		Update webhook
+// @Description	Replace a webhook's settings. With rotateSecret the response holds the new signing secret.
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int						true	"Webhook ID"
+// @Param			webhook	body		models.WebhookRequest	true	"Webhook"
+// @Success		200		{object}	models.Webhook
+// @Failure		400		{string}	string	"Bad request"
+// @Failure		404		{string}	string	"Webhook not found"
+// @Failure		401		{string}	string	"Missing or invalid credentials"
+// @Failure		403		{string}	string	"Caller lacks the required scope"
+// @Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500		{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks/{id} [put]
+func (s *Server) UpdateWebhookHandler(c *gin.Context) {
+	hook, ok := bindWebhook(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var updated models.Webhook
+	err := s.db.WithTx(ctx, func(tx database.Service) error {
+		before, err := tx.GetWebhook(ctx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if updated, err = tx.UpdateWebhook(ctx, c.Param("id"), hook); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.Webhook, updated.Id, withoutSecret(before), withoutSecret(updated))
+	})
+	if err != nil {
+		if errors.Is(err, customErrors.ErrWebhookNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("UpdateWebhookHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	if hook.Secret == "" {
+		updated = withoutSecret(updated)
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteWebhookHandler
+//
+// @Summary		Delete webhook
+// @Description	Delete a webhook together with its delivery log
+// @Accept			json
+// @Produce		json
+// @Param			id	path		int	true	"Webhook ID"
+// @Success		204
+// @Failure		404	{string}	string	"Webhook not found"
+// @Failure		401	{string}	string	"Missing or invalid credentials"
+// @Failure		403	{string}	string	"Caller lacks the required scope"
+// @Failure		429	{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500	{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks/{id} [delete]
+func (s *Server) DeleteWebhookHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	err := s.db.WithTx(ctx, func(tx database.Service) error {
+		before, err := tx.GetWebhook(ctx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteWebhook(ctx, c.Param("id")); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Delete, audit.Webhook, before.Id, withoutSecret(before), nil)
+	})
+	if err != nil {
+		if errors.Is(err, customErrors.ErrWebhookNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("DeleteWebhookHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetWebhookDeliveriesHandler
+//
+// @Summary		Get webhook deliveries
+// @Description	Get the delivery log of a webhook, newest first
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int	true	"Webhook ID"
+// @Param			page	query		int	false	"Page number, from 1"
+// @Param			limit	query		int	false	"Page size, 10 by default"
+// @Success		200		{object}	[]models.WebhookDelivery
+// @Failure		400		{string}	string	"Bad request"
+// @Failure		404		{string}	string	"Webhook not found"
+// @Failure		401		{string}	string	"Missing or invalid credentials"
+// @Failure		403		{string}	string	"Caller lacks the required scope"
+// @Failure		429		{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500		{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks/{id}/deliveries [get]
+func (s *Server) GetWebhookDeliveriesHandler(c *gin.Context) {
+	limit, offset := query.GetPaginator(c).Bounds()
+	deliveries, err := s.db.ListDeliveries(c.Request.Context(), c.Param("id"), limit, offset)
+	if err != nil {
+		if errors.Is(err, customErrors.ErrWebhookNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("GetWebhookDeliveriesHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverWebhookHandler
+//
+// @Summary		Redeliver webhook event
+// @Description	Queue the payload of an earlier delivery to be sent again as a new delivery with a fresh set of attempts
+// @Accept			json
+// @Produce		json
+// @Param			id			path		int	true	"Webhook ID"
+// @Param			deliveryId	path		int	true	"Delivery ID"
+// @Success		202			{object}	models.WebhookDelivery
+// @Failure		404			{string}	string	"Delivery not found"
+// @Failure		401			{string}	string	"Missing or invalid credentials"
+// @Failure		403			{string}	string	"Caller lacks the required scope"
+// @Failure		429			{string}	string	"Rate limit exceeded, see Retry-After"
+// @Failure		500			{string}	string	"Internal server error"
+// @Security		ApiKeyAuth
+// @Security		BearerAuth
+// @Router			/webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (s *Server) RedeliverWebhookHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	var delivery models.WebhookDelivery
+	err := s.db.WithTx(ctx, func(tx database.Service) error {
+		var err error
+		if delivery, err = tx.Redeliver(ctx, c.Param("id"), c.Param("deliveryId")); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, audit.Delivery, delivery.Id, nil, delivery)
+	})
+	if err != nil {
+		if errors.Is(err, customErrors.ErrDeliveryNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Debug("RedeliverWebhookHandler", "error", err.Error())
+		c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+		return
+	}
+	s.webhooks.Notify()
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// bindWebhook reads and checks a webhook request body, answering the
+// request itself if it is invalid.
+func bindWebhook(c *gin.Context) (models.Webhook, bool) {
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return models.Webhook{}, false
+	}
+
+	hook := models.Webhook{
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		Active:      req.Active == nil || *req.Active,
+	}
+	if err := webhook.Validate(hook); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return models.Webhook{}, false
+	}
+	if req.RotateSecret {
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			requestLogger(c).Debug("bindWebhook", "error", err.Error())
+			c.String(http.StatusInternalServerError, customErrors.ErrISE.Error())
+			return models.Webhook{}, false
+		}
+		hook.Secret = secret
+	}
+	return hook, true
+}
+
+func withoutSecret(hook models.Webhook) models.Webhook {
+	hook.Secret = ""
+	return hook
+}
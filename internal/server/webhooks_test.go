@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"music-library/internal/audit"
+	"music-library/internal/auth"
+	"music-library/internal/models"
+	"music-library/internal/outbox"
+)
+
+func TestRedeliverAudited(t *testing.T) {
+	h, db := newServer(t, testConfig())
+	ctx := context.Background()
+	_, key, err := auth.CreateKey(ctx, db, "ops", []string{auth.ScopeAdmin})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	hook, err := db.CreateWebhook(ctx, models.Webhook{URL: "http://127.0.0.1:1/hook", Events: outbox.Events, Active: true, Secret: "whsec_test"})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if _, err := db.EnqueueWebhookEvent(ctx, models.Event{Id: "evt-1", Type: outbox.SongCreated, CreatedAt: time.Now(), Data: []byte("{}")}); err != nil {
+		t.Fatalf("EnqueueWebhookEvent: %v", err)
+	}
+	deliveries, err := db.ListDeliveries(ctx, strconv.Itoa(hook.Id), 10, 0)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("ListDeliveries = %+v, %v", deliveries, err)
+	}
+
+	redeliver := func(deliveryId int) *httptest.ResponseRecorder {
+		return post(h, fmt.Sprintf("/webhooks/%d/deliveries/%d/redeliver", hook.Id, deliveryId), "", "192.0.2.1", key)
+	}
+
+	rec := redeliver(deliveries[0].Id)
+	var again models.WebhookDelivery
+	if rec.Code != http.StatusAccepted || json.Unmarshal(rec.Body.Bytes(), &again) != nil {
+		t.Fatalf("redeliver = %d %s, want 202 with the new delivery", rec.Code, rec.Body)
+	}
+	entries, err := db.ListAudit(ctx, models.AuditFilter{Resource: audit.Delivery, Limit: 10})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ListAudit = %+v, %v; want the redelivery", entries, err)
+	}
+	if e := entries[0]; e.Action != audit.Create || e.ResourceId != strconv.Itoa(again.Id) || e.Actor == "" || e.SourceIP != "192.0.2.1" || e.Before != nil {
+		t.Errorf("audit entry = %+v, want the creation of delivery %d by the key", e, again.Id)
+	}
+
+	// a delivery that doesn't exist leaves nothing behind
+	if rec := redeliver(again.Id + 1); rec.Code != http.StatusNotFound {
+		t.Errorf("redeliver of a missing delivery = %d, want 404", rec.Code)
+	}
+	if entries, _ := db.ListAudit(ctx, models.AuditFilter{Resource: audit.Delivery, Limit: 10}); len(entries) != 1 {
+		t.Errorf("audit has %d redeliveries, want 1", len(entries))
+	}
+}
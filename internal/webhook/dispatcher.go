@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/retry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	pollInterval = 2 * time.Second
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+	// maxErrorBody is how much of a failed response is kept in the
+	// delivery log.
+	maxErrorBody = 512
+)
+
+var deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_deliveries_total",
+	Help: "Webhook delivery attempts by outcome: succeeded, retry or failed.",
+}, []string{"outcome"})
+
+// Dispatcher sends queued deliveries on a fixed number of workers. A
+// delivery is leased while it is sent, so one left by a dead worker goes
+// out again once the lease expires. Receivers should dedupe on
+// X-Webhook-Event-Id, which redeliveries share.
+type Dispatcher struct {
+	db          database.Service
+	http        *http.Client
+	workers     int
+	maxAttempts int
+	lease       time.Duration
+	backoff     func(attempt int) time.Duration
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(db database.Service, cfg config.Webhooks) *Dispatcher {
+	return &Dispatcher{
+		db: db,
+		http: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   cfg.Timeout,
+		},
+		workers:     max(cfg.Workers, 1),
+		maxAttempts: cfg.MaxAttempts,
+		lease:       2 * cfg.Timeout,
+		backoff:     backoff,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.work(ctx)
+	}
+	slog.Info("Webhook workers started", "workers", d.workers)
+}
+
+func (d *Dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+	slog.Info("Webhook workers stopped")
+}
+
+// Notify wakes an idle worker so a freshly queued delivery doesn't wait for
+// the next poll.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	defer d.wg.Done()
+
+	for ctx.Err() == nil {
+		delivery, err := d.db.ClaimDelivery(ctx, d.lease)
+		if err == nil {
+			d.deliver(ctx, delivery)
+			continue
+		}
+		if !errors.Is(err, customErrors.ErrNoDeliveries) && ctx.Err() == nil {
+			slog.Error("Can't claim webhook delivery", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-d.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	logger := slog.With("webhook", delivery.WebhookId, "delivery", delivery.Id, "event", delivery.EventType)
+
+	status, err := d.send(ctx, delivery)
+
+	// the outcome is recorded even if shutdown started meanwhile, otherwise
+	// the delivery goes out again once the lease expires
+	done := context.WithoutCancel(ctx)
+	if err == nil {
+		deliveries.WithLabelValues("succeeded").Inc()
+		if err := d.db.CompleteDelivery(done, delivery.Id, status); err != nil {
+			logger.Error("Can't complete webhook delivery", "error", err)
+		}
+		return
+	}
+	if errors.Is(err, customErrors.ErrWebhookNotFound) {
+		return
+	}
+
+	var retryAt time.Time
+	if delivery.Attempts < d.maxAttempts && !errors.Is(err, errInactive) {
+		retryAt = time.Now().Add(d.backoff(delivery.Attempts))
+	}
+	failed, ferr := d.db.FailDelivery(done, delivery.Id, status, err.Error(), retryAt)
+	if ferr != nil {
+		logger.Error("Can't record webhook delivery failure", "error", ferr)
+		return
+	}
+	outcome := "retry"
+	if failed.Status == models.DeliveryFailed {
+		outcome = "failed"
+	}
+	deliveries.WithLabelValues(outcome).Inc()
+	logger.Warn("Webhook delivery failed", "attempt", delivery.Attempts, "status", failed.Status, "error", err)
+}
+
+var errInactive = errors.New("webhook is inactive")
+
+// send posts the payload and returns the response status, 0 if there was
+// no response. Only 2xx responses count as delivered.
+func (d *Dispatcher) send(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+	hook, err := d.db.GetWebhook(ctx, strconv.Itoa(delivery.WebhookId))
+	if err != nil {
+		return 0, err
+	}
+	if !hook.Active {
+		return 0, errInactive
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "music-library-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.Itoa(hook.Id))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Event-Id", delivery.EventId)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.Id))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", Sign(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	return resp.StatusCode, nil
+}
+
+func backoff(attempt int) time.Duration {
+	return retry.Backoff(attempt, baseBackoff, maxBackoff)
+}
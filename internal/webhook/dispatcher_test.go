@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"music-library/internal/config"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/outbox"
+)
+
+// receiver answers 500 to the first failures requests it gets and 204 to
+// the rest, keeping what it was sent.
+type receiver struct {
+	failures int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if len(r.requests) <= r.failures {
+		http.Error(w, "try later", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newDispatcher makes a dispatcher retrying at once, with a webhook to the
+// receiver subscribed to song.created. It is stopped when the test ends.
+func newDispatcher(t *testing.T, rcv *receiver, maxAttempts int) (*Dispatcher, database.Service, models.Webhook) {
+	t.Helper()
+	srv := httptest.NewServer(rcv)
+	t.Cleanup(srv.Close)
+
+	db := database.NewMemory()
+	hook, err := db.CreateWebhook(context.Background(), models.Webhook{
+		URL: srv.URL, Events: []string{outbox.SongCreated}, Active: true, Secret: "whsec_test",
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	d := NewDispatcher(db, config.Webhooks{Workers: 2, MaxAttempts: maxAttempts, Timeout: time.Second})
+	d.backoff = func(int) time.Duration { return 0 }
+	t.Cleanup(d.Stop)
+	return d, db, hook
+}
+
+func enqueue(t *testing.T, db database.Service) models.Event {
+	t.Helper()
+	event := models.Event{Id: "evt-1", Type: outbox.SongCreated, CreatedAt: time.Now(), Data: []byte(`{"id":1}`)}
+	if n, err := db.EnqueueWebhookEvent(context.Background(), event); err != nil || n != 1 {
+		t.Fatalf("EnqueueWebhookEvent = %d, %v; want 1 delivery", n, err)
+	}
+	return event
+}
+
+// waitForDelivery polls the webhook's only delivery until it reaches status.
+func waitForDelivery(t *testing.T, db database.Service, hook models.Webhook, status string) models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := db.ListDeliveries(context.Background(), strconv.Itoa(hook.Id), 10, 0)
+		if err != nil || len(deliveries) != 1 {
+			t.Fatalf("ListDeliveries = %+v, %v; want 1 delivery", deliveries, err)
+		}
+		if deliveries[0].Status == status {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery is still %+v", deliveries[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	rcv := &receiver{failures: 2}
+	d, db, hook := newDispatcher(t, rcv, 5)
+	event := enqueue(t, db)
+	d.Start()
+
+	delivery := waitForDelivery(t, db, hook, models.DeliverySucceeded)
+	if delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusNoContent || delivery.DeliveredAt == nil {
+		t.Errorf("delivery = %+v, want delivered with a 204 on the third attempt", delivery)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(rcv.requests))
+	}
+	for i, req := range rcv.requests {
+		h := req.Header
+		if h.Get("X-Webhook-Event-Id") != event.Id || h.Get("X-Webhook-Event") != outbox.SongCreated || h.Get("X-Webhook-Delivery") != strconv.Itoa(delivery.Id) {
+			t.Errorf("request %d has headers %v", i+1, h)
+		}
+		if want := Sign("whsec_test", h.Get("X-Webhook-Timestamp"), rcv.bodies[i]); h.Get("X-Webhook-Signature") != want {
+			t.Errorf("request %d is signed %q, want %q", i+1, h.Get("X-Webhook-Signature"), want)
+		}
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	rcv := &receiver{failures: 10}
+	d, db, hook := newDispatcher(t, rcv, 2)
+	enqueue(t, db)
+	d.Start()
+
+	delivery := waitForDelivery(t, db, hook, models.DeliveryFailed)
+	if delivery.Attempts != 2 || delivery.ResponseStatus != http.StatusInternalServerError || delivery.LastError != "unexpected status 500: try later" {
+		t.Errorf("delivery = %+v, want failed after 2 attempts with the last response", delivery)
+	}
+}
+
+func TestDispatcherInactive(t *testing.T) {
+	rcv := &receiver{}
+	d, db, hook := newDispatcher(t, rcv, 5)
+	enqueue(t, db)
+	hook.Active = false
+	if _, err := db.UpdateWebhook(context.Background(), strconv.Itoa(hook.Id), hook); err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+	d.Start()
+
+	delivery := waitForDelivery(t, db, hook, models.DeliveryFailed)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if delivery.Attempts != 1 || len(rcv.requests) != 0 {
+		t.Errorf("delivery to an inactive webhook = %+v after %d requests, want failed without sending", delivery, len(rcv.requests))
+	}
+}
@@ -0,0 +1,85 @@
+// Package webhook tells subscribers about song changes. The Sink queues a
+// delivery per subscribed webhook for each outbox event, and the Dispatcher
+// sends them.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/database"
+	"music-library/internal/models"
+	"music-library/internal/outbox"
+)
+
+const secretPrefix = "whsec_"
+
+// NewSecret makes a secret to sign a webhook's payloads with.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sink queues the deliveries of outbox events.
+type Sink struct {
+	dispatcher *Dispatcher
+}
+
+func NewSink(dispatcher *Dispatcher) *Sink {
+	return &Sink{dispatcher: dispatcher}
+}
+
+func (s *Sink) Name() string { return "webhooks" }
+
+// Publish queues the deliveries through tx, so every event is fanned out
+// exactly once.
+func (s *Sink) Publish(ctx context.Context, tx database.Service, events []models.Event) error {
+	for _, event := range events {
+		if _, err := tx.EnqueueWebhookEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Committed wakes the dispatcher for the deliveries just queued.
+func (s *Sink) Committed() {
+	s.dispatcher.Notify()
+}
+
+// Sign returns the X-Webhook-Signature of a payload sent at timestamp, the
+// HMAC-SHA256 of "timestamp.body" keyed with the webhook's secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Validate checks the settings of a webhook about to be stored.
+func Validate(hook models.Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", customErrors.ErrInvalidData)
+	}
+	if len(hook.Events) == 0 {
+		return fmt.Errorf("%w: events must not be empty", customErrors.ErrInvalidData)
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(outbox.Events, event) {
+			return fmt.Errorf("%w: unknown event %q, want one of %s", customErrors.ErrInvalidData, event, strings.Join(outbox.Events, ", "))
+		}
+	}
+	return nil
+}
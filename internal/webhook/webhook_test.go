@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+
+	"music-library/internal/customErrors"
+	"music-library/internal/models"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret, timestamp, body string
+		want                    string
+	}{
+		{"whsec_test", "1700000000", `{"id":"evt-1"}`, "sha256=5056f09710e0bebdbcd623bb1a7714db4eac94f18745b31b96dd55a69f444e14"},
+		{"whsec_test", "1700000000", "", "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+
+	// the timestamp is signed too, so a replayed body can't be restamped
+	if Sign("whsec_test", "1700000001", []byte(`{"id":"evt-1"}`)) == tests[0].want {
+		t.Errorf("Sign ignores the timestamp")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		hook models.Webhook
+		ok   bool
+	}{
+		{"valid", models.Webhook{URL: "https://example.com/hook", Events: []string{"song.created"}}, true},
+		{"relative url", models.Webhook{URL: "/hook", Events: []string{"song.created"}}, false},
+		{"other scheme", models.Webhook{URL: "ftp://example.com/hook", Events: []string{"song.created"}}, false},
+		{"no events", models.Webhook{URL: "https://example.com/hook"}, false},
+		{"unknown event", models.Webhook{URL: "https://example.com/hook", Events: []string{"song.played"}}, false},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.hook)
+		if tt.ok != (err == nil) || (err != nil && !errors.Is(err, customErrors.ErrInvalidData)) {
+			t.Errorf("%s: Validate = %v", tt.name, err)
+		}
+	}
+}
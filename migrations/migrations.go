@@ -0,0 +1,9 @@
+// Package migrations holds the schema migrations of every SQL backend, one
+// directory per driver, embedded so binaries don't depend on the working
+// directory.
+package migrations
+
+import "embed"
+
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
@@ -7,7 +7,7 @@ CREATE TABLE IF NOT EXISTS artists (
 
 CREATE TABLE IF NOT EXISTS songs (
 	id serial PRIMARY KEY,
-	artist_id int FOREIGN KEY references artists(id),
+	artist_id int REFERENCES artists(id),
 	song varchar(100) not null,
 	release_date date not null,
 	lirycs text not null,
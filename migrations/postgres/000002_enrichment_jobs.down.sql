@@ -0,0 +1,6 @@
+DROP TABLE jobs;
+ALTER TABLE songs DROP COLUMN enrichment_status;
+ALTER TABLE songs ALTER COLUMN link DROP DEFAULT;
+ALTER TABLE songs ALTER COLUMN lirycs DROP DEFAULT;
+UPDATE songs SET release_date = now() WHERE release_date IS NULL;
+ALTER TABLE songs ALTER COLUMN release_date SET NOT NULL;
@@ -0,0 +1,20 @@
+ALTER TABLE songs ALTER COLUMN release_date DROP NOT NULL;
+ALTER TABLE songs ALTER COLUMN lirycs SET DEFAULT '';
+ALTER TABLE songs ALTER COLUMN link SET DEFAULT '';
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS enrichment_status varchar(20) not null default 'pending';
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id serial PRIMARY KEY,
+	kind varchar(50) not null,
+	song_id int not null references songs(id) ON DELETE CASCADE,
+	status varchar(20) not null default 'queued',
+	attempts int not null default 0,
+	max_attempts int not null default 5,
+	last_error text not null default '',
+	run_at timestamptz not null default now(),
+	locked_until timestamptz,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS jobs_ready_idx ON jobs(run_at, id) WHERE status IN ('queued', 'running');
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS metadata_cache (
+	key text PRIMARY KEY,
+	release_date date,
+	lirycs text not null default '',
+	link varchar(200) not null default '',
+	not_found boolean not null default false,
+	expires_at timestamptz not null,
+	updated_at timestamptz not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS metadata_cache_expires_at_idx ON metadata_cache(expires_at);
@@ -0,0 +1,4 @@
+DROP TABLE song_refreshes;
+DROP INDEX IF EXISTS songs_enriched_at_idx;
+ALTER TABLE songs DROP COLUMN edited_fields;
+ALTER TABLE songs DROP COLUMN enriched_at;
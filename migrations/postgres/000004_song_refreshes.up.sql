@@ -0,0 +1,15 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS enriched_at timestamptz;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS edited_fields text[] not null default '{}';
+
+UPDATE songs SET enriched_at = now() WHERE enrichment_status = 'done' AND enriched_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS songs_enriched_at_idx ON songs(enriched_at);
+
+CREATE TABLE IF NOT EXISTS song_refreshes (
+	id serial PRIMARY KEY,
+	song_id int not null references songs(id) ON DELETE CASCADE,
+	changes jsonb not null,
+	created_at timestamptz not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS song_refreshes_song_id_idx ON song_refreshes(song_id);
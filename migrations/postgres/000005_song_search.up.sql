@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS songs_search_idx ON songs USING gin (to_tsvector('simple', song || ' ' || lirycs));
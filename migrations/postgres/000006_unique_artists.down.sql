@@ -0,0 +1 @@
+ALTER TABLE artists DROP CONSTRAINT IF EXISTS artists_artist_key;
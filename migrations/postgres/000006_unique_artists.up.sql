@@ -0,0 +1,9 @@
+-- songs of duplicate artists move to the oldest row before the rest go
+UPDATE songs SET artist_id = keep.id
+FROM artists dup
+JOIN (SELECT artist, min(id) AS id FROM artists GROUP BY artist) keep ON keep.artist = dup.artist
+WHERE songs.artist_id = dup.id AND dup.id <> keep.id;
+
+DELETE FROM artists a USING artists b WHERE a.artist = b.artist AND a.id > b.id;
+
+ALTER TABLE artists ADD CONSTRAINT artists_artist_key UNIQUE (artist);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id serial PRIMARY KEY,
+	name varchar(100) not null,
+	prefix varchar(20) not null,
+	-- sha256 of the whole key, the key itself is never stored
+	key_hash char(64) not null UNIQUE,
+	scopes text[] not null default '{}',
+	created_at timestamptz not null default now(),
+	last_used_at timestamptz,
+	request_count bigint not null default 0,
+	revoked_at timestamptz
+);
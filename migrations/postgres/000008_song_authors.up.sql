@@ -0,0 +1,3 @@
+-- who added and last edited a song, empty when auth was off
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS created_by text not null default '';
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS updated_by text not null default '';
@@ -0,0 +1,2 @@
+DROP TABLE rate_limit_buckets;
+ALTER TABLE api_keys DROP COLUMN rate_limits;
@@ -0,0 +1,13 @@
+-- per class overrides of the configured limits, {"read": {"perMinute": 1200, "burst": 200}}
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limits jsonb not null default '{}';
+
+-- token buckets shared by every instance; losing them in a crash only
+-- refills them early, so they skip the WAL
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+	key text PRIMARY KEY,
+	tokens double precision not null,
+	allowed boolean not null,
+	updated_at timestamptz not null
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_at_idx ON rate_limit_buckets(updated_at);
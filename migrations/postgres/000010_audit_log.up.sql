@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id bigserial PRIMARY KEY,
+	at timestamptz not null default now(),
+	actor text not null,
+	request_id text not null default '',
+	source_ip text not null default '',
+	action text not null,
+	resource text not null,
+	-- text and no foreign key, entries outlive what they describe
+	resource_id text not null,
+	before jsonb,
+	after jsonb
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_resource_idx ON audit_log(resource, resource_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log(actor);
+CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log(at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
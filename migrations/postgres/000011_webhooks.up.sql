@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id serial PRIMARY KEY,
+	url text not null,
+	events text[] not null,
+	description text not null default '',
+	secret text not null,
+	active boolean not null default true,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	webhook_id int not null references webhooks(id) ON DELETE CASCADE,
+	event_id text not null,
+	event_type text not null,
+	-- text rather than jsonb: the bytes sent, and signed, stay the same
+	payload text not null,
+	status text not null default 'pending',
+	attempts int not null default 0,
+	response_status int not null default 0,
+	last_error text not null default '',
+	next_attempt_at timestamptz not null default now(),
+	locked_until timestamptz,
+	delivered_at timestamptz,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE status IN ('pending', 'delivering');
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries(webhook_id, id);
@@ -0,0 +1,3 @@
+-- when a refresh was last queued, so songs whose refreshes keep failing
+-- wait for the next round instead of being queued on every tick
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS refresh_queued_at timestamptz;
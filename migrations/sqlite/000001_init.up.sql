@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS artists (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	artist varchar(50)
+);
+
+CREATE TABLE IF NOT EXISTS songs (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	artist_id int REFERENCES artists(id),
+	song varchar(100) not null,
+	release_date text,
+	lirycs text not null default '',
+	link varchar(200) not null default ''
+);
+
+CREATE INDEX IF NOT EXISTS songs_song_idx ON songs(song);
@@ -0,0 +1,2 @@
+DROP TABLE jobs;
+ALTER TABLE songs DROP COLUMN enrichment_status;
@@ -0,0 +1,17 @@
+ALTER TABLE songs ADD COLUMN enrichment_status varchar(20) not null default 'pending';
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	kind varchar(50) not null,
+	song_id int not null REFERENCES songs(id) ON DELETE CASCADE,
+	status varchar(20) not null default 'queued',
+	attempts int not null default 0,
+	max_attempts int not null default 5,
+	last_error text not null default '',
+	run_at timestamp not null,
+	locked_until timestamp,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+CREATE INDEX IF NOT EXISTS jobs_ready_idx ON jobs(status, run_at, id);
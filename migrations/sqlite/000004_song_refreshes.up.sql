@@ -0,0 +1,14 @@
+ALTER TABLE songs ADD COLUMN enriched_at timestamp;
+-- comma separated, SQLite has no arrays
+ALTER TABLE songs ADD COLUMN edited_fields text not null default '';
+
+CREATE INDEX IF NOT EXISTS songs_enriched_at_idx ON songs(enriched_at);
+
+CREATE TABLE IF NOT EXISTS song_refreshes (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	song_id int not null REFERENCES songs(id) ON DELETE CASCADE,
+	changes text not null,
+	created_at timestamp not null
+);
+
+CREATE INDEX IF NOT EXISTS song_refreshes_song_id_idx ON song_refreshes(song_id);
@@ -0,0 +1,3 @@
+-- Postgres gets a full-text index in this version. SQLite search falls back
+-- to LIKE, so only the artist join is indexed here.
+CREATE INDEX IF NOT EXISTS songs_artist_id_idx ON songs(artist_id);
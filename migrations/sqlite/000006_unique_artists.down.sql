@@ -0,0 +1 @@
+DROP INDEX IF EXISTS artists_artist_key;
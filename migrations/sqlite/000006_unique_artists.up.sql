@@ -0,0 +1,9 @@
+-- songs of duplicate artists move to the oldest row before the rest go
+UPDATE songs SET artist_id = (
+	SELECT min(keep.id) FROM artists dup JOIN artists keep ON keep.artist = dup.artist WHERE dup.id = songs.artist_id
+)
+WHERE artist_id IN (SELECT id FROM artists WHERE artist IS NOT NULL);
+
+DELETE FROM artists WHERE artist IS NOT NULL AND id NOT IN (SELECT min(id) FROM artists GROUP BY artist);
+
+CREATE UNIQUE INDEX IF NOT EXISTS artists_artist_key ON artists(artist);
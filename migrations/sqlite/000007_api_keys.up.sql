@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name varchar(100) not null,
+	prefix varchar(20) not null,
+	-- sha256 of the whole key, the key itself is never stored
+	key_hash char(64) not null UNIQUE,
+	-- comma separated, SQLite has no arrays
+	scopes text not null default '',
+	created_at timestamp not null,
+	last_used_at timestamp,
+	request_count integer not null default 0,
+	revoked_at timestamp
+);
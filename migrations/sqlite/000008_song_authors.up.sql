@@ -0,0 +1,3 @@
+-- who added and last edited a song, empty when auth was off
+ALTER TABLE songs ADD COLUMN created_by text not null default '';
+ALTER TABLE songs ADD COLUMN updated_by text not null default '';
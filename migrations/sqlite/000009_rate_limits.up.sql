@@ -0,0 +1,3 @@
+-- per class overrides of the configured limits, as JSON; buckets are only
+-- shared through Postgres
+ALTER TABLE api_keys ADD COLUMN rate_limits text not null default '{}';
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	at timestamp not null,
+	actor text not null,
+	request_id text not null default '',
+	source_ip text not null default '',
+	action text not null,
+	resource text not null,
+	-- text and no foreign key, entries outlive what they describe
+	resource_id text not null,
+	before text,
+	after text
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_resource_idx ON audit_log(resource, resource_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log(actor);
+CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log(at);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
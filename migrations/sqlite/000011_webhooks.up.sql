@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	url text not null,
+	-- comma separated, SQLite has no arrays
+	events text not null,
+	description text not null default '',
+	secret text not null,
+	active boolean not null default true,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	webhook_id integer not null references webhooks(id) ON DELETE CASCADE,
+	event_id text not null,
+	event_type text not null,
+	payload text not null,
+	status text not null default 'pending',
+	attempts integer not null default 0,
+	response_status integer not null default 0,
+	last_error text not null default '',
+	next_attempt_at timestamp not null,
+	locked_until timestamp,
+	delivered_at timestamp,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries(webhook_id, id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	-- set by the relay in commit order, id only gives the order of appends
+	seq integer UNIQUE,
+	event_id text not null,
+	type text not null,
+	data text not null,
+	created_at timestamp not null
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unsequenced_idx ON outbox(id) WHERE seq IS NULL;
+
+CREATE TABLE IF NOT EXISTS outbox_cursors (
+	sink text PRIMARY KEY,
+	seq integer not null default 0,
+	updated_at timestamp not null
+);
@@ -0,0 +1,25 @@
+-- Full-text index matching Postgres' songs_search_idx: whole words, case
+-- folded beyond ASCII. Diacritics are kept, as the 'simple' config does.
+CREATE VIRTUAL TABLE IF NOT EXISTS songs_search USING fts5(
+	song, lirycs,
+	content = 'songs', content_rowid = 'id',
+	tokenize = "unicode61 remove_diacritics 0"
+);
+
+INSERT INTO songs_search(songs_search) VALUES ('rebuild');
+
+CREATE TRIGGER IF NOT EXISTS songs_search_insert AFTER INSERT ON songs
+BEGIN
+	INSERT INTO songs_search(rowid, song, lirycs) VALUES (new.id, new.song, new.lirycs);
+END;
+
+CREATE TRIGGER IF NOT EXISTS songs_search_delete AFTER DELETE ON songs
+BEGIN
+	INSERT INTO songs_search(songs_search, rowid, song, lirycs) VALUES ('delete', old.id, old.song, old.lirycs);
+END;
+
+CREATE TRIGGER IF NOT EXISTS songs_search_update AFTER UPDATE OF song, lirycs ON songs
+BEGIN
+	INSERT INTO songs_search(songs_search, rowid, song, lirycs) VALUES ('delete', old.id, old.song, old.lirycs);
+	INSERT INTO songs_search(rowid, song, lirycs) VALUES (new.id, new.song, new.lirycs);
+END;